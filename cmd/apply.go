@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Plan actions, in the order the plan renders them.
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+)
+
+// Plan is the set of changes that converges the stored services and versions to a desired-state document.
+type Plan struct {
+	Prune   bool           `json:"prune"`
+	DryRun  bool           `json:"dry_run"`
+	Applied bool           `json:"applied"`
+	Summary map[string]int `json:"summary"`
+	Changes []PlanChange   `json:"changes"`
+}
+
+// PlanChange creates, updates or deletes one service or version. Diff lists the changed attributes of an update.
+type PlanChange struct {
+	Action  string      `json:"action"`
+	Kind    string      `json:"kind"`
+	Service string      `json:"service,omitempty"`
+	Name    string      `json:"name"`
+	Diff    []FieldDiff `json:"diff,omitempty"`
+}
+
+// FieldDiff is the stored and desired value of one attribute.
+type FieldDiff struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// ApplyCatalog converges the stored services and versions to the desired-state document in the request body.
+//
+// The document uses the catalog format of ImportCatalog, without users. Services and versions missing from the
+// database are created and differing ones are updated. With ?prune=true anything stored but absent from the
+// document is deleted. The plan is computed and applied in one transaction; with ?dry_run=true it is only
+// computed. The response is the plan as JSON, or as a diff when the client accepts text/plain.
+func ApplyCatalog(w http.ResponseWriter, r *http.Request) {
+	prune := r.URL.Query().Get("prune") == "true"
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeReadProblem(w, r, err, CodeInvalidJSON, "Failed to read document")
+		return
+	}
+	desired, rej := decodeCatalog(r.Header.Get("Content-Type"), body)
+	if rej != nil {
+		writeRejection(w, r, rej)
+		return
+	}
+	fieldErrors := validationErrors(&desired)
+	if len(desired.Users) > 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "users", Code: "unsupported", Message: "users are not managed by apply, use the import endpoint"})
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	plan := Plan{Prune: prune, DryRun: dryRun, Summary: map[string]int{}}
+	err = GetDBInstance().WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		var current []Service
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Versions").Find(&current).Error; err != nil {
+			return err
+		}
+		plan.Changes = computePlan(desired, current, prune)
+		if dryRun {
+			return nil
+		}
+		return executePlan(tx, plan.Changes, desired, current)
+	})
+	if err != nil {
+		if errors.Is(err, errRevisionMismatch) {
+			writeRevisionMismatch(w, r)
+			return
+		}
+		if writeUniqueViolation(w, r, err, "Document conflicts with stored data") {
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to apply document")
+		requestLogger(r).Error("Error applying document", "error", err)
+		return
+	}
+
+	plan.Applied = !dryRun && len(plan.Changes) > 0
+	for _, change := range plan.Changes {
+		plan.Summary[change.Action]++
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, renderPlan(plan))
+		return
+	}
+	setJSONHeader(w)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(plan)
+}
+
+// computePlan compares the desired document with the current services and their versions.
+//
+// Changes follow the order of the document, each service before its versions, and pruned services come last
+// in name order, each after its versions.
+func computePlan(desired Catalog, current []Service, prune bool) []PlanChange {
+	stored := make(map[string]Service)
+	for _, service := range current {
+		stored[service.ServiceName] = service
+	}
+
+	changes := []PlanChange{}
+	for _, item := range desired.Services {
+		service, exists := stored[item.Name]
+		delete(stored, item.Name)
+		if !exists {
+			changes = append(changes, PlanChange{Action: PlanCreate, Kind: "service", Name: item.Name})
+		} else if diff := diffFields("description", service.ServiceDescription, item.Description); len(diff) > 0 {
+			changes = append(changes, PlanChange{Action: PlanUpdate, Kind: "service", Name: item.Name, Diff: diff})
+		}
+
+		versions := make(map[string]ServiceVersion)
+		for _, version := range service.Versions {
+			versions[version.ServiceVersionName] = version
+		}
+		for _, versionItem := range item.Versions {
+			version, exists := versions[versionItem.Name]
+			delete(versions, versionItem.Name)
+			if !exists {
+				changes = append(changes, PlanChange{Action: PlanCreate, Kind: "version", Service: item.Name, Name: versionItem.Name})
+				continue
+			}
+			diff := append(diffFields("url", version.ServiceVersionURL, versionItem.URL),
+				diffFields("description", version.ServiceVersionDescription, versionItem.Description)...)
+			if len(diff) > 0 {
+				changes = append(changes, PlanChange{Action: PlanUpdate, Kind: "version", Service: item.Name, Name: versionItem.Name, Diff: diff})
+			}
+		}
+		if prune {
+			for _, name := range sortedKeys(versions) {
+				changes = append(changes, PlanChange{Action: PlanDelete, Kind: "version", Service: item.Name, Name: name})
+			}
+		}
+	}
+
+	if prune {
+		for _, name := range sortedKeys(stored) {
+			versions := stored[name].Versions
+			sort.Slice(versions, func(i, j int) bool { return versions[i].ServiceVersionName < versions[j].ServiceVersionName })
+			for _, version := range versions {
+				changes = append(changes, PlanChange{Action: PlanDelete, Kind: "version", Service: name, Name: version.ServiceVersionName})
+			}
+			changes = append(changes, PlanChange{Action: PlanDelete, Kind: "service", Name: name})
+		}
+	}
+	return changes
+}
+
+func diffFields(field, from, to string) []FieldDiff {
+	if from == to {
+		return nil
+	}
+	return []FieldDiff{{Field: field, From: from, To: to}}
+}
+
+// executePlan writes the changes computed from desired and current inside tx.
+func executePlan(tx *gorm.DB, changes []PlanChange, desired Catalog, current []Service) error {
+	services := make(map[string]Service)
+	versions := make(map[string]ServiceVersion)
+	for _, service := range current {
+		for _, version := range service.Versions {
+			versions[service.ServiceName+"\x00"+version.ServiceVersionName] = version
+		}
+		service.Versions = nil
+		services[service.ServiceName] = service
+	}
+	desiredServices := make(map[string]CatalogService)
+	desiredVersions := make(map[string]CatalogVersion)
+	for _, item := range desired.Services {
+		desiredServices[item.Name] = item
+		for _, versionItem := range item.Versions {
+			desiredVersions[item.Name+"\x00"+versionItem.Name] = versionItem
+		}
+	}
+
+	for _, change := range changes {
+		var err error
+		key := change.Service + "\x00" + change.Name
+
+		switch change.Kind + " " + change.Action {
+		case "service " + PlanCreate:
+			item := desiredServices[change.Name]
+			services[change.Name], err = createOrRestoreService(tx, item.Name, item.Description)
+		case "service " + PlanUpdate:
+			service := services[change.Name]
+			service.ServiceDescription = desiredServices[change.Name].Description
+			err = updateWithRevision(tx, &service, service.Revision)
+		case "service " + PlanDelete:
+			service := services[change.Name]
+			err = deleteWithRevision(tx, &service, service.Revision)
+		case "version " + PlanCreate:
+			item := desiredVersions[key]
+			err = createResource(tx, &ServiceVersion{
+				ServiceID:                 services[change.Service].ID,
+				ServiceVersionName:        item.Name,
+				ServiceVersionURL:         item.URL,
+				ServiceVersionDescription: item.Description,
+			})
+		case "version " + PlanUpdate:
+			version := versions[key]
+			version.ServiceVersionURL = desiredVersions[key].URL
+			version.ServiceVersionDescription = desiredVersions[key].Description
+			err = updateWithRevision(tx, &version, version.Revision)
+		case "version " + PlanDelete:
+			version := versions[key]
+			err = deleteWithRevision(tx, &version, version.Revision)
+		default:
+			err = fmt.Errorf("unknown plan change %s %s", change.Action, change.Kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderPlan formats a plan as a diff: "+" creates, "~" updates with their changed attributes and "-" deletes.
+func renderPlan(plan Plan) string {
+	if len(plan.Changes) == 0 {
+		return "No changes. The catalog matches the document.\n"
+	}
+
+	symbols := map[string]string{PlanCreate: "+", PlanUpdate: "~", PlanDelete: "-"}
+	var b strings.Builder
+	for _, change := range plan.Changes {
+		name := change.Name
+		if change.Service != "" {
+			name = change.Service + "/" + change.Name
+		}
+		fmt.Fprintf(&b, "%s %s %q\n", symbols[change.Action], change.Kind, name)
+		for _, diff := range change.Diff {
+			fmt.Fprintf(&b, "    %s: %q => %q\n", diff.Field, diff.From, diff.To)
+		}
+	}
+
+	summary := fmt.Sprintf("Plan: %d to create, %d to update, %d to delete.", plan.Summary[PlanCreate], plan.Summary[PlanUpdate], plan.Summary[PlanDelete])
+	if plan.Applied {
+		summary = fmt.Sprintf("Applied: %d created, %d updated, %d deleted.", plan.Summary[PlanCreate], plan.Summary[PlanUpdate], plan.Summary[PlanDelete])
+	}
+	fmt.Fprintf(&b, "\n%s\n", summary)
+	return b.String()
+}
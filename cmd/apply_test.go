@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputePlan(t *testing.T) {
+	current := []Service{
+		{ServiceName: "Billing", ServiceDescription: "Invoices", Versions: []ServiceVersion{
+			{ServiceVersionName: "v1", ServiceVersionURL: "http://billing/v1"},
+			{ServiceVersionName: "v0"},
+		}},
+		{ServiceName: "Legacy", Versions: []ServiceVersion{{ServiceVersionName: "v9"}}},
+	}
+	desired := Catalog{Services: []CatalogService{
+		{Name: "Billing", Description: "Payments", Versions: []CatalogVersion{
+			{Name: "v1", URL: "http://billing/v1"},
+			{Name: "v2", URL: "http://billing/v2"},
+		}},
+		{Name: "Search"},
+	}}
+
+	tests := []struct {
+		name    string
+		prune   bool
+		desired Catalog
+		changes []string
+	}{
+		{"WithoutPrune", false, desired, []string{"update service Billing", "create version Billing/v2", "create service Search"}},
+		{"WithPrune", true, desired, []string{"update service Billing", "create version Billing/v2", "delete version Billing/v0", "create service Search", "delete version Legacy/v9", "delete service Legacy"}},
+		{"NoChanges", false, Catalog{Services: []CatalogService{{Name: "Legacy"}}}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := []string{}
+			for _, change := range computePlan(tt.desired, current, tt.prune) {
+				name := change.Name
+				if change.Service != "" {
+					name = change.Service + "/" + name
+				}
+				changes = append(changes, change.Action+" "+change.Kind+" "+name)
+			}
+			assert.Equal(t, tt.changes, changes)
+		})
+	}
+}
+
+func TestRenderPlan(t *testing.T) {
+	plan := Plan{
+		Summary: map[string]int{PlanCreate: 1, PlanUpdate: 1},
+		Changes: []PlanChange{
+			{Action: PlanUpdate, Kind: "service", Name: "Billing", Diff: []FieldDiff{{Field: "description", From: "Invoices", To: "Payments"}}},
+			{Action: PlanCreate, Kind: "version", Service: "Billing", Name: "v2"},
+		},
+	}
+
+	expected := `~ service "Billing"
+    description: "Invoices" => "Payments"
++ version "Billing/v2"
+
+Plan: 1 to create, 1 to update, 0 to delete.
+`
+	assert.Equal(t, expected, renderPlan(plan))
+	assert.Equal(t, "No changes. The catalog matches the document.\n", renderPlan(Plan{}))
+}
+
+func TestRunApply(t *testing.T) {
+	var gotQuery, gotContentType, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery, gotContentType, gotAuth = r.URL.RawQuery, r.Header.Get("Content-Type"), r.Header.Get("Authorization")
+		if r.Header.Get("Authorization") == "" {
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "Authorization token not provided")
+			return
+		}
+		json.NewEncoder(w).Encode(Plan{DryRun: true, Summary: map[string]int{PlanCreate: 1}, Changes: []PlanChange{{Action: PlanCreate, Kind: "service", Name: "Search"}}})
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "catalog.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("services:\n  - name: Search\n"), 0o600))
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand([]string{"apply", "-f", file, "-server", server.URL, "-token", "abc", "-prune", "-dry-run"}, &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "dry_run=true&prune=true", gotQuery)
+	assert.Equal(t, YAMLContentType, gotContentType)
+	assert.Equal(t, "Bearer abc", gotAuth)
+	assert.Contains(t, stdout.String(), `+ service "Search"`)
+
+	stdout.Reset()
+	code = runCommand([]string{"apply", "-f", file, "-server", server.URL, "-token", ""}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr.String(), "Authorization token not provided")
+
+	assert.Equal(t, 2, runCommand([]string{"apply"}, &stdout, &stderr))
+	assert.Equal(t, 2, runCommand([]string{"destroy"}, &stdout, &stderr))
+}
+
+func TestApplyCatalog(t *testing.T) {
+	db := GetDBInstance()
+	service := Service{ServiceName: "ApplyService", ServiceDescription: "Old"}
+	assert.NoError(t, db.Create(&service).Error)
+	assert.NoError(t, db.Create(&ServiceVersion{ServiceID: service.ID, ServiceVersionName: "v1"}).Error)
+
+	document := "services:\n  - name: ApplyService\n    description: New\n    versions:\n      - name: v2\n        url: http://apply.example.com\n"
+
+	tests := []struct {
+		name    string
+		query   string
+		applied bool
+		summary map[string]int
+	}{
+		{"DryRun", "?dry_run=true", false, map[string]int{PlanUpdate: 1, PlanCreate: 1}},
+		{"Apply", "", true, map[string]int{PlanUpdate: 1, PlanCreate: 1}},
+		{"Idempotent", "", false, map[string]int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/apply"+tt.query, strings.NewReader(document))
+			req.Header.Set("Content-Type", YAMLContentType)
+			rr := httptest.NewRecorder()
+			ApplyCatalog(rr, req)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			var plan Plan
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &plan))
+			assert.Equal(t, tt.applied, plan.Applied)
+			assert.Equal(t, tt.summary, plan.Summary)
+		})
+	}
+
+	// Pruning removes v1, and with it every other service missing from the document; check only ours
+	req := httptest.NewRequest("POST", "/v1/apply?prune=true&dry_run=true", strings.NewReader(document))
+	req.Header.Set("Content-Type", YAMLContentType)
+	req.Header.Set("Accept", "text/plain")
+	rr := httptest.NewRecorder()
+	ApplyCatalog(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `- version "ApplyService/v1"`)
+
+	var stored Service
+	assert.NoError(t, db.Preload("Versions").Where("service_name = ?", "ApplyService").First(&stored).Error)
+	assert.Equal(t, "New", stored.ServiceDescription)
+	assert.Len(t, stored.Versions, 2)
+}
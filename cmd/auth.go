@@ -1,7 +1,8 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -15,23 +16,28 @@ type CustomClaims struct {
 	jwt.RegisteredClaims
 }
 
-var JwtSecretKey = []byte("secret") //Note - This is a sample key. For production, use a secure key.
+// JwtSecretKey signs and verifies the tokens, and TokenTTL is their lifetime. Both are set from AuthConfig at
+// startup; production refuses the sample key.
+var JwtSecretKey = []byte(DefaultJWTSecret)
+var TokenTTL = DefaultTokenTTL
 var AllowedRoles = []string{"admin", "user"}
 var Permissions = map[string]map[string]bool{
 	"admin": {
 		"GET":    true,
 		"POST":   true,
 		"PUT":    true,
+		"PATCH":  true,
 		"DELETE": true,
 	},
 	"user": {
 		"GET":    true,
 		"POST":   false,
 		"PUT":    false,
+		"PATCH":  false,
 		"DELETE": false,
 	},
 }
-var whitelistedPaths = []string{"/v1/auth"}
+var whitelistedPaths = []string{"/v1/auth", "/livez", "/readyz", "/health"}
 
 // UserAuthentication is a handler function that authenticates a user based on the provided username and password.
 func UserAuthentication(w http.ResponseWriter, r *http.Request) {
@@ -40,8 +46,7 @@ func UserAuthentication(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &creds) {
 		return
 	}
 
@@ -49,30 +54,33 @@ func UserAuthentication(w http.ResponseWriter, r *http.Request) {
 	password := creds.Password
 
 	if username == "" || password == "" {
-		http.Error(w, "Invalid username or password", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "Username and password are required")
 		return
 	}
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 	var user User
 	if err := db.Where("username = ? AND password = ?", username, password).First(&user).Error; err != nil {
-		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		recordAuth(AuthLogin, AuthFailure)
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid username or password")
 		return
 	}
 
 	claims := CustomClaims{
 		Role: user.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * 24 * time.Hour)),
+			Subject:   user.Username,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(TokenTTL)),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(JwtSecretKey)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to generate token")
 		return
 	}
 
+	recordAuth(AuthLogin, AuthSuccess)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"token":"` + tokenString + `"}`))
@@ -90,13 +98,27 @@ func RoleBasedMiddleware(next http.Handler) http.Handler {
 		}
 
 		tokenString := r.Header.Get("Authorization")
+		// A verified client certificate authenticates callers that send no token
+		if name, ok := clientCertificateName(r); ok && tokenString == "" {
+			role, ok := ClientCertificateRoles[name]
+			if !ok {
+				recordAuth(AuthCertificate, AuthFailure)
+				writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "Client certificate "+name+" is not mapped to a role")
+				return
+			}
+			recordAuth(AuthCertificate, AuthSuccess)
+			authorize(w, r, next, role, SubjectCertificate, name)
+			return
+		}
 		if tokenString == "" {
-			http.Error(w, "Authorization token not provided", http.StatusUnauthorized)
+			recordAuth(AuthToken, AuthFailure)
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "Authorization token not provided")
 			return
 		}
 
 		if !strings.HasPrefix(tokenString, "Bearer ") {
-			http.Error(w, "Invalid authorization header format, requires Bearer prefix", http.StatusUnauthorized)
+			recordAuth(AuthToken, AuthFailure)
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid authorization header format, requires Bearer prefix")
 			return
 		}
 		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
@@ -108,7 +130,14 @@ func RoleBasedMiddleware(next http.Handler) http.Handler {
 			return JwtSecretKey, nil
 		})
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Token parsing failed: %v", err), http.StatusUnauthorized)
+			// The parser error is logged rather than returned so token internals never reach the client
+			requestLogger(r).Error("Token parsing failed", "error", err)
+			recordAuth(AuthToken, AuthFailure)
+			if errors.Is(err, jwt.ErrTokenExpired) {
+				writeProblem(w, r, http.StatusUnauthorized, CodeTokenExpired, "Token has expired")
+				return
+			}
+			writeProblem(w, r, http.StatusUnauthorized, CodeInvalidToken, "Invalid token")
 			return
 		}
 
@@ -120,21 +149,60 @@ func RoleBasedMiddleware(next http.Handler) http.Handler {
 			} else if !token.Valid {
 				errMsg = "Invalid token"
 			}
-			http.Error(w, errMsg, http.StatusUnauthorized)
+			recordAuth(AuthToken, AuthFailure)
+			writeProblem(w, r, http.StatusUnauthorized, CodeInvalidToken, errMsg)
 			return
 		}
 
-		// Check if the role has the required permission
-		method := r.Method
-		if !checkPermission(claims.Role, method) {
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-
-		next.ServeHTTP(w, r)
+		recordAuth(AuthToken, AuthSuccess)
+		authorize(w, r, next, claims.Role, SubjectUser, claims.Subject)
 	})
 }
 
+// authorize serves an authenticated request with next if role may use its method, and with 403 otherwise.
+func authorize(w http.ResponseWriter, r *http.Request, next http.Handler, role, source, name string) {
+	setAuthInfo(r.Context(), role, name)
+
+	// Check if the role has the required permission
+	if !checkPermission(role, r.Method) {
+		writeProblem(w, r, http.StatusForbidden, CodeForbidden, "Role "+role+" is not allowed to "+r.Method+" this resource")
+		return
+	}
+
+	// The source keeps a certificate named like a user from sharing the rate limits and idempotency keys of that user
+	subject := ""
+	if name != "" {
+		subject = source + ":" + name
+	}
+	ctx := context.WithValue(r.Context(), roleKey{}, role)
+	next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, subjectKey{}, subject)))
+}
+
+type roleKey struct{}
+
+// RoleFromContext returns the role of the authenticated caller stored by RoleBasedMiddleware, or "" if there is none.
+func RoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey{}).(string)
+	return role
+}
+
+type subjectKey struct{}
+
+// Sources of the subject of a caller.
+const (
+	// SubjectUser prefixes the username a token was issued to.
+	SubjectUser = "user"
+	// SubjectCertificate prefixes the common name of a client certificate.
+	SubjectCertificate = "cert"
+)
+
+// SubjectFromContext returns the subject of the token or client certificate of the authenticated caller stored by
+// RoleBasedMiddleware, prefixed with its source as in user:alice or cert:billing-sync, or "" if there is none.
+func SubjectFromContext(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectKey{}).(string)
+	return subject
+}
+
 // CheckPermission is a helper function to check if a role has permission to perform an action.
 func checkPermission(role, action string) bool {
 	actions, exists := Permissions[role]
@@ -96,6 +96,21 @@ func TestRoleBasedMiddleware(t *testing.T) {
 	}
 }
 
+func TestRoleBasedMiddlewareProblemDetails(t *testing.T) {
+	req, err := http.NewRequest("GET", "/v1/services", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+
+	rr := httptest.NewRecorder()
+	handler := RoleBasedMiddleware(http.HandlerFunc(GetServices))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `"code":"invalid_token"`)
+	assert.NotContains(t, rr.Body.String(), "Token parsing failed")
+}
+
 func TestAuthFlow(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxBulkItems caps the number of items accepted by a single bulk request.
+const MaxBulkItems = 500
+
+// NDJSONContentType is the media type of bulk responses, one JSON result per line.
+const NDJSONContentType = "application/x-ndjson"
+
+// Error codes specific to bulk requests.
+const (
+	CodeBatchTooLarge = "batch_too_large"
+	CodeRolledBack    = "rolled_back"
+)
+
+// Bulk modes selected with ?mode=.
+const (
+	// BulkAtomic applies every item in one transaction, so either all items are stored or none is.
+	BulkAtomic = "atomic"
+	// BulkBestEffort applies each item in its own transaction and keeps the ones that succeed.
+	BulkBestEffort = "best_effort"
+)
+
+// BulkResult reports the outcome of one item of a bulk request, in the order the items were sent.
+type BulkResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	ETag   string      `json:"etag,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  *Problem    `json:"error,omitempty"`
+}
+
+// bulkAction describes one bulk operation on items of type T.
+type bulkAction[T any] struct {
+	// check reports problems with a decoded item that the validate tags cannot express.
+	check func(item *T) []FieldError
+	// apply writes a valid item inside tx.
+	apply func(tx *gorm.DB, item *T) (BulkResult, error)
+}
+
+// bulkDeleteItem identifies a resource to delete, together with the revision the client last saw.
+type bulkDeleteItem struct {
+	ID       uint `json:"id" validate:"required"`
+	Revision uint `json:"revision" validate:"required"`
+}
+
+// BulkCreateServices creates every service in the JSON array body.
+func BulkCreateServices(w http.ResponseWriter, r *http.Request) {
+	bulkHandler(w, r, bulkAction[Service]{
+		apply: func(tx *gorm.DB, service *Service) (BulkResult, error) {
+			if err := createResource(tx, service); err != nil {
+				return BulkResult{}, err
+			}
+			return BulkResult{Status: http.StatusCreated, ETag: service.ETag(), Data: service}, nil
+		},
+	})
+}
+
+// BulkUpdateServices replaces every service in the JSON array body. Each item needs its id and current revision.
+func BulkUpdateServices(w http.ResponseWriter, r *http.Request) {
+	bulkHandler(w, r, bulkAction[Service]{
+		check: func(service *Service) []FieldError { return requireIDAndRevision(service.ID, service.Revision) },
+		apply: func(tx *gorm.DB, service *Service) (BulkResult, error) {
+			var existing Service
+			if err := lockForUpdate(tx, &existing, service.ID, service.Revision); err != nil {
+				return BulkResult{}, err
+			}
+			service.CreatedAt = existing.CreatedAt
+			if err := updateWithRevision(tx, service, existing.Revision); err != nil {
+				return BulkResult{}, err
+			}
+			return BulkResult{Status: http.StatusOK, ETag: service.ETag(), Data: service}, nil
+		},
+	})
+}
+
+// BulkDeleteServices soft-deletes every service listed in the JSON array body of {"id", "revision"} items.
+func BulkDeleteServices(w http.ResponseWriter, r *http.Request) {
+	bulkHandler(w, r, bulkDeleteAction[Service]())
+}
+
+// BulkCreateServiceVersions creates every service version in the JSON array body.
+func BulkCreateServiceVersions(w http.ResponseWriter, r *http.Request) {
+	bulkHandler(w, r, bulkAction[ServiceVersion]{
+		check: func(version *ServiceVersion) []FieldError {
+			if version.ServiceID == 0 {
+				return []FieldError{{Field: "service_id", Code: "required", Message: "is required"}}
+			}
+			return nil
+		},
+		apply: func(tx *gorm.DB, version *ServiceVersion) (BulkResult, error) {
+			var service Service
+			if err := tx.First(&service, version.ServiceID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return BulkResult{}, &rejection{status: http.StatusNotFound, code: CodeNotFound, detail: "Service not found"}
+				}
+				return BulkResult{}, err
+			}
+			if err := createResource(tx, version); err != nil {
+				return BulkResult{}, err
+			}
+			return BulkResult{Status: http.StatusCreated, ETag: version.ETag(), Data: version}, nil
+		},
+	})
+}
+
+// BulkUpdateServiceVersions updates the name, URL and description of every service version in the JSON array body.
+// Each item needs its id and current revision.
+func BulkUpdateServiceVersions(w http.ResponseWriter, r *http.Request) {
+	bulkHandler(w, r, bulkAction[ServiceVersion]{
+		check: func(version *ServiceVersion) []FieldError { return requireIDAndRevision(version.ID, version.Revision) },
+		apply: func(tx *gorm.DB, version *ServiceVersion) (BulkResult, error) {
+			var existing ServiceVersion
+			if err := lockForUpdate(tx, &existing, version.ID, version.Revision); err != nil {
+				return BulkResult{}, err
+			}
+			existing.ServiceVersionName = version.ServiceVersionName
+			existing.ServiceVersionDescription = version.ServiceVersionDescription
+			existing.ServiceVersionURL = version.ServiceVersionURL
+			if err := updateWithRevision(tx, &existing, existing.Revision); err != nil {
+				return BulkResult{}, err
+			}
+			return BulkResult{Status: http.StatusOK, ETag: existing.ETag(), Data: &existing}, nil
+		},
+	})
+}
+
+// BulkDeleteServiceVersions soft-deletes every service version listed in the JSON array body of {"id", "revision"} items.
+func BulkDeleteServiceVersions(w http.ResponseWriter, r *http.Request) {
+	bulkHandler(w, r, bulkDeleteAction[ServiceVersion]())
+}
+
+// BulkCreateUsers creates every user in the JSON array body.
+func BulkCreateUsers(w http.ResponseWriter, r *http.Request) {
+	bulkHandler(w, r, bulkAction[User]{
+		apply: func(tx *gorm.DB, user *User) (BulkResult, error) {
+			if err := createResource(tx, user); err != nil {
+				return BulkResult{}, err
+			}
+			return BulkResult{Status: http.StatusCreated, ETag: user.ETag(), Data: user}, nil
+		},
+	})
+}
+
+// BulkUpdateUsers replaces every user in the JSON array body. Each item needs its id and current revision.
+func BulkUpdateUsers(w http.ResponseWriter, r *http.Request) {
+	bulkHandler(w, r, bulkAction[User]{
+		check: func(user *User) []FieldError { return requireIDAndRevision(user.ID, user.Revision) },
+		apply: func(tx *gorm.DB, user *User) (BulkResult, error) {
+			var existing User
+			if err := lockForUpdate(tx, &existing, user.ID, user.Revision); err != nil {
+				return BulkResult{}, err
+			}
+			user.CreatedAt = existing.CreatedAt
+			if err := updateWithRevision(tx, user, existing.Revision); err != nil {
+				return BulkResult{}, err
+			}
+			return BulkResult{Status: http.StatusOK, ETag: user.ETag(), Data: user}, nil
+		},
+	})
+}
+
+// BulkDeleteUsers soft-deletes every user listed in the JSON array body of {"id", "revision"} items.
+func BulkDeleteUsers(w http.ResponseWriter, r *http.Request) {
+	bulkHandler(w, r, bulkDeleteAction[User]())
+}
+
+func bulkDeleteAction[M any]() bulkAction[bulkDeleteItem] {
+	return bulkAction[bulkDeleteItem]{
+		apply: func(tx *gorm.DB, item *bulkDeleteItem) (BulkResult, error) {
+			var existing M
+			if err := lockForUpdate(tx, &existing, item.ID, item.Revision); err != nil {
+				return BulkResult{}, err
+			}
+			if err := deleteWithRevision(tx, any(&existing).(revisioned), item.Revision); err != nil {
+				return BulkResult{}, err
+			}
+			return BulkResult{Status: http.StatusOK}, nil
+		},
+	}
+}
+
+// requireIDAndRevision stands in for the ID check and If-Match header of single updates.
+func requireIDAndRevision(id, revision uint) []FieldError {
+	var fieldErrors []FieldError
+	if id == 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "id", Code: "required", Message: "is required"})
+	}
+	if revision == 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "revision", Code: "required", Message: "is required"})
+	}
+	return fieldErrors
+}
+
+// lockForUpdate loads the row into model and locks it, failing with 412 unless it still has the given revision.
+func lockForUpdate(tx *gorm.DB, model interface{}, id, revision uint) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(model, id).Error; err != nil {
+		return err
+	}
+	current := model.(revisioned)
+	if current.currentRevision() != revision {
+		return &rejection{status: http.StatusPreconditionFailed, code: CodePreconditionFailed, detail: "Resource has been modified, current ETag is " + current.ETag()}
+	}
+	return nil
+}
+
+// bulkHandler runs a bulk request whose body is a JSON array of items.
+//
+// Every item is decoded and validated before anything is written. In atomic mode, the default, one invalid
+// or failing item rolls back the whole batch and every other item is reported as rolled_back. In best_effort
+// mode each item is written in its own transaction and its result is streamed as soon as it is known.
+// The response is 207 Multi-Status with one BulkResult per line, in request order.
+func bulkHandler[T any](w http.ResponseWriter, r *http.Request, action bulkAction[T]) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = BulkAtomic
+	}
+	if mode != BulkAtomic && mode != BulkBestEffort {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "mode must be "+BulkAtomic+" or "+BulkBestEffort,
+			FieldError{Field: "mode", Code: CodeInvalidParameter, Message: "must be " + BulkAtomic + " or " + BulkBestEffort})
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeReadProblem(w, r, err, CodeInvalidJSON, "Invalid JSON payload")
+		return
+	}
+	var rawItems []json.RawMessage
+	if err := json.Unmarshal(body, &rawItems); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "Bulk payload must be a JSON array")
+		return
+	}
+	if len(rawItems) == 0 {
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "Bulk payload must contain at least one item")
+		return
+	}
+	if len(rawItems) > MaxBulkItems {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, CodeBatchTooLarge, fmt.Sprintf("Bulk payload may contain at most %d items", MaxBulkItems))
+		return
+	}
+
+	items := make([]T, len(rawItems))
+	results := make([]*BulkResult, len(rawItems))
+	invalid := false
+	for i, raw := range rawItems {
+		rej := decodeJSON(raw, &items[i])
+		if rej == nil {
+			fieldErrors := validationErrors(&items[i])
+			if action.check != nil {
+				fieldErrors = append(fieldErrors, action.check(&items[i])...)
+			}
+			if len(fieldErrors) > 0 {
+				rej = &rejection{status: http.StatusUnprocessableEntity, code: CodeValidationFailed, detail: "Payload validation failed", fieldErrors: fieldErrors}
+			}
+		}
+		if rej != nil {
+			results[i] = bulkFailure(r, i, rej)
+			invalid = true
+		}
+	}
+
+	stream := newBulkStream(w)
+
+	if mode == BulkBestEffort {
+		for i := range items {
+			if results[i] == nil {
+				var result BulkResult
+				err := GetDBInstance().WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+					var err error
+					result, err = action.apply(tx, &items[i])
+					return err
+				})
+				if err != nil {
+					results[i] = bulkFailure(r, i, err)
+				} else {
+					result.Index = i
+					results[i] = &result
+				}
+			}
+			stream.send(results[i])
+		}
+		return
+	}
+
+	failed := -1
+	if !invalid {
+		err := GetDBInstance().WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+			for i := range items {
+				result, err := action.apply(tx, &items[i])
+				if err != nil {
+					results[i] = bulkFailure(r, i, err)
+					failed = i
+					return err
+				}
+				result.Index = i
+				results[i] = &result
+			}
+			return nil
+		})
+		if err != nil && failed < 0 {
+			// The commit itself failed, so no item was stored
+			for i := range results {
+				results[i] = bulkFailure(r, i, err)
+			}
+		}
+	}
+
+	for i, result := range results {
+		if (invalid || failed >= 0) && (result == nil || result.Error == nil) {
+			result = bulkFailure(r, i, &rejection{status: http.StatusFailedDependency, code: CodeRolledBack, detail: "Batch was rolled back because another item failed"})
+		}
+		stream.send(result)
+	}
+}
+
+// bulkFailure maps an item error onto its result, the way the single-item handlers map it onto a response.
+func bulkFailure(r *http.Request, index int, err error) *BulkResult {
+	var problem Problem
+	var rej *rejection
+	switch {
+	case errors.As(err, &rej):
+		problem = newProblem(r, rej.status, rej.code, rej.detail, rej.fieldErrors...)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		problem = newProblem(r, http.StatusNotFound, CodeNotFound, "Resource not found")
+	case errors.Is(err, errRevisionMismatch):
+		problem = newProblem(r, http.StatusPreconditionFailed, CodePreconditionFailed, "Resource was modified concurrently")
+	default:
+		if fieldErrors, ok := uniqueViolationFields(err); ok {
+			problem = newProblem(r, http.StatusConflict, CodeConflict, "Resource already exists", fieldErrors...)
+			break
+		}
+		problem = newProblem(r, http.StatusInternalServerError, CodeInternal, "Failed to process item")
+		requestLogger(r).Error("Error processing bulk item", "index", index, "error", err)
+	}
+	return &BulkResult{Index: index, Status: problem.Status, Error: &problem}
+}
+
+// bulkStream writes bulk results as newline-delimited JSON, flushing after every line.
+type bulkStream struct {
+	controller *http.ResponseController
+	encoder    *json.Encoder
+}
+
+func newBulkStream(w http.ResponseWriter) *bulkStream {
+	w.Header().Set("Content-Type", NDJSONContentType)
+	w.WriteHeader(http.StatusMultiStatus)
+	return &bulkStream{controller: http.NewResponseController(w), encoder: json.NewEncoder(w)}
+}
+
+func (s *bulkStream) send(result *BulkResult) {
+	if err := s.encoder.Encode(result); err != nil {
+		slog.Error("Encoding error", "error", err)
+		return
+	}
+	// Not every writer supports flushing; the results are then delivered when the handler returns
+	s.controller.Flush()
+}
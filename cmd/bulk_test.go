@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readBulkResults decodes an NDJSON bulk response into its per-item results.
+func readBulkResults(t *testing.T, body string) []BulkResult {
+	var results []BulkResult
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var result BulkResult
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &result))
+		results = append(results, result)
+	}
+	return results
+}
+
+func bulkStatuses(results []BulkResult) []int {
+	statuses := make([]int, len(results))
+	for i, result := range results {
+		statuses[i] = result.Status
+	}
+	return statuses
+}
+
+func TestBulkRequestRejected(t *testing.T) {
+	tooMany := "[" + strings.TrimSuffix(strings.Repeat(`{"service_name": "x"},`, MaxBulkItems+1), ",") + "]"
+
+	tests := []struct {
+		name       string
+		url        string
+		payload    string
+		statusCode int
+		code       string
+	}{
+		{"NotAnArray", "/v1/services/bulk", `{"service_name": "Service 1"}`, http.StatusBadRequest, CodeInvalidJSON},
+		{"Empty", "/v1/services/bulk", `[]`, http.StatusUnprocessableEntity, CodeValidationFailed},
+		{"TooLarge", "/v1/services/bulk", tooMany, http.StatusRequestEntityTooLarge, CodeBatchTooLarge},
+		{"UnknownMode", "/v1/services/bulk?mode=sometimes", `[{"service_name": "Service 1"}]`, http.StatusBadRequest, CodeInvalidParameter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.url, strings.NewReader(tt.payload))
+			rr := httptest.NewRecorder()
+			BulkCreateServices(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			assert.Contains(t, rr.Body.String(), `"code":"`+tt.code+`"`)
+		})
+	}
+}
+
+func TestBulkAtomicInvalidItemRollsBack(t *testing.T) {
+	payload := `[{"service_name": "Valid"}, {"service_name": ""}, {"service_name": "Other", "owner": "alice"}]`
+	req := httptest.NewRequest("POST", "/v1/services/bulk", strings.NewReader(payload))
+	rr := httptest.NewRecorder()
+	BulkCreateServices(rr, req)
+
+	assert.Equal(t, http.StatusMultiStatus, rr.Code)
+	assert.Equal(t, NDJSONContentType, rr.Header().Get("Content-Type"))
+
+	results := readBulkResults(t, rr.Body.String())
+	assert.Equal(t, []int{http.StatusFailedDependency, http.StatusUnprocessableEntity, http.StatusUnprocessableEntity}, bulkStatuses(results))
+	assert.Equal(t, CodeRolledBack, results[0].Error.Code)
+	assert.Equal(t, "service_name", results[1].Error.Errors[0].Field)
+	assert.Equal(t, CodeUnknownField, results[2].Error.Errors[0].Code)
+}
+
+func TestBulkHandlers(t *testing.T) {
+	db := GetDBInstance()
+	service := Service{ServiceName: "BulkUpdateService"}
+	assert.NoError(t, db.Create(&service).Error)
+	version := ServiceVersion{ServiceID: service.ID, ServiceVersionName: "bulk-v1"}
+	assert.NoError(t, db.Create(&version).Error)
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		handler  http.HandlerFunc
+		payload  string
+		statuses []int
+	}{
+		{"CreateServicesAtomic", "POST", "/v1/services/bulk", BulkCreateServices, `[{"service_name": "Bulk 1"}, {"service_name": "Bulk 2"}]`, []int{201, 201}},
+		{"CreateServicesAtomicConflict", "POST", "/v1/services/bulk", BulkCreateServices, `[{"service_name": "Bulk 3"}, {"service_name": "Bulk 1"}]`, []int{424, 409}},
+		{"CreateServicesBestEffort", "POST", "/v1/services/bulk?mode=best_effort", BulkCreateServices, `[{"service_name": "Bulk 4"}, {"service_name": "Bulk 1"}, {"service_name": ""}]`, []int{201, 409, 422}},
+		{"CreateVersionsMissingService", "POST", "/v1/service_versions/bulk?mode=best_effort", BulkCreateServiceVersions, fmt.Sprintf(`[{"service_id": %d, "service_version_name": "bulk-v2"}, {"service_id": 100000, "service_version_name": "v1"}, {"service_version_name": "v1"}]`, service.ID), []int{201, 404, 422}},
+		{"UpdateVersionStaleRevision", "PUT", "/v1/service_versions/bulk", BulkUpdateServiceVersions, fmt.Sprintf(`[{"id": %d, "revision": 5, "service_version_name": "bulk-v1"}]`, version.ID), []int{412}},
+		{"UpdateVersionMissingRevision", "PUT", "/v1/service_versions/bulk", BulkUpdateServiceVersions, fmt.Sprintf(`[{"id": %d, "service_version_name": "bulk-v1"}]`, version.ID), []int{422}},
+		{"UpdateVersion", "PUT", "/v1/service_versions/bulk", BulkUpdateServiceVersions, fmt.Sprintf(`[{"id": %d, "revision": 1, "service_version_name": "bulk-v1-renamed"}]`, version.ID), []int{200}},
+		{"UpdateService", "PUT", "/v1/services/bulk", BulkUpdateServices, fmt.Sprintf(`[{"id": %d, "revision": 1, "service_name": "BulkUpdateService", "service_description": "Updated"}]`, service.ID), []int{200}},
+		{"DeleteVersionsBestEffort", "DELETE", "/v1/service_versions/bulk?mode=best_effort", BulkDeleteServiceVersions, fmt.Sprintf(`[{"id": %d, "revision": 2}, {"id": 100000, "revision": 1}]`, version.ID), []int{200, 404}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.payload))
+			rr := httptest.NewRecorder()
+			tt.handler(rr, req)
+
+			assert.Equal(t, http.StatusMultiStatus, rr.Code)
+			assert.Equal(t, tt.statuses, bulkStatuses(readBulkResults(t, rr.Body.String())))
+		})
+	}
+
+	// The atomic conflict must not have left its first item behind
+	var count int64
+	db.Model(&Service{}).Where("service_name = ?", "Bulk 3").Count(&count)
+	assert.Equal(t, int64(0), count)
+}
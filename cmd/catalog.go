@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// Media types accepted by import and produced by export.
+const (
+	YAMLContentType = "application/yaml"
+	CSVContentType  = "text/csv"
+)
+
+// Conflict strategies selected with ?on_conflict=, applied when an imported item differs from the stored one.
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictFail      = "fail"
+)
+
+// Actions reported for every imported item.
+const (
+	ActionCreated   = "created"
+	ActionUpdated   = "updated"
+	ActionUnchanged = "unchanged"
+	ActionSkipped   = "skipped"
+	ActionConflict  = "conflict"
+)
+
+// errDryRun rolls back an import transaction after the report has been built.
+var errDryRun = errors.New("dry run")
+
+// Catalog is the portable form of the dashboard contents. It refers to everything by name,
+// so it can be moved between environments and kept in git.
+type Catalog struct {
+	Services []CatalogService `json:"services" yaml:"services" validate:"unique=Name,dive"`
+	Users    []CatalogUser    `json:"users,omitempty" yaml:"users,omitempty" validate:"unique=Username,dive"`
+}
+
+type CatalogService struct {
+	Name        string           `json:"name" yaml:"name" validate:"required,max=255"`
+	Description string           `json:"description,omitempty" yaml:"description,omitempty" validate:"max=4096"`
+	Versions    []CatalogVersion `json:"versions,omitempty" yaml:"versions,omitempty" validate:"unique=Name,dive"`
+}
+
+type CatalogVersion struct {
+	Name        string `json:"name" yaml:"name" validate:"required,max=255"`
+	URL         string `json:"url,omitempty" yaml:"url,omitempty" validate:"omitempty,service_url,max=2048"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty" validate:"max=4096"`
+}
+
+// CatalogUser never carries a password on export. On import a password is only needed to create the user.
+type CatalogUser struct {
+	Username  string `json:"username" yaml:"username" validate:"required,max=255"`
+	Password  string `json:"password,omitempty" yaml:"password,omitempty" validate:"omitempty,min=8,max=255"`
+	Role      string `json:"role" yaml:"role" validate:"required,role"`
+	FirstName string `json:"first_name,omitempty" yaml:"first_name,omitempty" validate:"max=255"`
+	LastName  string `json:"last_name,omitempty" yaml:"last_name,omitempty" validate:"max=255"`
+	Email     string `json:"email,omitempty" yaml:"email,omitempty" validate:"omitempty,email,max=255"`
+}
+
+// ImportReport lists what an import changed, or would change in a dry run.
+type ImportReport struct {
+	DryRun     bool           `json:"dry_run"`
+	OnConflict string         `json:"on_conflict"`
+	Summary    map[string]int `json:"summary"`
+	Changes    []ImportChange `json:"changes"`
+}
+
+// ImportChange is the outcome for one service, version or user. Fields lists the attributes that differ.
+type ImportChange struct {
+	Kind    string   `json:"kind"`
+	Service string   `json:"service,omitempty"`
+	Name    string   `json:"name"`
+	Action  string   `json:"action"`
+	Fields  []string `json:"fields,omitempty"`
+}
+
+// ExportCatalog writes every service with its versions, and with ?include_users=true every user without
+// its password, as JSON, YAML or CSV depending on ?format=.
+func ExportCatalog(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "yaml" && format != "csv" {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "format must be json, yaml or csv",
+			FieldError{Field: "format", Code: CodeInvalidParameter, Message: "must be json, yaml or csv"})
+		return
+	}
+	includeUsers := r.URL.Query().Get("include_users") == "true"
+
+	catalog, err := loadCatalog(GetDBInstance().WithContext(r.Context()), includeUsers)
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to export catalog")
+		requestLogger(r).Error("Error exporting catalog", "error", err)
+		return
+	}
+
+	var body []byte
+	var contentType string
+	switch format {
+	case "yaml":
+		body, err = yaml.Marshal(catalog)
+		contentType = YAMLContentType
+	case "csv":
+		body, err = encodeCatalogCSV(catalog)
+		contentType = CSVContentType
+	default:
+		body, err = json.MarshalIndent(catalog, "", "  ")
+		body = append(body, '\n')
+		contentType = "application/json"
+	}
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to encode catalog")
+		requestLogger(r).Error("Encoding error", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="catalog.`+format+`"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// ImportCatalog upserts the services, versions and users of a catalog by name.
+//
+// The body format follows the Content-Type: JSON (default), YAML or CSV as produced by ExportCatalog.
+// Items that are missing are created and identical ones are left alone. Items that differ are handled
+// according to ?on_conflict=: skip (default) keeps the stored item, overwrite replaces it and fail rejects the
+// whole import with 409. Items stored but absent from the catalog are never deleted. With ?dry_run=true the
+// import runs in a transaction that is rolled back, so the report shows what would change.
+func ImportCatalog(w http.ResponseWriter, r *http.Request) {
+	onConflict := r.URL.Query().Get("on_conflict")
+	if onConflict == "" {
+		onConflict = ConflictSkip
+	}
+	if onConflict != ConflictSkip && onConflict != ConflictOverwrite && onConflict != ConflictFail {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "on_conflict must be skip, overwrite or fail",
+			FieldError{Field: "on_conflict", Code: CodeInvalidParameter, Message: "must be skip, overwrite or fail"})
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeReadProblem(w, r, err, CodeInvalidJSON, "Failed to read catalog")
+		return
+	}
+	catalog, rej := decodeCatalog(r.Header.Get("Content-Type"), body)
+	if rej != nil {
+		writeRejection(w, r, rej)
+		return
+	}
+	if fieldErrors := validationErrors(&catalog); len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	report := ImportReport{DryRun: dryRun, OnConflict: onConflict, Summary: map[string]int{}, Changes: []ImportChange{}}
+	err = GetDBInstance().WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := importCatalog(tx, catalog, onConflict, &report); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+
+	var rejected *rejection
+	switch {
+	case err == nil, errors.Is(err, errDryRun):
+	case errors.As(err, &rejected):
+		writeRejection(w, r, rejected)
+		return
+	case writeUniqueViolation(w, r, err, "Catalog conflicts with stored data"):
+		return
+	default:
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to import catalog")
+		requestLogger(r).Error("Error importing catalog", "error", err)
+		return
+	}
+
+	for _, change := range report.Changes {
+		report.Summary[change.Action]++
+	}
+	setJSONHeader(w)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// loadCatalog reads the stored services, versions and optionally users, sorted by name.
+func loadCatalog(db *gorm.DB, includeUsers bool) (Catalog, error) {
+	catalog := Catalog{Services: []CatalogService{}}
+
+	var services []Service
+	err := db.Preload("Versions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("service_version_name")
+	}).Order("service_name").Find(&services).Error
+	if err != nil {
+		return catalog, err
+	}
+	for _, service := range services {
+		entry := CatalogService{Name: service.ServiceName, Description: service.ServiceDescription}
+		for _, version := range service.Versions {
+			entry.Versions = append(entry.Versions, CatalogVersion{
+				Name:        version.ServiceVersionName,
+				URL:         version.ServiceVersionURL,
+				Description: version.ServiceVersionDescription,
+			})
+		}
+		catalog.Services = append(catalog.Services, entry)
+	}
+
+	if includeUsers {
+		var users []User
+		if err := db.Preload("UserProfile").Order("username").Find(&users).Error; err != nil {
+			return catalog, err
+		}
+		for _, user := range users {
+			catalog.Users = append(catalog.Users, catalogUser(user))
+		}
+	}
+	return catalog, nil
+}
+
+func catalogUser(user User) CatalogUser {
+	return CatalogUser{
+		Username:  user.Username,
+		Role:      user.Role,
+		FirstName: user.UserProfile.FirstName,
+		LastName:  user.UserProfile.LastName,
+		Email:     user.UserProfile.Email,
+	}
+}
+
+// importCatalog applies the catalog inside tx and records every outcome in report.
+// With the fail strategy every conflict is collected first, so the 409 lists all of them.
+func importCatalog(tx *gorm.DB, catalog Catalog, onConflict string, report *ImportReport) error {
+	var conflicts []FieldError
+	var missing []FieldError
+
+	// resolve records the outcome of an item and reports whether the stored item should be overwritten
+	resolve := func(change ImportChange, path string) bool {
+		if len(change.Fields) == 0 {
+			change.Action = ActionUnchanged
+		} else if onConflict == ConflictOverwrite {
+			change.Action = ActionUpdated
+		} else if onConflict == ConflictFail {
+			change.Action = ActionConflict
+			conflicts = append(conflicts, FieldError{Field: path, Code: CodeConflict, Message: "differs from the stored item in " + strings.Join(change.Fields, ", ")})
+		} else {
+			change.Action = ActionSkipped
+		}
+		report.Changes = append(report.Changes, change)
+		return change.Action == ActionUpdated
+	}
+
+	for i, item := range catalog.Services {
+		path := fmt.Sprintf("services[%d]", i)
+		var service Service
+		err := tx.Preload("Versions").Where("service_name = ?", item.Name).First(&service).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if service, err = createOrRestoreService(tx, item.Name, item.Description); err != nil {
+				return err
+			}
+			report.Changes = append(report.Changes, ImportChange{Kind: "service", Name: item.Name, Action: ActionCreated})
+		case err != nil:
+			return err
+		default:
+			var fields []string
+			if service.ServiceDescription != item.Description {
+				fields = append(fields, "description")
+			}
+			if resolve(ImportChange{Kind: "service", Name: item.Name, Fields: fields}, path) {
+				service.ServiceDescription = item.Description
+				versions := service.Versions
+				service.Versions = nil
+				if err := updateWithRevision(tx, &service, service.Revision); err != nil {
+					return err
+				}
+				service.Versions = versions
+			}
+		}
+
+		stored := make(map[string]ServiceVersion)
+		for _, version := range service.Versions {
+			stored[version.ServiceVersionName] = version
+		}
+		for j, versionItem := range item.Versions {
+			version, ok := stored[versionItem.Name]
+			if !ok {
+				version = ServiceVersion{
+					ServiceID:                 service.ID,
+					ServiceVersionName:        versionItem.Name,
+					ServiceVersionURL:         versionItem.URL,
+					ServiceVersionDescription: versionItem.Description,
+				}
+				if err := createResource(tx, &version); err != nil {
+					return err
+				}
+				report.Changes = append(report.Changes, ImportChange{Kind: "version", Service: item.Name, Name: versionItem.Name, Action: ActionCreated})
+				continue
+			}
+
+			var fields []string
+			if version.ServiceVersionURL != versionItem.URL {
+				fields = append(fields, "url")
+			}
+			if version.ServiceVersionDescription != versionItem.Description {
+				fields = append(fields, "description")
+			}
+			if resolve(ImportChange{Kind: "version", Service: item.Name, Name: versionItem.Name, Fields: fields}, fmt.Sprintf("%s.versions[%d]", path, j)) {
+				version.ServiceVersionURL = versionItem.URL
+				version.ServiceVersionDescription = versionItem.Description
+				if err := updateWithRevision(tx, &version, version.Revision); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for i, item := range catalog.Users {
+		path := fmt.Sprintf("users[%d]", i)
+		var user User
+		err := tx.Unscoped().Preload("UserProfile").Where("username = ?", item.Username).First(&user).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err != nil || user.DeletedAt.Valid {
+			if item.Password == "" {
+				missing = append(missing, FieldError{Field: path + ".password", Code: "required", Message: "is required to create a user"})
+				continue
+			}
+			applyCatalogUser(&user, item)
+			if user.ID == 0 {
+				err = createResource(tx, &user)
+			} else {
+				// Usernames stay unique across soft-deleted users, so a deleted user is restored instead. Subscribers
+				// saw the user deleted, so its restore is announced as a creation like that of a service
+				user.UserProfile.DeletedAt = gorm.DeletedAt{}
+				err = tx.Unscoped().Model(&user).Update("deleted_at", nil).Error
+				if err == nil {
+					err = writeWithRevision(tx.Session(&gorm.Session{FullSaveAssociations: true}), &user, user.Revision, "created")
+				}
+			}
+			if err != nil {
+				return err
+			}
+			report.Changes = append(report.Changes, ImportChange{Kind: "user", Name: item.Username, Action: ActionCreated})
+			continue
+		}
+
+		var fields []string
+		current := catalogUser(user)
+		if current.Role != item.Role {
+			fields = append(fields, "role")
+		}
+		if current.FirstName != item.FirstName {
+			fields = append(fields, "first_name")
+		}
+		if current.LastName != item.LastName {
+			fields = append(fields, "last_name")
+		}
+		if current.Email != item.Email {
+			fields = append(fields, "email")
+		}
+		if item.Password != "" && user.Password != item.Password {
+			fields = append(fields, "password")
+		}
+		if resolve(ImportChange{Kind: "user", Name: item.Username, Fields: fields}, path) {
+			applyCatalogUser(&user, item)
+			if err := updateWithRevision(tx.Session(&gorm.Session{FullSaveAssociations: true}), &user, user.Revision); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return &rejection{status: http.StatusUnprocessableEntity, code: CodeValidationFailed, detail: "Catalog validation failed", fieldErrors: missing}
+	}
+	if len(conflicts) > 0 {
+		return &rejection{status: http.StatusConflict, code: CodeConflict, detail: "Catalog conflicts with stored data", fieldErrors: conflicts}
+	}
+	return nil
+}
+
+// createOrRestoreService creates a service with the given name. Names stay unique across soft-deleted
+// services, so a deleted service of the same name is restored instead, without its old versions.
+func createOrRestoreService(tx *gorm.DB, name, description string) (Service, error) {
+	var service Service
+	err := tx.Unscoped().Where("service_name = ? AND deleted_at IS NOT NULL", name).First(&service).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		service = Service{ServiceName: name, ServiceDescription: description}
+		return service, createResource(tx, &service)
+	}
+	if err != nil {
+		return service, err
+	}
+
+	// Subscribers saw the service deleted, so its restore is announced as a creation
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&service).Updates(map[string]interface{}{
+			"deleted_at":          nil,
+			"service_description": description,
+			"revision":            gorm.Expr("revision + 1"),
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&service, service.ID).Error; err != nil {
+			return err
+		}
+		return recordChange(tx, &service, "created")
+	})
+	return service, err
+}
+
+func applyCatalogUser(user *User, item CatalogUser) {
+	user.Username = item.Username
+	user.Role = item.Role
+	if item.Password != "" {
+		user.Password = item.Password
+	}
+	user.UserProfile.FirstName = item.FirstName
+	user.UserProfile.LastName = item.LastName
+	user.UserProfile.Email = item.Email
+}
+
+// decodeCatalog parses an import body according to its media type, rejecting unknown fields like decodeJSON.
+func decodeCatalog(contentType string, body []byte) (Catalog, *rejection) {
+	var catalog Catalog
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case "", "application/json":
+		return catalog, decodeJSON(body, &catalog)
+	case YAMLContentType, "application/x-yaml", "text/yaml":
+		var document interface{}
+		if err := yaml.Unmarshal(body, &document); err != nil {
+			return catalog, &rejection{status: http.StatusBadRequest, code: CodeInvalidJSON, detail: "Invalid YAML payload"}
+		}
+		// Round-trip through JSON so YAML gets the same unknown-field and type checks
+		raw, err := json.Marshal(document)
+		if err != nil {
+			return catalog, &rejection{status: http.StatusBadRequest, code: CodeInvalidJSON, detail: "YAML payload must use string keys"}
+		}
+		return catalog, decodeJSON(raw, &catalog)
+	case CSVContentType:
+		return decodeCatalogCSV(body)
+	default:
+		return catalog, &rejection{
+			status: http.StatusUnsupportedMediaType,
+			code:   CodeUnsupportedMediaType,
+			detail: "Content-Type must be application/json, " + YAMLContentType + " or " + CSVContentType,
+		}
+	}
+}
+
+// csvHeader lists the CSV columns. The record column says which of the other columns a row uses:
+// "service" rows name a service, "version" rows add a version to the named service and "user" rows describe a user.
+var csvHeader = []string{"record", "service", "service_description", "version", "version_url", "version_description", "username", "role", "first_name", "last_name", "email"}
+
+func encodeCatalogCSV(catalog Catalog) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(csvHeader)
+	for _, service := range catalog.Services {
+		writer.Write([]string{"service", service.Name, service.Description, "", "", "", "", "", "", "", ""})
+		for _, version := range service.Versions {
+			writer.Write([]string{"version", service.Name, "", version.Name, version.URL, version.Description, "", "", "", "", ""})
+		}
+	}
+	for _, user := range catalog.Users {
+		writer.Write([]string{"user", "", "", "", "", "", user.Username, user.Role, user.FirstName, user.LastName, user.Email})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func decodeCatalogCSV(body []byte) (Catalog, *rejection) {
+	catalog := Catalog{Services: []CatalogService{}}
+	invalid := func(detail string) (Catalog, *rejection) {
+		return catalog, &rejection{status: http.StatusBadRequest, code: CodeInvalidJSON, detail: detail}
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		return invalid("Invalid CSV payload")
+	}
+	if len(rows) == 0 {
+		return catalog, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range csvHeader {
+		if _, ok := columns[name]; !ok {
+			return invalid("CSV header must contain the columns " + strings.Join(csvHeader, ","))
+		}
+	}
+
+	services := make(map[string]int)
+	serviceIndex := func(name string) int {
+		if i, ok := services[name]; ok {
+			return i
+		}
+		services[name] = len(catalog.Services)
+		catalog.Services = append(catalog.Services, CatalogService{Name: name})
+		return services[name]
+	}
+
+	var fieldErrors []FieldError
+	for n, row := range rows[1:] {
+		get := func(column string) string { return row[columns[column]] }
+		switch get("record") {
+		case "service":
+			i := serviceIndex(get("service"))
+			catalog.Services[i].Description = get("service_description")
+		case "version":
+			i := serviceIndex(get("service"))
+			catalog.Services[i].Versions = append(catalog.Services[i].Versions, CatalogVersion{
+				Name:        get("version"),
+				URL:         get("version_url"),
+				Description: get("version_description"),
+			})
+		case "user":
+			catalog.Users = append(catalog.Users, CatalogUser{
+				Username:  get("username"),
+				Role:      get("role"),
+				FirstName: get("first_name"),
+				LastName:  get("last_name"),
+				Email:     get("email"),
+			})
+		default:
+			// Rows are numbered as in a spreadsheet, counting the header as row 1
+			fieldErrors = append(fieldErrors, FieldError{Field: "row " + strconv.Itoa(n+2), Code: "record", Message: "record must be service, version or user"})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return catalog, &rejection{status: http.StatusUnprocessableEntity, code: CodeValidationFailed, detail: "Payload contains invalid rows", fieldErrors: fieldErrors}
+	}
+	return catalog, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+var testCatalog = Catalog{
+	Services: []CatalogService{
+		{Name: "Billing", Description: "Invoices, with commas", Versions: []CatalogVersion{
+			{Name: "v1", URL: "http://billing.example.com/v1", Description: "First"},
+			{Name: "v2", URL: "http://billing.example.com/v2"},
+		}},
+		{Name: "Search"},
+	},
+	Users: []CatalogUser{
+		{Username: "alice", Role: "admin", FirstName: "Alice", LastName: "Smith", Email: "alice@example.com"},
+	},
+}
+
+func TestDecodeCatalog(t *testing.T) {
+	csvBody, err := encodeCatalogCSV(testCatalog)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		code        string
+	}{
+		{"JSON", "application/json", `{"services": [{"name": "Billing", "versions": [{"name": "v1"}]}]}`, 0, ""},
+		{"DefaultsToJSON", "", `{"services": []}`, 0, ""},
+		{"YAML", "application/yaml", "services:\n  - name: Billing\n    versions:\n      - name: v1\n", 0, ""},
+		{"CSV", "text/csv; charset=utf-8", string(csvBody), 0, ""},
+		{"JSONUnknownField", "application/json", `{"services": [{"name": "Billing", "owner": "alice"}]}`, http.StatusUnprocessableEntity, CodeValidationFailed},
+		{"YAMLUnknownField", "application/yaml", "services:\n  - name: Billing\n    owner: alice\n", http.StatusUnprocessableEntity, CodeValidationFailed},
+		{"InvalidYAML", "application/yaml", "services: [", http.StatusBadRequest, CodeInvalidJSON},
+		{"CSVMissingColumns", "text/csv", "record,service\nservice,Billing\n", http.StatusBadRequest, CodeInvalidJSON},
+		{"CSVUnknownRecord", "text/csv", strings.Join(csvHeader, ",") + "\nroute,,,,,,,,,,\n", http.StatusUnprocessableEntity, CodeValidationFailed},
+		{"Unsupported", "text/plain", "services", http.StatusUnsupportedMediaType, CodeUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rej := decodeCatalog(tt.contentType, []byte(tt.body))
+			if tt.status == 0 {
+				assert.Nil(t, rej)
+				return
+			}
+			if assert.NotNil(t, rej) {
+				assert.Equal(t, tt.status, rej.status)
+				assert.Equal(t, tt.code, rej.code)
+			}
+		})
+	}
+}
+
+func TestCatalogCSVRoundTrip(t *testing.T) {
+	body, err := encodeCatalogCSV(testCatalog)
+	assert.NoError(t, err)
+
+	decoded, rej := decodeCatalogCSV(body)
+	assert.Nil(t, rej)
+	assert.Equal(t, testCatalog, decoded)
+}
+
+func TestCatalogValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog Catalog
+		fields  []string
+	}{
+		{"Valid", testCatalog, nil},
+		{"DuplicateServices", Catalog{Services: []CatalogService{{Name: "Billing"}, {Name: "Billing"}}}, []string{"services"}},
+		{"DuplicateVersions", Catalog{Services: []CatalogService{{Name: "Billing", Versions: []CatalogVersion{{Name: "v1"}, {Name: "v1"}}}}}, []string{"services[0].versions"}},
+		{"InvalidUser", Catalog{Users: []CatalogUser{{Username: "bob", Role: "root", Password: "short"}}}, []string{"users[0].password", "users[0].role"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, fe := range validationErrors(&tt.catalog) {
+				fields = append(fields, fe.Field)
+			}
+			assert.ElementsMatch(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestImportCatalog(t *testing.T) {
+	db := GetDBInstance()
+	service := Service{ServiceName: "ImportedService", ServiceDescription: "Stored"}
+	assert.NoError(t, db.Create(&service).Error)
+	assert.NoError(t, db.Create(&ServiceVersion{ServiceID: service.ID, ServiceVersionName: "v1", ServiceVersionURL: "http://stored.example.com"}).Error)
+
+	payload := `{"services": [
+		{"name": "ImportedService", "description": "Incoming", "versions": [{"name": "v1", "url": "http://stored.example.com"}, {"name": "v2"}]},
+		{"name": "NewImportedService"}
+	]}`
+
+	tests := []struct {
+		name       string
+		query      string
+		statusCode int
+		summary    map[string]int
+	}{
+		{"DryRunOverwrite", "?dry_run=true&on_conflict=overwrite", http.StatusOK, map[string]int{ActionCreated: 2, ActionUpdated: 1, ActionUnchanged: 1}},
+		{"Fail", "?on_conflict=fail", http.StatusConflict, nil},
+		{"Skip", "", http.StatusOK, map[string]int{ActionCreated: 2, ActionSkipped: 1, ActionUnchanged: 1}},
+		{"SkipAgain", "?on_conflict=skip", http.StatusOK, map[string]int{ActionSkipped: 1, ActionUnchanged: 3}},
+		{"Overwrite", "?on_conflict=overwrite", http.StatusOK, map[string]int{ActionUpdated: 1, ActionUnchanged: 3}},
+		{"UnknownStrategy", "?on_conflict=merge", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/import"+tt.query, strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			ImportCatalog(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.summary != nil {
+				var report ImportReport
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+				assert.Equal(t, tt.summary, report.Summary)
+			}
+		})
+	}
+
+	var stored Service
+	assert.NoError(t, db.Where("service_name = ?", "ImportedService").First(&stored).Error)
+	assert.Equal(t, "Incoming", stored.ServiceDescription)
+	assert.Equal(t, uint(2), stored.Revision)
+}
+
+func TestExportCatalog(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		statusCode  int
+		contentType string
+		body        string
+	}{
+		{"JSON", "", http.StatusOK, "application/json", `"name": "Service 1"`},
+		{"YAML", "?format=yaml&include_users=true", http.StatusOK, YAMLContentType, "username: user1"},
+		{"CSV", "?format=csv", http.StatusOK, CSVContentType, "version,Service 1,,Service 1 Version 1,http://service1.com"},
+		{"UsersWithoutPasswords", "?include_users=true", http.StatusOK, "application/json", `"username": "user2"`},
+		{"UnknownFormat", "?format=xml", http.StatusBadRequest, ProblemContentType, "format must be json, yaml or csv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/export"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			ExportCatalog(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			assert.Equal(t, tt.contentType, rr.Header().Get("Content-Type"))
+			assert.Contains(t, rr.Body.String(), tt.body)
+			assert.NotContains(t, rr.Body.String(), "password")
+		})
+	}
+}
+
+func TestImportCatalogRestoresUser(t *testing.T) {
+	db := GetDBInstance()
+	user := User{Username: "restored-user", Password: "password1", Role: "user"}
+	assert.NoError(t, db.Create(&user).Error)
+	assert.NoError(t, db.Delete(&user).Error)
+
+	catalog := Catalog{Users: []CatalogUser{{Username: "restored-user", Role: "admin", Password: "password2"}}}
+	report := ImportReport{OnConflict: ConflictOverwrite}
+	assert.NoError(t, db.Transaction(func(tx *gorm.DB) error { return importCatalog(tx, catalog, ConflictOverwrite, &report) }))
+
+	// Subscribers saw the user deleted, so the restore is announced as a creation
+	var types []string
+	assert.NoError(t, db.Model(&OutboxEntry{}).Where("payload->'data'->>'username' = ?", "restored-user").Order("id").Pluck("event_type", &types).Error)
+	assert.Equal(t, []string{EventUserCreated}, types)
+}
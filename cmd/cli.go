@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runCommand runs a CLI subcommand and returns the process exit code.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	switch args[0] {
+	case "apply":
+		return runApply(args[1:], stdout, stderr)
+	case "import-kong":
+		return runImportKong(args[1:], stdout, stderr)
+	case "config":
+		return runConfig(args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		printUsage(stdout)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n\n", args[0])
+		printUsage(stderr)
+		return 2
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, `Usage:
+  kong-service-dashboard [flags]               start the API server
+  kong-service-dashboard apply [flags]         converge the catalog to a desired-state document
+  kong-service-dashboard import-kong [flags]   import a Kong declarative configuration
+  kong-service-dashboard config print [flags]  print the configuration the server would start with
+
+Run "kong-service-dashboard <command> -h" for the flags of a command.`)
+}
+
+// runApply sends a desired-state document to a running server's /v1/apply endpoint and prints the plan as a diff.
+func runApply(args []string, stdout, stderr io.Writer) int {
+	flags, client := newClientFlags("apply", stderr)
+	prune := flags.Bool("prune", false, "delete services and versions missing from the document")
+	dryRun := flags.Bool("dry-run", false, "only print the plan")
+	if code := client.parse(flags, args); code != 0 {
+		return code
+	}
+
+	query := url.Values{}
+	query.Set("prune", fmt.Sprint(*prune))
+	query.Set("dry_run", fmt.Sprint(*dryRun))
+	var plan Plan
+	if !client.post("/v1/apply", query, &plan, stderr) {
+		return 1
+	}
+	fmt.Fprint(stdout, renderPlan(plan))
+	return 0
+}
+
+// runImportKong sends a Kong declarative configuration to a running server's /v1/import/kong endpoint.
+func runImportKong(args []string, stdout, stderr io.Writer) int {
+	flags, client := newClientFlags("import-kong", stderr)
+	dryRun := flags.Bool("dry-run", false, "only report what would change")
+	if code := client.parse(flags, args); code != 0 {
+		return code
+	}
+
+	query := url.Values{}
+	query.Set("dry_run", fmt.Sprint(*dryRun))
+	var report ImportReport
+	if !client.post("/v1/import/kong", query, &report, stderr) {
+		return 1
+	}
+	fmt.Fprint(stdout, renderImportReport(report))
+	return 0
+}
+
+// runConfig prints the configuration loaded from the same file, environment and flags as the server, with
+// its secrets redacted, followed by any validation error.
+func runConfig(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(stderr, `usage: kong-service-dashboard config print [flags]`)
+		return 2
+	}
+	config, err := LoadConfig("config print", args[1:], stderr)
+	if err != nil {
+		if err != errInvalidFlags {
+			fmt.Fprintln(stderr, err)
+		}
+		return 2
+	}
+
+	out, err := config.Redacted()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	stdout.Write(out)
+	if err := config.Validate(); err != nil {
+		fmt.Fprintf(stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// clientFlags holds the flags shared by subcommands that send a document to a running server.
+type clientFlags struct {
+	name   string
+	file   *string
+	server *string
+	token  *string
+}
+
+func newClientFlags(name string, stderr io.Writer) (*flag.FlagSet, *clientFlags) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags, &clientFlags{
+		name:   name,
+		file:   flags.String("f", "", "document to send (.yaml, .yml, .json or .csv)"),
+		server: flags.String("server", getEnvQuiet("SERVICE_DASHBOARD_URL", "http://localhost:8080"), "dashboard base URL, or SERVICE_DASHBOARD_URL"),
+		token:  flags.String("token", os.Getenv("SERVICE_DASHBOARD_TOKEN"), "admin JWT, or SERVICE_DASHBOARD_TOKEN"),
+	}
+}
+
+func (c *clientFlags) parse(flags *flag.FlagSet, args []string) int {
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *c.file == "" {
+		fmt.Fprintf(flags.Output(), "%s: -f is required\n", c.name)
+		flags.Usage()
+		return 2
+	}
+	return 0
+}
+
+// post sends the document to the server and decodes a 200 response into out.
+// Any failure, including problem details returned by the server, is printed to stderr.
+func (c *clientFlags) post(path string, query url.Values, out interface{}, stderr io.Writer) bool {
+	document, err := os.ReadFile(*c.file)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", c.name, err)
+		return false
+	}
+	contentType := "application/json"
+	switch strings.ToLower(filepath.Ext(*c.file)) {
+	case ".yaml", ".yml":
+		contentType = YAMLContentType
+	case ".csv":
+		contentType = CSVContentType
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimSuffix(*c.server, "/")+path+"?"+query.Encode(), bytes.NewReader(document))
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", c.name, err)
+		return false
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if *c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+*c.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", c.name, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var problem Problem
+		if json.NewDecoder(resp.Body).Decode(&problem) != nil || problem.Detail == "" {
+			fmt.Fprintf(stderr, "%s: server returned %s\n", c.name, resp.Status)
+			return false
+		}
+		fmt.Fprintf(stderr, "%s: %s\n", c.name, problem.Detail)
+		for _, fe := range problem.Errors {
+			fmt.Fprintf(stderr, "  %s: %s\n", fe.Field, fe.Message)
+		}
+		return false
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		fmt.Fprintf(stderr, "%s: invalid response: %v\n", c.name, err)
+		return false
+	}
+	return true
+}
+
+// renderImportReport lists the outcome of every imported item, followed by the summary.
+func renderImportReport(report ImportReport) string {
+	var b strings.Builder
+	for _, change := range report.Changes {
+		name := change.Name
+		if change.Service != "" {
+			name = change.Service + "/" + change.Name
+		}
+		fmt.Fprintf(&b, "%-9s %s %q", change.Action, change.Kind, name)
+		if len(change.Fields) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(change.Fields, ", "))
+		}
+		b.WriteString("\n")
+	}
+
+	prefix := ""
+	if report.DryRun {
+		prefix = "Dry run, nothing was stored. "
+	}
+	fmt.Fprintf(&b, "\n%s%d created, %d updated, %d unchanged.\n", prefix, report.Summary[ActionCreated], report.Summary[ActionUpdated], report.Summary[ActionUnchanged])
+	return b.String()
+}
+
+// getEnvQuiet returns the value of key, or fallback when it is unset, for optional CLI settings.
+func getEnvQuiet(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
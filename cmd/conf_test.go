@@ -14,6 +14,11 @@ func TestMain(m *testing.M) {
 		panic("failed to connect database")
 	}
 
+	// Make sure the schema includes columns added since the database was created
+	if err := AutoMigrateModels(db); err != nil {
+		panic(err)
+	}
+
 	// Load test data
 	log.Println("Generating dummy data")
 	GenerateDummyData(db)
@@ -34,4 +39,10 @@ func cleanup(db *gorm.DB) {
 	db.Exec("DELETE FROM services")
 	db.Exec("DELETE FROM user_profiles")
 	db.Exec("DELETE FROM users")
+	db.Exec("DELETE FROM idempotency_records")
+	db.Exec("DELETE FROM health_checks")
+	db.Exec("DELETE FROM webhook_deliveries")
+	db.Exec("DELETE FROM webhooks")
+	db.Exec("DELETE FROM outbox_entries")
+	db.Exec("DELETE FROM rate_limit_buckets")
 }
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Run modes. Production refuses the defaults meant for local development, such as the sample JWT secret.
+const (
+	ModeDevelopment = "development"
+	ModeProduction  = "production"
+)
+
+// Defaults of the database and authentication settings.
+const (
+	DefaultDBHost     = "host.docker.internal"
+	DefaultDBPort     = 5432
+	DefaultDBUser     = "postgres"
+	DefaultDBPassword = "example"
+	DefaultDBName     = "postgres"
+	DefaultDBSSLMode  = "disable"
+	DefaultJWTSecret  = "secret"
+	DefaultTokenTTL   = 30 * 24 * time.Hour
+)
+
+// minJWTSecretLength is the shortest JWT secret accepted in production, 256 bits for HS256.
+const minJWTSecretLength = 32
+
+// errInvalidFlags is returned by LoadConfig when the flags could not be parsed, which the flag package has
+// already reported along with the usage.
+var errInvalidFlags = errors.New("invalid flags")
+
+// redacted replaces the value of secrets in printed configurations.
+const redacted = "<redacted>"
+
+// Config is the configuration of the server.
+//
+// Every setting is read, in increasing precedence, from its default, the YAML file, the environment variable
+// in its env tag and the flag named after its YAML path, e.g. -database.host. config print redacts the
+// settings tagged as secret, and the password of URLs tagged with secret:"url".
+type Config struct {
+	Mode        string            `yaml:"mode" env:"SERVICE_DASHBOARD_MODE" usage:"development or production"`
+	Server      ServerConfig      `yaml:"server"`
+	TLS         TLSConfig         `yaml:"tls"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Log         LogConfig         `yaml:"log"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Probe       ProbeConfig       `yaml:"probe"`
+	Webhook     WebhookConfig     `yaml:"webhook"`
+	Outbox      OutboxConfig      `yaml:"outbox"`
+	Kong        KongSyncConfig    `yaml:"kong"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+}
+
+// DatabaseConfig locates the Postgres database.
+type DatabaseConfig struct {
+	Host     string `yaml:"host" env:"SERVICE_DASHBOARD_DB_HOST" usage:"database host"`
+	Port     int    `yaml:"port" env:"SERVICE_DASHBOARD_DB_PORT" usage:"database port"`
+	User     string `yaml:"user" env:"SERVICE_DASHBOARD_DB_USER" usage:"database user"`
+	Password string `yaml:"password" env:"SERVICE_DASHBOARD_DB_PASSWORD" secret:"true" usage:"database password"`
+	Name     string `yaml:"name" env:"SERVICE_DASHBOARD_DB_NAME" usage:"database name"`
+	SSLMode  string `yaml:"sslmode" env:"SERVICE_DASHBOARD_DB_SSLMODE" usage:"Postgres sslmode, e.g. disable, require or verify-full"`
+}
+
+// DSN returns the connection string of the database.
+func (c DatabaseConfig) DSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		c.Host, c.Port, c.User, c.Password, c.Name, c.SSLMode)
+}
+
+// AuthConfig configures the tokens issued by POST /v1/auth.
+type AuthConfig struct {
+	JWTSecret string        `yaml:"jwt_secret" env:"SERVICE_DASHBOARD_JWT_SECRET" secret:"true" usage:"secret signing the tokens"`
+	TokenTTL  time.Duration `yaml:"token_ttl" env:"SERVICE_DASHBOARD_TOKEN_TTL" usage:"lifetime of the tokens"`
+}
+
+// LogConfig configures the logger, see setupLogging.
+type LogConfig struct {
+	Level  string `yaml:"level" env:"SERVICE_DASHBOARD_LOG_LEVEL" usage:"debug, info, warn or error"`
+	Format string `yaml:"format" env:"SERVICE_DASHBOARD_LOG_FORMAT" usage:"json or text"`
+}
+
+// TracingConfig selects the trace exporter, see setupTracing.
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" env:"SERVICE_DASHBOARD_TRACES_EXPORTER" usage:"none, otlp or stdout"`
+}
+
+// ProbeConfig configures the HealthProber.
+type ProbeConfig struct {
+	Enabled        bool          `yaml:"enabled" env:"SERVICE_DASHBOARD_PROBE_ENABLED" usage:"probe the version URLs"`
+	Interval       time.Duration `yaml:"interval" env:"SERVICE_DASHBOARD_PROBE_INTERVAL" usage:"time between probe rounds"`
+	Timeout        time.Duration `yaml:"timeout" env:"SERVICE_DASHBOARD_PROBE_TIMEOUT" usage:"time a probe may take"`
+	Method         string        `yaml:"method" env:"SERVICE_DASHBOARD_PROBE_METHOD" usage:"GET or HEAD"`
+	ExpectedStatus string        `yaml:"expected_status" env:"SERVICE_DASHBOARD_PROBE_EXPECTED_STATUS" usage:"healthy status codes, e.g. 200-299,301"`
+	Concurrency    int           `yaml:"concurrency" env:"SERVICE_DASHBOARD_PROBE_CONCURRENCY" usage:"probes running at the same time"`
+}
+
+// WebhookConfig configures the WebhookDispatcher.
+type WebhookConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" env:"SERVICE_DASHBOARD_WEBHOOK_POLL_INTERVAL" usage:"time between looks for due deliveries"`
+}
+
+// OutboxConfig configures the OutboxRelay and its sinks.
+type OutboxConfig struct {
+	Sinks             []string      `yaml:"sinks" env:"SERVICE_DASHBOARD_OUTBOX_SINKS" usage:"comma-separated sinks: log, webhook, sse, nats, kafka"`
+	PollInterval      time.Duration `yaml:"poll_interval" env:"SERVICE_DASHBOARD_OUTBOX_POLL_INTERVAL" usage:"time between relay rounds"`
+	Retention         time.Duration `yaml:"retention" env:"SERVICE_DASHBOARD_OUTBOX_RETENTION" usage:"time delivered entries are kept"`
+	EventsBuffer      int           `yaml:"events_buffer" env:"SERVICE_DASHBOARD_EVENTS_BUFFER" usage:"events kept for Last-Event-ID resume"`
+	NATSURL           string        `yaml:"nats_url" env:"SERVICE_DASHBOARD_NATS_URL" secret:"url" usage:"NATS server of the nats sink"`
+	NATSSubjectPrefix string        `yaml:"nats_subject_prefix" env:"SERVICE_DASHBOARD_NATS_SUBJECT_PREFIX" usage:"prefix of the NATS subjects"`
+	KafkaRESTURL      string        `yaml:"kafka_rest_url" env:"SERVICE_DASHBOARD_KAFKA_REST_URL" secret:"url" usage:"Kafka REST proxy of the kafka sink"`
+	KafkaTopic        string        `yaml:"kafka_topic" env:"SERVICE_DASHBOARD_KAFKA_TOPIC" usage:"Kafka topic"`
+}
+
+// KongSyncConfig configures the KongSyncWorker.
+type KongSyncConfig struct {
+	AdminURL     string        `yaml:"admin_url" env:"SERVICE_DASHBOARD_KONG_ADMIN_URL" secret:"url" usage:"Kong Admin API to sync with"`
+	AdminToken   string        `yaml:"admin_token" env:"SERVICE_DASHBOARD_KONG_ADMIN_TOKEN" secret:"true" usage:"Kong-Admin-Token header"`
+	SyncInterval time.Duration `yaml:"sync_interval" env:"SERVICE_DASHBOARD_KONG_SYNC_INTERVAL" usage:"time between syncs"`
+	Push         bool          `yaml:"push" env:"SERVICE_DASHBOARD_KONG_PUSH" usage:"push the catalog to Kong"`
+}
+
+// IdempotencyConfig configures IdempotencyMiddleware.
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl" env:"SERVICE_DASHBOARD_IDEMPOTENCY_TTL" usage:"time Idempotency-Key responses are replayed"`
+}
+
+// defaultConfig returns the configuration used when nothing is set, suited to local development.
+func defaultConfig() *Config {
+	dbHost := DefaultDBHost
+	// Tests run next to the database rather than in a container
+	if os.Getenv("UNIT_TEST") == "True" {
+		dbHost = "localhost"
+	}
+
+	return &Config{
+		Mode: ModeDevelopment,
+		Server: ServerConfig{
+			Addr:              DefaultAddr,
+			ReadHeaderTimeout: DefaultReadHeaderTimeout,
+			ReadTimeout:       DefaultReadTimeout,
+			WriteTimeout:      DefaultWriteTimeout,
+			IdleTimeout:       DefaultIdleTimeout,
+			MaxHeaderBytes:    DefaultMaxHeaderBytes,
+			MaxBodyBytes:      DefaultMaxBodyBytes,
+			ShutdownDelay:     DefaultShutdownDelay,
+			ShutdownTimeout:   DefaultShutdownTimeout,
+		},
+		TLS: TLSConfig{ReloadInterval: DefaultTLSReloadInterval, ClientAuth: TLSClientAuthOptional},
+		Database: DatabaseConfig{
+			Host:     dbHost,
+			Port:     DefaultDBPort,
+			User:     DefaultDBUser,
+			Password: DefaultDBPassword,
+			Name:     DefaultDBName,
+			SSLMode:  DefaultDBSSLMode,
+		},
+		Auth:    AuthConfig{JWTSecret: DefaultJWTSecret, TokenTTL: DefaultTokenTTL},
+		Log:     LogConfig{Level: DefaultLogLevel, Format: DefaultLogFormat},
+		Tracing: TracingConfig{Exporter: TracesExporterNone},
+		Probe: ProbeConfig{
+			Enabled:        true,
+			Interval:       DefaultProbeInterval,
+			Timeout:        DefaultProbeTimeout,
+			Method:         "GET",
+			ExpectedStatus: DefaultProbeExpectedStatus,
+			Concurrency:    DefaultProbeConcurrency,
+		},
+		Webhook: WebhookConfig{PollInterval: DefaultWebhookPollInterval},
+		Outbox: OutboxConfig{
+			Sinks:             splitList(DefaultOutboxSinks),
+			PollInterval:      DefaultOutboxPollInterval,
+			Retention:         DefaultOutboxRetention,
+			EventsBuffer:      DefaultEventBufferSize,
+			NATSSubjectPrefix: "catalog",
+			KafkaTopic:        "catalog-events",
+		},
+		Kong:        KongSyncConfig{SyncInterval: DefaultKongSyncInterval},
+		Idempotency: IdempotencyConfig{TTL: DefaultIdempotencyTTL},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Backend: RateLimitBackendMemory,
+			Limits:  splitList(DefaultRateLimits),
+		},
+	}
+}
+
+// configField is one setting of a Config, found by walking its struct tags.
+type configField struct {
+	Path   string
+	Env    string
+	Usage  string
+	Secret string
+	Value  reflect.Value
+}
+
+// configFields lists the settings of config, in declaration order.
+func configFields(config *Config) []configField {
+	var fields []configField
+	var walk func(prefix string, value reflect.Value)
+	walk = func(prefix string, value reflect.Value) {
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			path := prefix + field.Tag.Get("yaml")
+			if field.Type.Kind() == reflect.Struct {
+				walk(path+".", value.Field(i))
+				continue
+			}
+			fields = append(fields, configField{
+				Path:   path,
+				Env:    field.Tag.Get("env"),
+				Usage:  field.Tag.Get("usage"),
+				Secret: field.Tag.Get("secret"),
+				Value:  value.Field(i),
+			})
+		}
+	}
+	walk("", reflect.ValueOf(config).Elem())
+	return fields
+}
+
+// setConfigValue parses s into the setting value.
+func setConfigValue(value reflect.Value, s string) error {
+	if value.Type() == reflect.TypeOf(time.Duration(0)) {
+		duration, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(s)
+	case reflect.Int:
+		parsed, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		value.SetInt(int64(parsed))
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		value.SetBool(parsed)
+	case reflect.Slice:
+		value.Set(reflect.ValueOf(splitList(s)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", value.Type())
+	}
+	return nil
+}
+
+// LoadConfig builds the configuration from, in increasing precedence, the defaults, the YAML file named by the
+// -config flag or SERVICE_DASHBOARD_CONFIG, the environment and the flags in args. It does not validate it.
+func LoadConfig(name string, args []string, stderr io.Writer) (*Config, error) {
+	config := defaultConfig()
+	fields := configFields(config)
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.String("config", os.Getenv("SERVICE_DASHBOARD_CONFIG"), "YAML configuration file, or SERVICE_DASHBOARD_CONFIG")
+	// Flags are parsed first but applied last, over the file and the environment
+	var flagValues []func()
+	for _, field := range fields {
+		usage := field.Usage
+		if field.Env != "" {
+			usage += ", or " + field.Env
+		}
+		flags.Func(field.Path, usage, func(s string) error {
+			if err := setConfigValue(reflect.New(field.Value.Type()).Elem(), s); err != nil {
+				return err
+			}
+			flagValues = append(flagValues, func() { setConfigValue(field.Value, s) })
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, errInvalidFlags
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	if *path != "" {
+		if err := readConfigFile(*path, config); err != nil {
+			return nil, err
+		}
+	}
+	for _, field := range fields {
+		if s := os.Getenv(field.Env); field.Env != "" && s != "" {
+			if err := setConfigValue(field.Value, s); err != nil {
+				return nil, fmt.Errorf("%s: %w", field.Env, err)
+			}
+		}
+	}
+	for _, apply := range flagValues {
+		apply()
+	}
+	return config, nil
+}
+
+// readConfigFile overlays the settings of the YAML file at path on config. Unknown settings are rejected, so a
+// typo does not silently leave the default in place.
+func readConfigFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading the configuration file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting, and in production every insecure default left in place.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Mode != ModeDevelopment && c.Mode != ModeProduction {
+		fail("mode must be %s or %s, got %q", ModeDevelopment, ModeProduction, c.Mode)
+	}
+	for _, field := range configFields(c) {
+		if field.Value.Kind() != reflect.Int && field.Value.Kind() != reflect.Int64 {
+			continue
+		}
+		// Only the shutdown delay may be zero, to shut down right away
+		if field.Value.Int() < 0 || (field.Value.Int() == 0 && field.Path != "server.shutdown_delay") {
+			fail("%s must be positive", field.Path)
+		}
+	}
+	errs = append(errs, c.TLS.validate()...)
+	errs = append(errs, c.RateLimit.validate()...)
+	if c.Database.Port > 65535 {
+		fail("database.port must be at most 65535")
+	}
+	if !slices.Contains([]string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}, c.Database.SSLMode) {
+		fail("database.sslmode must be one of disable, allow, prefer, require, verify-ca, verify-full, got %q", c.Database.SSLMode)
+	}
+	if _, err := newLogger(io.Discard, c.Log.Level, c.Log.Format); err != nil {
+		fail("log: %v", err)
+	}
+	if !slices.Contains([]string{TracesExporterNone, TracesExporterOTLP, TracesExporterStdout}, c.Tracing.Exporter) {
+		fail("tracing.exporter must be one of %s, %s, %s, got %q", TracesExporterNone, TracesExporterOTLP, TracesExporterStdout, c.Tracing.Exporter)
+	}
+	if method := strings.ToUpper(c.Probe.Method); method != "GET" && method != "HEAD" {
+		fail("probe.method must be GET or HEAD, got %q", c.Probe.Method)
+	}
+	if _, err := ParseStatusRanges(c.Probe.ExpectedStatus); err != nil {
+		fail("probe.expected_status: %v", err)
+	}
+	for _, sink := range c.Outbox.Sinks {
+		switch sink {
+		case "log", "webhook", "sse":
+		case "nats":
+			if c.Outbox.NATSURL == "" {
+				fail("outbox.nats_url must be set for the nats sink")
+			}
+		case "kafka":
+			if c.Outbox.KafkaRESTURL == "" {
+				fail("outbox.kafka_rest_url must be set for the kafka sink")
+			}
+		default:
+			fail("outbox.sinks: unknown sink %q, allowed: log, webhook, sse, nats, kafka", sink)
+		}
+	}
+	if c.Kong.AdminURL != "" {
+		if parsed, err := url.Parse(c.Kong.AdminURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			fail("kong.admin_url must be an http or https URL, got %q", c.Kong.AdminURL)
+		}
+	}
+
+	if c.Mode == ModeProduction {
+		if c.Auth.JWTSecret == DefaultJWTSecret || len(c.Auth.JWTSecret) < minJWTSecretLength {
+			fail("auth.jwt_secret must be set to at least %d characters in production", minJWTSecretLength)
+		}
+		if c.Database.Host == DefaultDBHost {
+			fail("database.host must be set in production")
+		}
+		if c.Database.Password == DefaultDBPassword {
+			fail("database.password must be set in production")
+		}
+		if c.Database.SSLMode == "disable" {
+			fail("database.sslmode must not be disable in production")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted returns the configuration as YAML, with the secrets that are set replaced, so it can be shared or
+// used as a configuration file once they are filled in.
+func (c *Config) Redacted() ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{}
+	for _, field := range configFields(c) {
+		parent := root
+		key := field.Path
+		if section, name, ok := strings.Cut(field.Path, "."); ok {
+			if sections[section] == nil {
+				sections[section] = &yaml.Node{Kind: yaml.MappingNode}
+				root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section}, sections[section])
+			}
+			parent, key = sections[section], name
+		}
+
+		value := &yaml.Node{}
+		switch {
+		case field.Secret == "true" && field.Value.String() != "":
+			value.SetString(redacted)
+		case field.Secret == "url":
+			printed := field.Value.String()
+			if parsed, err := url.Parse(printed); err == nil {
+				printed = parsed.Redacted()
+			}
+			value.SetString(printed)
+		case field.Value.Type() == reflect.TypeOf(time.Duration(0)):
+			value.SetString(time.Duration(field.Value.Int()).String())
+		default:
+			if err := value.Encode(field.Value.Interface()); err != nil {
+				return nil, err
+			}
+			// Lists stay on one line, next to their variable
+			value.Style = yaml.FlowStyle
+		}
+		if field.Env != "" {
+			value.LineComment = field.Env
+		}
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	appConfig     *Config
+	appConfigOnce sync.Once
+)
+
+// activeConfig returns the configuration the server was started with. Without one, e.g. in tests, it is loaded
+// from the environment.
+func activeConfig() *Config {
+	appConfigOnce.Do(func() {
+		if appConfig != nil {
+			return
+		}
+		config, err := LoadConfig("", nil, io.Discard)
+		if err != nil {
+			log.Fatal(err)
+		}
+		appConfig = config
+	})
+	return appConfig
+}
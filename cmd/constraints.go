@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// pgUniqueViolation is the Postgres SQLSTATE for a unique constraint violation.
+const pgUniqueViolation = "23505"
+
+// CodeUnique is reported for fields whose value is already taken by another row.
+const CodeUnique = "unique"
+
+// uniqueKeyDetail matches the detail Postgres attaches to unique violations,
+// e.g. `Key (service_id, service_version_name)=(1, v1) already exists.`
+var uniqueKeyDetail = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+
+// tableFieldPrefix maps tables written through a parent resource onto their JSON path in that resource.
+var tableFieldPrefix = map[string]string{
+	"user_profiles": "user_profile.",
+}
+
+// uniqueViolationFields reports whether err is a Postgres unique violation and, if so,
+// which payload fields make up the violated key.
+//
+// The database is the source of truth for uniqueness: checking with a SELECT first
+// cannot stop two concurrent requests from inserting the same row.
+func uniqueViolationFields(err error) ([]FieldError, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgUniqueViolation {
+		return nil, false
+	}
+
+	match := uniqueKeyDetail.FindStringSubmatch(pgErr.Detail)
+	if match == nil {
+		return nil, true
+	}
+	var fieldErrors []FieldError
+	for _, column := range strings.Split(match[1], ",") {
+		fieldErrors = append(fieldErrors, FieldError{
+			Field:   tableFieldPrefix[pgErr.TableName] + strings.TrimSpace(column),
+			Code:    CodeUnique,
+			Message: "is already taken",
+		})
+	}
+	return fieldErrors, true
+}
+
+// writeUniqueViolation answers a unique violation with 409 and reports whether err was one.
+func writeUniqueViolation(w http.ResponseWriter, r *http.Request, err error, detail string) bool {
+	fieldErrors, ok := uniqueViolationFields(err)
+	if !ok {
+		return false
+	}
+	writeProblem(w, r, http.StatusConflict, CodeConflict, detail, fieldErrors...)
+	return true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUniqueViolationFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		ok     bool
+		fields []string
+	}{
+		{"NotAPostgresError", errors.New("boom"), false, nil},
+		{"OtherPostgresError", &pgconn.PgError{Code: "23503"}, false, nil},
+		{"SingleColumn", &pgconn.PgError{Code: pgUniqueViolation, TableName: "services", Detail: "Key (service_name)=(Service 1) already exists."}, true, []string{"service_name"}},
+		{"CompositeKey", &pgconn.PgError{Code: pgUniqueViolation, TableName: "service_versions", Detail: "Key (service_id, service_version_name)=(1, v1) already exists."}, true, []string{"service_id", "service_version_name"}},
+		{"NestedTable", fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: pgUniqueViolation, TableName: "user_profiles", Detail: "Key (email)=(abc@gmail.com) already exists."}), true, []string{"user_profile.email"}},
+		{"MissingDetail", &pgconn.PgError{Code: pgUniqueViolation}, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fieldErrors, ok := uniqueViolationFields(tt.err)
+			assert.Equal(t, tt.ok, ok)
+
+			var fields []string
+			for _, fe := range fieldErrors {
+				assert.Equal(t, CodeUnique, fe.Code)
+				fields = append(fields, fe.Field)
+			}
+			assert.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+// runConcurrently sends the same request from n goroutines at once and returns the status codes.
+func runConcurrently(n int, handler http.HandlerFunc, method, payload string) []int {
+	codes := make([]int, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(method, "/", strings.NewReader(payload))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			<-start
+			handler.ServeHTTP(rr, req)
+			codes[i] = rr.Code
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return codes
+}
+
+func countCodes(codes []int) map[int]int {
+	counts := make(map[int]int)
+	for _, code := range codes {
+		counts[code]++
+	}
+	return counts
+}
+
+func TestConcurrentCreatesConflict(t *testing.T) {
+	db := GetDBInstance()
+	service := Service{ServiceName: "ConcurrentVersionsService"}
+	assert.NoError(t, db.Create(&service).Error)
+
+	const n = 10
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		payload string
+	}{
+		{"CreateService", CreateService, `{"service_name": "ConcurrentService"}`},
+		{"CreateServiceVersion", CreateServiceVersion, fmt.Sprintf(`{"service_id": %d, "service_version_name": "v1"}`, service.ID)},
+		{"CreateUser", CreateUser, `{"username": "concurrentUser", "password": "password", "role": "user"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counts := countCodes(runConcurrently(n, tt.handler, "POST", tt.payload))
+			assert.Equal(t, map[int]int{http.StatusCreated: 1, http.StatusConflict: n - 1}, counts)
+		})
+	}
+}
+
+func TestServiceVersionNameReusableAfterDelete(t *testing.T) {
+	db := GetDBInstance()
+	service := Service{ServiceName: "ReusableVersionService"}
+	assert.NoError(t, db.Create(&service).Error)
+	version := ServiceVersion{ServiceID: service.ID, ServiceVersionName: "v1"}
+	assert.NoError(t, db.Create(&version).Error)
+	assert.NoError(t, db.Delete(&version).Error)
+
+	payload := fmt.Sprintf(`{"service_id": %d, "service_version_name": "v1"}`, service.ID)
+	req := httptest.NewRequest("POST", "/v1/service_versions", strings.NewReader(payload))
+	rr := httptest.NewRecorder()
+	CreateServiceVersion(rr, req)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+}
@@ -3,279 +3,219 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"log"
 	"net/http"
 	"strconv"
-	"time"
-
-	"gorm.io/gorm"
 )
 
 func setJSONHeader(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 }
 
-func handleDBQueryError(w http.ResponseWriter, err error, message string, statusCode int) bool {
+func handleDBQueryError(w http.ResponseWriter, r *http.Request, err error, message string, statusCode int) bool {
 	if err != nil {
-		http.Error(w, message, statusCode)
-		log.Printf("Query error: %v", err)
+		writeProblem(w, r, statusCode, CodeInvalidParameter, message)
+		requestLogger(r).Error("Query error", "error", err)
 		return true
 	}
 	return false
 }
 
-// LoggerMiddleware logs details about each HTTP request
-func LoggerMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Capture the start time
-		startTime := time.Now()
-
-		// Combine the query params into a single URL
-		urlWithParams := r.URL.Path + "?" + r.URL.RawQuery
-		log.Printf("[Requests] %s %s", r.Method, urlWithParams)
-
-		// Call the next handler in the chain
-		next.ServeHTTP(w, r)
-
-		// Log the duration
-		duration := time.Since(startTime)
-		log.Printf("Completed in %v", duration)
-	})
-}
-
-// fetchAndRespond is a helper function that handles fetching data and responding to an HTTP request.
-//
-// It calls the provided fetch function, checks for errors, and writes the appropriate HTTP response.
-//
-// Args:
+// GetServices fetches services based on query parameters and responds with the results.
 //
-//	w (http.ResponseWriter): The HTTP response writer.
-//	fetchFunc (func() error): A function that fetches data and returns an error if something goes wrong.
-//	data (interface{}): The data to be encoded and sent in the HTTP response if the fetch is successful.
-//
-// The function performs the following steps:
-// 1. Calls the fetch function.
-// 2. Checks if the error returned by the fetch function is a "record not found" error and responds with a 404 status code if true.
-// 3. Handles any other errors by responding with a 500 status code and logging the error.
-// 4. If no errors occur, it sets the response content type to "application/json" and encodes the provided data into the response body.
-func fetchAndRespond(w http.ResponseWriter, fetchFunc func() error, data interface{}) {
-	// Call the fetch function
-	err := fetchFunc()
-
-	// Check for "record not found" error
-	if errors.Is(err, gorm.ErrRecordNotFound) {
-		http.Error(w, "Resource not found", http.StatusNotFound)
-		return
-	}
-
-	// Handle other potential errors
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		log.Printf("Database error: %v", err)
-		return
-	}
-
-	// Respond with the fetched data encoded as JSON
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(data); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		log.Printf("Encoding error: %v", err)
-	}
-}
-
+// Besides the id, name and search_mode lookups, list requests accept the filter and sort grammar
+// described on ParseListQuery, e.g. "?updated_at>=2024-01-01&has_versions=true&sort=-updated_at".
+// Every request accepts ?fields= and ?expand=versions, see ParseResponseOptions.
 func GetServices(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 	var services []Service
 	var service Service
 
-	// Get pagination and sorting parameters from query string
 	queryParams := r.URL.Query()
-	page := queryParams.Get("page")
-	limit := queryParams.Get("limit")
-	sortBy := queryParams.Get("sort_by")
-	order := queryParams.Get("order")
 	searchFlag := queryParams.Get("search_mode")
 	name := queryParams.Get("name")
 	id := queryParams.Get("id")
-	loadVersion := queryParams.Get("load_version")
-
-	// Set default values if parameters are not provided
-	if page == "" {
-		page = "1"
-	}
-	if limit == "" {
-		limit = "10"
-	}
-	if sortBy == "" {
-		sortBy = "id"
-	}
-	if order == "" {
-		order = "asc"
-	}
-
-	// Convert parameters to integers
-	pageInt, err := strconv.Atoi(page)
-	if handleDBQueryError(w, err, "Invalid page parameter", http.StatusBadRequest) {
-		return
-	}
-	limitInt, err := strconv.Atoi(limit)
-	if handleDBQueryError(w, err, "Invalid limit parameter", http.StatusBadRequest) {
-		return
-	}
 
-	// Validate sorting parameters
-	validSortBy := map[string]bool{"id": true, "service_name": true, "created_at": true}
-	validOrder := map[string]bool{"asc": true, "desc": true}
-
-	if sortBy != "" && !validSortBy[sortBy] {
-		http.Error(w, "Invalid sort_by parameter", http.StatusBadRequest)
+	listQuery, err := ParseListQuery(r, serviceQuerySchema)
+	if err != nil {
+		writeQueryProblem(w, r, err)
 		return
 	}
-	if order != "" && !validOrder[order] {
-		http.Error(w, "Invalid order parameter", http.StatusBadRequest)
+	opts, err := ParseResponseOptions(r, serviceResourceSpec)
+	if err != nil {
+		writeQueryProblem(w, r, err)
 		return
 	}
-	if (sortBy == "" && order != "") || (sortBy != "" && order == "") {
-		http.Error(w, "Both sort_by and order parameters must be provided together", http.StatusBadRequest)
-		return
+	// load_version=true is the legacy spelling of expand=versions
+	if queryParams.Get("load_version") == "true" {
+		opts = opts.WithExpand("versions")
 	}
 
-	// Calculate offset
-	offset := (pageInt - 1) * limitInt
-
 	// Fetch data based on search criteria
 	switch {
 	case id != "":
 		// Get service by ID
-		fetchAndRespond(w, func() error {
-			query := db.First(&service, "id = ?", id)
-			if loadVersion == "true" {
-				query = query.Preload("Versions")
-			}
-			return query.Error
+		respond(w, r, opts, func() error {
+			return opts.Preload(db).First(&service, "id = ?", id).Error
 		}, &service)
 	case searchFlag == "true" && name != "":
 		// Perform a search by name
-		fetchAndRespond(w, func() error {
-			query := db.Where("service_name LIKE ?", "%"+name+"%").Order(sortBy + " " + order).Limit(limitInt)
-			if loadVersion == "true" {
-				query = query.Preload("Versions")
-			}
-			return query.Find(&services).Error
+		respond(w, r, opts, func() error {
+			query := listQuery.Apply(db.Where("service_name LIKE ?", "%"+name+"%"), serviceQuerySchema)
+			return opts.Preload(query).Find(&services).Error
 		}, &services)
 	case name != "":
 		// Get a single service by name
-		fetchAndRespond(w, func() error {
-			query := db.First(&service, "service_name = ?", name)
-			if loadVersion == "true" {
-				query = query.Preload("Versions")
-			}
-			return query.Error
+		respond(w, r, opts, func() error {
+			return opts.Preload(db).First(&service, "service_name = ?", name).Error
 		}, &service)
 	default:
-		// Fetch paginated and sorted results
-		fetchAndRespond(w, func() error {
-			query := db.Offset(offset).Limit(limitInt).Order(sortBy + " " + order)
-			if loadVersion == "true" {
-				query = query.Preload("Versions")
-			}
-			return query.Find(&services).Error
+		// Fetch filtered, paginated and sorted results
+		respond(w, r, opts, func() error {
+			return opts.Preload(listQuery.Apply(db, serviceQuerySchema)).Find(&services).Error
 		}, &services)
 	}
 }
 
 // UpdateService updates an existing service in the database based on the provided JSON payload.
 func UpdateService(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 
 	var service Service
-	if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &service) {
 		return
 	}
 
 	// Ensure ID is provided
 	if service.ID == 0 {
-		http.Error(w, "ID is required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "ID is required",
+			FieldError{Field: "id", Code: "required", Message: "is required"})
 		return
 	}
 
 	// Check if the service exists
 	var existingService Service
 	if db.First(&existingService, service.ID).Error != nil {
-		http.Error(w, "Service not found", http.StatusNotFound)
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Service not found")
+		return
+	}
+
+	// Require the client to have seen the current revision
+	if rej := checkIfMatch(r, &existingService); rej != nil {
+		writeRejection(w, r, rej)
 		return
 	}
 
-	if err := db.Save(&service).Error; err != nil {
-		http.Error(w, "Failed to update service", http.StatusInternalServerError)
-		log.Printf("Error updating service: %v", err)
+	if !validatePayload(w, r, &service) {
+		return
+	}
+
+	service.CreatedAt = existingService.CreatedAt
+	if err := updateWithRevision(db, &service, existingService.Revision); err != nil {
+		if errors.Is(err, errRevisionMismatch) {
+			writeRevisionMismatch(w, r)
+			return
+		}
+		if writeUniqueViolation(w, r, err, "Service already exists") {
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to update service")
+		requestLogger(r).Error("Error updating service", "error", err)
 		return
 	}
 
+	w.Header().Set("ETag", service.ETag())
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(service)
 }
 
+// GetServiceVersions fetches service versions based on query parameters and responds with the results.
+//
+// List requests accept the filter and sort grammar described on ParseListQuery, e.g. "?service_id=1&sort=-created_at".
+func GetServiceVersions(w http.ResponseWriter, r *http.Request) {
+	db := GetDBInstance().WithContext(r.Context())
+	var versions []ServiceVersion
+
+	listQuery, err := ParseListQuery(r, serviceVersionQuerySchema)
+	if err != nil {
+		writeQueryProblem(w, r, err)
+		return
+	}
+	opts, err := ParseResponseOptions(r, serviceVersionResourceSpec)
+	if err != nil {
+		writeQueryProblem(w, r, err)
+		return
+	}
+
+	respond(w, r, opts, func() error {
+		return listQuery.Apply(db, serviceVersionQuerySchema).Find(&versions).Error
+	}, &versions)
+}
+
 func CreateServiceVersion(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 
 	var version ServiceVersion
-	if err := json.NewDecoder(r.Body).Decode(&version); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &version) {
 		return
 	}
 
-	// Validate that ServiceID is provided
+	// ServiceID is checked here rather than by tag, since versions nested in CreateService get theirs from GORM
+	fieldErrors := validationErrors(&version)
 	if version.ServiceID == 0 {
-		http.Error(w, "ServiceID is required", http.StatusBadRequest)
+		fieldErrors = append(fieldErrors, FieldError{Field: "service_id", Code: "required", Message: "is required"})
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
 		return
 	}
 
 	// Check if the service exists
 	var service Service
 	if err := db.First(&service, version.ServiceID).Error; err != nil {
-		http.Error(w, "Service not found", http.StatusNotFound)
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Service not found")
 		return
 	}
 
-	// Check if the version already exists
-	var existingVersion ServiceVersion
-	if err := db.Where("service_version_name = ? AND service_id = ?", version.ServiceVersionName, version.ServiceID).First(&existingVersion).Error; err == nil {
-		http.Error(w, "Version already exists", http.StatusConflict)
-		return
-	}
-
-	// Create the new version
-	if err := db.Create(&version).Error; err != nil {
-		http.Error(w, "Failed to create version", http.StatusInternalServerError)
-		log.Printf("Error creating version: %v", err)
+	// Create the new version, duplicates are rejected by the unique index
+	if err := createResource(db, &version); err != nil {
+		if writeUniqueViolation(w, r, err, "Version already exists") {
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to create version")
+		requestLogger(r).Error("Error creating version", "error", err)
 		return
 	}
 
+	w.Header().Set("ETag", version.ETag())
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(version)
 }
 
 func UpdateServiceVersion(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 
 	var version ServiceVersion
-	if err := json.NewDecoder(r.Body).Decode(&version); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &version) {
 		return
 	}
 
 	// Ensure ID is provided
 	if version.ID == 0 {
-		http.Error(w, "ID is required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "ID is required",
+			FieldError{Field: "id", Code: "required", Message: "is required"})
 		return
 	}
 
 	// Check if the version exists
 	var existingVersion ServiceVersion
 	if err := db.First(&existingVersion, version.ID).Error; err != nil {
-		http.Error(w, "Version not found", http.StatusNotFound)
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Version not found")
+		return
+	}
+
+	// Require the client to have seen the current revision
+	if rej := checkIfMatch(r, &existingVersion); rej != nil {
+		writeRejection(w, r, rej)
 		return
 	}
 
@@ -284,18 +224,30 @@ func UpdateServiceVersion(w http.ResponseWriter, r *http.Request) {
 	existingVersion.ServiceVersionDescription = version.ServiceVersionDescription
 	existingVersion.ServiceVersionURL = version.ServiceVersionURL
 
-	if err := db.Save(&existingVersion).Error; err != nil {
-		http.Error(w, "Failed to update version", http.StatusInternalServerError)
-		log.Printf("Error updating version: %v", err)
+	if !validatePayload(w, r, &existingVersion) {
 		return
 	}
 
+	if err := updateWithRevision(db, &existingVersion, existingVersion.Revision); err != nil {
+		if errors.Is(err, errRevisionMismatch) {
+			writeRevisionMismatch(w, r)
+			return
+		}
+		if writeUniqueViolation(w, r, err, "Version already exists") {
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to update version")
+		requestLogger(r).Error("Error updating version", "error", err)
+		return
+	}
+
+	w.Header().Set("ETag", existingVersion.ETag())
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(existingVersion)
 }
 
 func DeleteServiceVersion(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 
 	// Parse query parameters
 	id := r.URL.Query().Get("id")
@@ -306,7 +258,7 @@ func DeleteServiceVersion(w http.ResponseWriter, r *http.Request) {
 		parsedID, err := strconv.ParseUint(id, 10, 32)
 		idInt = uint(parsedID)
 		if err != nil {
-			http.Error(w, "Invalid ID parameter", http.StatusBadRequest)
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid ID parameter")
 			return
 		}
 	}
@@ -315,53 +267,59 @@ func DeleteServiceVersion(w http.ResponseWriter, r *http.Request) {
 	var version ServiceVersion
 	if id != "" {
 		if err := db.First(&version, idInt).Error; err != nil {
-			http.Error(w, "Resource not found", http.StatusNotFound)
+			writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
 			return
 		}
 	} else {
-		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "ID parameter is required")
 		return
 	}
 
-	// Perform delete
-	if err := db.Delete(&version).Error; err != nil {
-		http.Error(w, "Failed to delete version", http.StatusInternalServerError)
-		log.Printf("Error deleting version: %v", err)
+	// Require the client to have seen the current revision
+	if rej := checkIfMatch(r, &version); rej != nil {
+		writeRejection(w, r, rej)
 		return
 	}
 
+	// Perform delete
+	if err := deleteWithRevision(db, &version, version.Revision); err != nil {
+		if errors.Is(err, errRevisionMismatch) {
+			writeRevisionMismatch(w, r)
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to delete version")
+		requestLogger(r).Error("Error deleting version", "error", err)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 // CreateService creates a new service in the database based on the provided JSON payload.
 func CreateService(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 
 	var service Service
-	if err := json.NewDecoder(r.Body).Decode(&service); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
-		return
-	}
-
-	// Check if the service already exists
-	var existingService Service
-	if db.Where("service_name = ?", service.ServiceName).First(&existingService).Error == nil {
-		http.Error(w, "Service already exists", http.StatusConflict)
+	if !decodeJSONBody(w, r, &service) || !validatePayload(w, r, &service) {
 		return
 	}
 
-	if err := db.Create(&service).Error; err != nil {
-		http.Error(w, "Failed to create service", http.StatusInternalServerError)
-		log.Printf("Error creating service: %v", err)
+	// Duplicates are rejected by the unique constraint on service_name
+	if err := createResource(db, &service); err != nil {
+		if writeUniqueViolation(w, r, err, "Service already exists") {
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to create service")
+		requestLogger(r).Error("Error creating service", "error", err)
 		return
 	}
 
+	w.Header().Set("ETag", service.ETag())
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(service)
 }
 
 func DeleteService(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 
 	// Parse query parameters
 	id := r.URL.Query().Get("id")
@@ -372,7 +330,7 @@ func DeleteService(w http.ResponseWriter, r *http.Request) {
 	var err error
 	if id != "" {
 		idInt, err = strconv.Atoi(id)
-		if handleDBQueryError(w, err, "Invalid ID parameter", http.StatusBadRequest) {
+		if handleDBQueryError(w, r, err, "Invalid ID parameter", http.StatusBadRequest) {
 			return
 		}
 	}
@@ -381,32 +339,43 @@ func DeleteService(w http.ResponseWriter, r *http.Request) {
 	var service Service
 	if id != "" {
 		if db.First(&service, idInt).Error != nil {
-			http.Error(w, "Resource not found", http.StatusNotFound)
+			writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
 			return
 		}
 	} else if name != "" {
 		if db.Where("service_name = ?", name).First(&service).Error != nil {
-			http.Error(w, "Resource not found", http.StatusNotFound)
+			writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
 			return
 		}
 	} else {
-		http.Error(w, "ID or name parameter is required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "ID or name parameter is required")
 		return
 	}
 
-	// Perform soft delete
-	if err := db.Delete(&service).Error; err != nil {
-		http.Error(w, "Failed to delete service", http.StatusInternalServerError)
-		log.Printf("Error deleting service: %v", err)
+	// Require the client to have seen the current revision
+	if rej := checkIfMatch(r, &service); rej != nil {
+		writeRejection(w, r, rej)
 		return
 	}
 
+	// Perform soft delete
+	if err := deleteWithRevision(db, &service, service.Revision); err != nil {
+		if errors.Is(err, errRevisionMismatch) {
+			writeRevisionMismatch(w, r)
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to delete service")
+		requestLogger(r).Error("Error deleting service", "error", err)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 // GetUsers fetches user data from the database based on query parameters and responds with the results.
+//
+// List requests accept the filter and sort grammar described on ParseListQuery, e.g. "?role=admin&sort=-created_at".
 func GetUsers(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 	var users []User
 	var user User
 
@@ -415,83 +384,110 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 	username := queryParams.Get("username")
 	id := queryParams.Get("id")
 
+	listQuery, err := ParseListQuery(r, userQuerySchema)
+	if err != nil {
+		writeQueryProblem(w, r, err)
+		return
+	}
+	opts, err := ParseResponseOptions(r, userResourceSpec)
+	if err != nil {
+		writeQueryProblem(w, r, err)
+		return
+	}
+
 	// Fetch data based on query parameters
 	if id != "" {
 		// Get user by ID
-		fetchAndRespond(w, func() error { return db.First(&user, "id = ?", id).Error }, &user)
+		respond(w, r, opts, func() error { return opts.Preload(db).First(&user, "id = ?", id).Error }, &user)
 		return
 	}
 	if username != "" {
 		// Get user by username
-		fetchAndRespond(w, func() error { return db.First(&user, "user_name = ?", username).Error }, &user)
+		respond(w, r, opts, func() error { return opts.Preload(db).First(&user, "username = ?", username).Error }, &user)
 	} else {
-		// Get all users
-		fetchAndRespond(w, func() error { return db.Find(&users).Error }, &users)
+		// Get filtered, paginated and sorted users
+		respond(w, r, opts, func() error { return opts.Preload(listQuery.Apply(db, userQuerySchema)).Find(&users).Error }, &users)
 	}
 }
 
 // UpdateUser updates an existing user in the database based on the provided JSON payload.
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 
 	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+	if !decodeJSONBody(w, r, &user) {
 		return
 	}
 
 	// Ensure ID is provided
 	if user.ID == 0 {
-		http.Error(w, "ID is required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "ID is required",
+			FieldError{Field: "id", Code: "required", Message: "is required"})
 		return
 	}
 
 	// Check if the user exists
 	var existingUser User
 	if db.First(&existingUser, user.ID).Error != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "User not found")
+		return
+	}
+
+	// Require the client to have seen the current revision
+	if rej := checkIfMatch(r, &existingUser); rej != nil {
+		writeRejection(w, r, rej)
+		return
+	}
+
+	if !validatePayload(w, r, &user) {
 		return
 	}
 
-	if err := db.Save(&user).Error; err != nil {
-		http.Error(w, "Failed to update user", http.StatusInternalServerError)
-		log.Printf("Error updating user: %v", err)
+	user.CreatedAt = existingUser.CreatedAt
+	if err := updateWithRevision(db, &user, existingUser.Revision); err != nil {
+		if errors.Is(err, errRevisionMismatch) {
+			writeRevisionMismatch(w, r)
+			return
+		}
+		if writeUniqueViolation(w, r, err, "User already exists") {
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to update user")
+		requestLogger(r).Error("Error updating user", "error", err)
 		return
 	}
 
+	w.Header().Set("ETag", user.ETag())
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(user)
 }
 
 // CreateUser creates a new user in the database based on the provided JSON payload.
 func CreateUser(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 
 	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
-		return
-	}
-
-	// Check if the user already exists
-	var existingUser User
-	if db.Where("username = ?", user.Username).First(&existingUser).Error == nil {
-		http.Error(w, "User already exists", http.StatusConflict)
+	if !decodeJSONBody(w, r, &user) || !validatePayload(w, r, &user) {
 		return
 	}
 
-	if err := db.Create(&user).Error; err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
-		log.Printf("Error creating user: %v", err)
+	// Duplicates are rejected by the unique constraints on username and profile email
+	if err := createResource(db, &user); err != nil {
+		if writeUniqueViolation(w, r, err, "User already exists") {
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to create user")
+		requestLogger(r).Error("Error creating user", "error", err)
 		return
 	}
 
+	w.Header().Set("ETag", user.ETag())
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(user)
 }
 
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	db := GetDBInstance()
+	db := GetDBInstance().WithContext(r.Context())
 
 	// Parse query parameters
 	id := r.URL.Query().Get("id")
@@ -502,7 +498,7 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	var err error
 	if id != "" {
 		idInt, err = strconv.Atoi(id)
-		if handleDBQueryError(w, err, "Invalid ID parameter", http.StatusBadRequest) {
+		if handleDBQueryError(w, r, err, "Invalid ID parameter", http.StatusBadRequest) {
 			return
 		}
 	}
@@ -511,23 +507,33 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	var user User
 	if id != "" {
 		if db.First(&user, idInt).Error != nil {
-			http.Error(w, "Resource not found", http.StatusNotFound)
+			writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
 			return
 		}
 	} else if username != "" {
 		if db.Where("username = ?", username).First(&user).Error != nil {
-			http.Error(w, "Resource not found", http.StatusNotFound)
+			writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
 			return
 		}
 	} else {
-		http.Error(w, "ID or username parameter is required", http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "ID or username parameter is required")
+		return
+	}
+
+	// Require the client to have seen the current revision
+	if rej := checkIfMatch(r, &user); rej != nil {
+		writeRejection(w, r, rej)
 		return
 	}
 
 	// Perform soft delete
-	if err := db.Delete(&user).Error; err != nil {
-		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
-		log.Printf("Error deleting user: %v", err)
+	if err := deleteWithRevision(db, &user, user.Revision); err != nil {
+		if errors.Is(err, errRevisionMismatch) {
+			writeRevisionMismatch(w, r)
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to delete user")
+		requestLogger(r).Error("Error deleting user", "error", err)
 		return
 	}
 
@@ -23,10 +23,22 @@ func TestGetHandlers(t *testing.T) {
 		{"TestGetAllServicesWithServiceVersion", "GET", "/services?load_version=true", GetServices, http.StatusOK, "Service 1 Version 1"},
 		{"TestGetAllServicesWithPagination", "GET", "/services?page=1&limit=1", GetServices, http.StatusOK, "Service 1"},
 		{"TestGetAllServicesWithPaginationSecondPage", "GET", "/services?page=2&limit=1", GetServices, http.StatusOK, "Service 2"},
-		{"TestGetAllServicesWithSorting", "GET", "/services?sortBy=service_name&order=desc", GetServices, http.StatusOK, "Service 2"},
+		{"TestGetAllServicesWithSorting", "GET", "/services?sort_by=service_name&order=desc", GetServices, http.StatusOK, "Service 2"},
 		{"TestGetAllServicesWithInvalidSorting", "GET", "/services?sort_by=invalid&order=desc", GetServices, http.StatusBadRequest, ""},
 		{"TestGetAllServicesWithInvalidOrder", "GET", "/services?sort_by=service_name&order=invalid", GetServices, http.StatusBadRequest, ""},
+		{"TestGetAllServicesWithMultiKeySort", "GET", "/services?sort=-updated_at,service_name", GetServices, http.StatusOK, "Service"},
+		{"TestGetAllServicesWithComparisonFilter", "GET", "/services?created_at>=2000-01-01&has_versions=true", GetServices, http.StatusOK, "Service 1"},
+		{"TestGetAllServicesWithUnknownFilter", "GET", "/services?owner=alice", GetServices, http.StatusBadRequest, "unknown filter field"},
+		{"TestGetAllServicesWithUnknownSortField", "GET", "/services?sort=-owner", GetServices, http.StatusBadRequest, "unknown sort field"},
+		{"TestGetAllServicesWithSparseFields", "GET", "/services?fields=id,service_name", GetServices, http.StatusOK, `"service_name":"Service 1"`},
+		{"TestGetAllServicesWithExpandVersions", "GET", "/services?expand=versions", GetServices, http.StatusOK, "Service 1 Version 1"},
+		{"TestGetAllServicesWithUnknownExpansion", "GET", "/services?expand=owner", GetServices, http.StatusBadRequest, "unknown relationship"},
 		{"TestGetAllUsers", "GET", "/users", GetUsers, http.StatusOK, "user1"},
+		{"TestGetUsersWithExpandProfile", "GET", "/users?username=user1&expand=user_profile", GetUsers, http.StatusOK, "abc@gmail.com"},
+		{"TestGetUsersByRole", "GET", "/users?role=admin&sort=-created_at", GetUsers, http.StatusOK, "user2"},
+		{"TestGetUserByUsername", "GET", "/users?username=user1", GetUsers, http.StatusOK, "user1"},
+		{"TestGetServiceVersions", "GET", "/service_versions?sort=service_version_name", GetServiceVersions, http.StatusOK, "Service 1 Version 1"},
+		{"TestGetServiceVersionsWithInvalidOperator", "GET", "/service_versions?service_version_name>=a", GetServiceVersions, http.StatusBadRequest, "not supported"},
 		{"TestGetServiceByIdNotFound", "GET", "/services?id=10000", GetServices, http.StatusNotFound, ""},
 		{"TestGetUserByIdNotFound", "GET", "/users?id=10000", GetUsers, http.StatusNotFound, ""},
 		{"TestSearchServicesByServiceName", "GET", "/services?search_mode=true&name=1", GetServices, http.StatusOK, "Service 1"},
@@ -53,6 +65,28 @@ func TestGetHandlers(t *testing.T) {
 	}
 }
 
+func TestConditionalGet(t *testing.T) {
+	for _, url := range []string{"/services", "/services?name=Service%201"} {
+		t.Run(url, func(t *testing.T) {
+			req, err := http.NewRequest("GET", url, nil)
+			assert.NoError(t, err)
+			rr := httptest.NewRecorder()
+			GetServices(rr, req)
+			assert.Equal(t, http.StatusOK, rr.Code)
+			etag := rr.Header().Get("ETag")
+			assert.NotEmpty(t, etag)
+
+			req, err = http.NewRequest("GET", url, nil)
+			assert.NoError(t, err)
+			req.Header.Set("If-None-Match", etag)
+			rr = httptest.NewRecorder()
+			GetServices(rr, req)
+			assert.Equal(t, http.StatusNotModified, rr.Code)
+			assert.Empty(t, rr.Body.String())
+		})
+	}
+}
+
 func TestServiceMutateHandlers(t *testing.T) {
 	// Seed database for delete operation
 	db := GetDBInstance()
@@ -82,25 +116,32 @@ func TestServiceMutateHandlers(t *testing.T) {
 		statusCode int
 		body       string
 		payload    string
+		ifMatch    string
 	}{
-		{"TestCreateService", "POST", "/services", CreateService, http.StatusCreated, "Service 5", `{"service_name": "Service 5", "service_description": "Service 5 Description"}`},
-		{"TestCreateServiceAlreadyCreated", "POST", "/services", CreateService, http.StatusConflict, "Service already exists", `{"service_name": "Service 1", "service_description": "Service 1 Description"}`},
-		{"TestCreateServiceWithInvalidJsonPayload", "POST", "/services", CreateService, http.StatusBadRequest, "Invalid JSON payload", "invalid json"},
-		{"TestUpdateService", "PUT", "/services", UpdateService, http.StatusOK, "Service 1 Updated", `{"id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_name": "Service 1 Updated"}`},
-		{"TestUpdateServiceNotFound", "PUT", "/services", UpdateService, http.StatusNotFound, "Service not found", `{"id": 10000, "service_name": "Non-existent Service"}`},
-		{"TestUpdateServiceInvalidJsonPayload", "PUT", "/services", UpdateService, http.StatusBadRequest, "Invalid JSON payload", "invalid json"},
-		{"TestUpdateServiceInternalServerError", "PUT", "/services", UpdateService, http.StatusInternalServerError, "Failed to update service", `{"id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_name": "Service 1"}`},
-		{"TestDeleteService", "DELETE", "/services?id=" + fmt.Sprint(serviceForDeletion.ID), DeleteService, http.StatusOK, "", ""},
-		{"TestDeleteServiceByName", "DELETE", "/services?name=ServiceForDeletion2", DeleteService, http.StatusOK, "", ""},
-		{"TestDeleteServiceNotFound", "DELETE", "/services?id=10000", DeleteService, http.StatusNotFound, "Resource not found", ""},
-		{"TestCreateServiceVersion", "POST", "/service_versions", CreateServiceVersion, http.StatusCreated, "Service 1 Version 2", `{"service_id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_version_name": "Service 1 Version 2", "service_version_url": "http://service1.com", "service_version_description": "Service 1 Version 2 Description"}`},
-		{"TestCreateServiceVersionInvalidJsonPayload", "POST", "/service_versions", CreateServiceVersion, http.StatusBadRequest, "Invalid JSON payload", "invalid json"},
-		{"TestCreateServiceVersionServiceNotFound", "POST", "/service_versions", CreateServiceVersion, http.StatusNotFound, "Service not found", `{"service_id": 10000, "service_version_name": "Service 1 Version 2", "service_version_url": "http://service1.com", "service_version_description": "Service 1 Version 2 Description"}`},
-		{"TestUpdateServiceVersion", "PUT", "/service_versions", UpdateServiceVersion, http.StatusOK, "Service 1 Version 1", `{"id": ` + fmt.Sprint(serviceVersionForUpdate.ID) + `, "service_id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_version_name": "Service 1 Version 1"}`},
-		{"TestUpdateServiceVersionNotFound", "PUT", "/service_versions", UpdateServiceVersion, http.StatusNotFound, "Version not found", `{"id": 10000, "service_version_name": "Non-existent Service Version"}`},
-		{"TestUpdateServiceVersionInvalidJsonPayload", "PUT", "/service_versions", UpdateServiceVersion, http.StatusBadRequest, "Invalid JSON payload", "invalid json"},
-		{"TestDeleteServiceVersion", "DELETE", "/service_versions?id=" + fmt.Sprint(serviceVersionForDeletion.ID), DeleteServiceVersion, http.StatusOK, "", ""},
-		{"TestDeleteServiceVersionNotFound", "DELETE", "/service_versions?id=10000", DeleteServiceVersion, http.StatusNotFound, "Resource not found", ""},
+		{"TestCreateService", "POST", "/services", CreateService, http.StatusCreated, "Service 5", `{"service_name": "Service 5", "service_description": "Service 5 Description"}`, ""},
+		{"TestCreateServiceAlreadyCreated", "POST", "/services", CreateService, http.StatusConflict, "Service already exists", `{"service_name": "Service 1", "service_description": "Service 1 Description"}`, ""},
+		{"TestCreateServiceWithInvalidJsonPayload", "POST", "/services", CreateService, http.StatusBadRequest, "Invalid JSON payload", "invalid json", ""},
+		{"TestCreateServiceWithEmptyName", "POST", "/services", CreateService, http.StatusUnprocessableEntity, `"field":"service_name"`, `{"service_name": "", "service_description": "No name"}`, ""},
+		{"TestCreateServiceWithUnknownField", "POST", "/services", CreateService, http.StatusUnprocessableEntity, `"code":"unknown_field"`, `{"service_name": "Service 6", "owner": "alice"}`, ""},
+		{"TestUpdateService", "PUT", "/services", UpdateService, http.StatusOK, "Service 1 Updated", `{"id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_name": "Service 1 Updated"}`, `"1"`},
+		{"TestUpdateServiceWithoutIfMatch", "PUT", "/services", UpdateService, http.StatusPreconditionRequired, "precondition_required", `{"id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_name": "Service 1 Updated"}`, ""},
+		{"TestUpdateServiceWithStaleIfMatch", "PUT", "/services", UpdateService, http.StatusPreconditionFailed, "precondition_failed", `{"id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_name": "Service 1 Updated"}`, `"1"`},
+		{"TestUpdateServiceNotFound", "PUT", "/services", UpdateService, http.StatusNotFound, "Service not found", `{"id": 10000, "service_name": "Non-existent Service"}`, ""},
+		{"TestUpdateServiceInvalidJsonPayload", "PUT", "/services", UpdateService, http.StatusBadRequest, "Invalid JSON payload", "invalid json", ""},
+		{"TestUpdateServiceNameTaken", "PUT", "/services", UpdateService, http.StatusConflict, `"field":"service_name"`, `{"id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_name": "Service 1"}`, `"2"`},
+		{"TestDeleteService", "DELETE", "/services?id=" + fmt.Sprint(serviceForDeletion.ID), DeleteService, http.StatusOK, "", "", `"1"`},
+		{"TestDeleteServiceByName", "DELETE", "/services?name=ServiceForDeletion2", DeleteService, http.StatusOK, "", "", `"1"`},
+		{"TestDeleteServiceNotFound", "DELETE", "/services?id=10000", DeleteService, http.StatusNotFound, "Resource not found", "", ""},
+		{"TestCreateServiceVersion", "POST", "/service_versions", CreateServiceVersion, http.StatusCreated, "Service 1 Version 2", `{"service_id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_version_name": "Service 1 Version 2", "service_version_url": "http://service1.com", "service_version_description": "Service 1 Version 2 Description"}`, ""},
+		{"TestCreateServiceVersionInvalidURL", "POST", "/service_versions", CreateServiceVersion, http.StatusUnprocessableEntity, `"field":"service_version_url"`, `{"service_id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_version_name": "Service 1 Version 3", "service_version_url": "not a url"}`, ""},
+		{"TestCreateServiceVersionMissingFields", "POST", "/service_versions", CreateServiceVersion, http.StatusUnprocessableEntity, `"field":"service_id"`, `{"service_version_url": "http://service1.com"}`, ""},
+		{"TestCreateServiceVersionInvalidJsonPayload", "POST", "/service_versions", CreateServiceVersion, http.StatusBadRequest, "Invalid JSON payload", "invalid json", ""},
+		{"TestCreateServiceVersionServiceNotFound", "POST", "/service_versions", CreateServiceVersion, http.StatusNotFound, "Service not found", `{"service_id": 10000, "service_version_name": "Service 1 Version 2", "service_version_url": "http://service1.com", "service_version_description": "Service 1 Version 2 Description"}`, ""},
+		{"TestUpdateServiceVersion", "PUT", "/service_versions", UpdateServiceVersion, http.StatusOK, "Service 1 Version 1", `{"id": ` + fmt.Sprint(serviceVersionForUpdate.ID) + `, "service_id": ` + fmt.Sprint(serviceForUpdate.ID) + `, "service_version_name": "Service 1 Version 1"}`, `"1"`},
+		{"TestUpdateServiceVersionNotFound", "PUT", "/service_versions", UpdateServiceVersion, http.StatusNotFound, "Version not found", `{"id": 10000, "service_version_name": "Non-existent Service Version"}`, ""},
+		{"TestUpdateServiceVersionInvalidJsonPayload", "PUT", "/service_versions", UpdateServiceVersion, http.StatusBadRequest, "Invalid JSON payload", "invalid json", ""},
+		{"TestDeleteServiceVersion", "DELETE", "/service_versions?id=" + fmt.Sprint(serviceVersionForDeletion.ID), DeleteServiceVersion, http.StatusOK, "", "", `"2"`}, // TestUpdateServiceVersion bumped the revision
+		{"TestDeleteServiceVersionNotFound", "DELETE", "/service_versions?id=10000", DeleteServiceVersion, http.StatusNotFound, "Resource not found", "", ""},
 	}
 
 	for _, tt := range tests {
@@ -117,6 +158,9 @@ func TestServiceMutateHandlers(t *testing.T) {
 			}
 
 			assert.NoError(t, err)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
 
 			// Mock response recorder
 			rr := httptest.NewRecorder()
@@ -151,16 +195,19 @@ func TestUserMutateHandlers(t *testing.T) {
 		statusCode int
 		body       string
 		payload    string
+		ifMatch    string
 	}{
-		{"TestCreateUser", "POST", "/users", CreateUser, http.StatusCreated, "User 5", `{"username": "User 5", "password": "password", "role": "user"}`},
-		{"TestCreateUserAlreadyCreated", "POST", "/users", CreateUser, http.StatusConflict, "User already exists", `{"username": "user1", "password": "password", "role": "user"}`},
-		{"TestCreateUserWithInvalidJsonPayload", "POST", "/users", CreateUser, http.StatusBadRequest, "Invalid JSON payload", "invalid json"},
-		{"TestUpdateUser", "PUT", "/users", UpdateUser, http.StatusOK, "User 1 Updated", `{"id": ` + fmt.Sprint(userForUpdate.ID) + `, "username": "User 1 Updated"}`},
-		{"TestUpdateUserNotFound", "PUT", "/users", UpdateUser, http.StatusNotFound, "User not found", `{"id": 10000, "username": "Non-existent User"}`},
-		{"TestUpdateUserInvalidJsonPayload", "PUT", "/users", UpdateUser, http.StatusBadRequest, "Invalid JSON payload", "invalid json"},
-		{"TestDeleteUser", "DELETE", "/users?id=" + fmt.Sprint(userForDeletion.ID), DeleteUser, http.StatusOK, "", ""},
-		{"TestDeleteUserByName", "DELETE", "/users?username=UserForDeletion2", DeleteUser, http.StatusOK, "", ""},
-		{"TestDeleteUserNotFound", "DELETE", "/users?id=10000", DeleteUser, http.StatusNotFound, "Resource not found", ""},
+		{"TestCreateUser", "POST", "/users", CreateUser, http.StatusCreated, "User 5", `{"username": "User 5", "password": "password", "role": "user"}`, ""},
+		{"TestCreateUserAlreadyCreated", "POST", "/users", CreateUser, http.StatusConflict, "User already exists", `{"username": "user1", "password": "password", "role": "user"}`, ""},
+		{"TestCreateUserWithInvalidRole", "POST", "/users", CreateUser, http.StatusUnprocessableEntity, `"field":"role"`, `{"username": "User 6", "password": "password", "role": "superuser"}`, ""},
+		{"TestCreateUserWithInvalidEmail", "POST", "/users", CreateUser, http.StatusUnprocessableEntity, `"field":"user_profile.email"`, `{"username": "User 7", "password": "password", "role": "user", "user_profile": {"email": "not-an-email"}}`, ""},
+		{"TestCreateUserWithInvalidJsonPayload", "POST", "/users", CreateUser, http.StatusBadRequest, "Invalid JSON payload", "invalid json", ""},
+		{"TestUpdateUser", "PUT", "/users", UpdateUser, http.StatusOK, "User 1 Updated", `{"id": ` + fmt.Sprint(userForUpdate.ID) + `, "username": "User 1 Updated", "password": "password", "role": "user"}`, `"1"`},
+		{"TestUpdateUserNotFound", "PUT", "/users", UpdateUser, http.StatusNotFound, "User not found", `{"id": 10000, "username": "Non-existent User"}`, ""},
+		{"TestUpdateUserInvalidJsonPayload", "PUT", "/users", UpdateUser, http.StatusBadRequest, "Invalid JSON payload", "invalid json", ""},
+		{"TestDeleteUser", "DELETE", "/users?id=" + fmt.Sprint(userForDeletion.ID), DeleteUser, http.StatusOK, "", "", `"1"`},
+		{"TestDeleteUserByName", "DELETE", "/users?username=UserForDeletion2", DeleteUser, http.StatusOK, "", "", `"1"`},
+		{"TestDeleteUserNotFound", "DELETE", "/users?id=10000", DeleteUser, http.StatusNotFound, "Resource not found", "", ""},
 	}
 
 	for _, tt := range tests {
@@ -177,6 +224,9 @@ func TestUserMutateHandlers(t *testing.T) {
 			}
 
 			assert.NoError(t, err)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
 
 			// Mock response recorder
 			rr := httptest.NewRecorder()
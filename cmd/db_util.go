@@ -2,9 +2,8 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"os"
+	"log/slog"
 	"sync"
 
 	"github.com/golang-migrate/migrate/v4/database/postgres"
@@ -28,37 +27,27 @@ func GetDBInstance() *gorm.DB {
 		if err != nil {
 			log.Fatal("failed to connect database")
 		}
+		if err := registerTracingCallbacks(dbInstance); err != nil {
+			log.Fatal(err)
+		}
 	})
 	return dbInstance
 }
 
+// getDsn returns the connection string of the configured database.
 func getDsn() string {
-	dbHost := getEnv("SERVICE_DASHBOARD_DB_HOST", "host.docker.internal")
-	dbPort := getEnv("SERVICE_DASHBOARD_DB_PORT", "5432")
-	dbUser := getEnv("SERVICE_DASHBOARD_DB_USER", "postgres")
-	dbPassword := getEnv("SERVICE_DASHBOARD_DB_PASSWORD", "example")
-	dbName := getEnv("SERVICE_DASHBOARD_DB_NAME", "postgres")
-
-	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
+	return activeConfig().Database.DSN()
 }
 
-func getEnv(key, fallback string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		if key == "SERVICE_DASHBOARD_DB_HOST" && os.Getenv("UNIT_TEST") == "True" {
-			return "localhost"
-		}
-		log.Printf("Warning: %s is not set. Using default value '%s'.", key, fallback)
-		return fallback
-	}
-	return value
+// AutoMigrateModels lets GORM create or extend the tables of every model.
+func AutoMigrateModels(db *gorm.DB) error {
+	return db.AutoMigrate(&Service{}, &ServiceVersion{}, &User{}, &UserProfile{}, &IdempotencyRecord{}, &HealthCheck{}, &Webhook{}, &WebhookDelivery{}, &OutboxEntry{}, &RateLimitBucket{})
 }
 
 func InitDB() {
 	db := GetDBInstance()
 
-	db.AutoMigrate(&Service{}, &ServiceVersion{}, &User{}, &UserProfile{})
+	AutoMigrateModels(db)
 
 	sqlDB, err := db.DB()
 	if err != nil {
@@ -141,11 +130,11 @@ func GenerateDummyData(db *gorm.DB) {
 		if err := db.WithContext(ctx).Create(&services).Error; err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("Inserted services %+v", services)
+		slog.Debug("Inserted dummy services", "count", len(services))
 		if err := db.WithContext(ctx).Create(&users).Error; err != nil {
 			log.Fatal(err)
 		}
-		log.Printf("Inserted users: %+v", users)
+		slog.Debug("Inserted dummy users", "count", len(users))
 	}
 
 	return
@@ -19,7 +19,7 @@ func TestGenerateDummyData(t *testing.T) {
 	}
 
 	// Auto migrate the schema
-	if err := db.AutoMigrate(&Service{}, &ServiceVersion{}, &User{}, &UserProfile{}); err != nil {
+	if err := AutoMigrateModels(db); err != nil {
 		t.Fatalf("failed to migrate database: %v", err)
 	}
 
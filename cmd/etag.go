@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Error codes for conditional requests.
+const (
+	CodePreconditionRequired = "precondition_required"
+	CodePreconditionFailed   = "precondition_failed"
+)
+
+// errRevisionMismatch is returned when a row changed between reading it and writing it back.
+var errRevisionMismatch = errors.New("revision mismatch")
+
+// RevisionCounter is an optimistic concurrency counter embedded in mutable models.
+// It starts at 1, is bumped on every update and is exposed to clients as the ETag.
+type RevisionCounter struct {
+	Revision uint `gorm:"not null;default:1" json:"revision"`
+}
+
+// ETag returns the strong entity tag for the current revision.
+func (r RevisionCounter) ETag() string {
+	return fmt.Sprintf(`"%d"`, r.Revision)
+}
+
+func (r RevisionCounter) currentRevision() uint {
+	return r.Revision
+}
+
+func (r *RevisionCounter) setRevision(revision uint) {
+	r.Revision = revision
+}
+
+// revisioned is implemented by pointers to every model embedding RevisionCounter.
+type revisioned interface {
+	ETag() string
+	currentRevision() uint
+	setRevision(uint)
+}
+
+// checkIfMatch verifies the If-Match header of a write request against the current row.
+//
+// The header is required: a missing one is rejected with 428 and a stale one with 412.
+// "*" matches any existing row.
+func checkIfMatch(r *http.Request, current revisioned) *rejection {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return &rejection{status: http.StatusPreconditionRequired, code: CodePreconditionRequired, detail: "If-Match header is required"}
+	}
+	if !etagListMatches(header, current.ETag(), false) {
+		return &rejection{status: http.StatusPreconditionFailed, code: CodePreconditionFailed, detail: "Resource has been modified, current ETag is " + current.ETag()}
+	}
+	return nil
+}
+
+// etagListMatches reports whether the comma-separated header contains etag or "*".
+// Weak comparison ignores the W/ prefix, as required for If-None-Match.
+func etagListMatches(header, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyETag returns a weak entity tag derived from a response body, used for lists.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// updateWithRevision writes model back only if its row still has the expected revision, and bumps it.
+//
+// This closes the gap between reading a row and saving it: a concurrent writer makes the update
+// match no rows and errRevisionMismatch is returned. created_at and deleted_at are never overwritten.
+//
+// The updated event of a service, version or user is recorded in the outbox in the same transaction.
+func updateWithRevision(tx *gorm.DB, model revisioned, expected uint) error {
+	return writeWithRevision(tx, model, expected, "updated")
+}
+
+// writeWithRevision is updateWithRevision recording the event of action, e.g. "created" for a restored row.
+func writeWithRevision(tx *gorm.DB, model revisioned, expected uint, action string) error {
+	model.setRevision(expected + 1)
+	return tx.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(model).Where("revision = ?", expected).Select("*").Omit("created_at", "deleted_at").Updates(model)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRevisionMismatch
+		}
+		return recordChange(tx, model, action)
+	})
+}
+
+// deleteWithRevision soft-deletes model only if its row still has the expected revision.
+// The deleted event of a service, version or user is recorded in the outbox in the same transaction.
+func deleteWithRevision(tx *gorm.DB, model revisioned, expected uint) error {
+	return tx.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("revision = ?", expected).Delete(model)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRevisionMismatch
+		}
+		return recordChange(tx, model, "deleted")
+	})
+}
+
+// writeRevisionMismatch reports a lost race detected by updateWithRevision or deleteWithRevision.
+func writeRevisionMismatch(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, "Resource was modified concurrently")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEtagListMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{"Exact", `"3"`, `"3"`, false, true},
+		{"Mismatch", `"2"`, `"3"`, false, false},
+		{"List", `"1", "3"`, `"3"`, false, true},
+		{"Wildcard", `*`, `"3"`, false, true},
+		{"WeakIgnoredForStrongComparison", `W/"3"`, `"3"`, false, false},
+		{"WeakComparison", `W/"abc"`, `W/"abc"`, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, etagListMatches(tt.header, tt.etag, tt.weak))
+		})
+	}
+}
+
+func TestCheckIfMatch(t *testing.T) {
+	service := &Service{RevisionCounter: RevisionCounter{Revision: 3}}
+
+	tests := []struct {
+		name       string
+		ifMatch    string
+		wantStatus int
+	}{
+		{"Missing", "", http.StatusPreconditionRequired},
+		{"Stale", `"2"`, http.StatusPreconditionFailed},
+		{"Current", `"3"`, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("PUT", "/v1/services", nil)
+			assert.NoError(t, err)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			rej := checkIfMatch(req, service)
+			if tt.wantStatus == 0 {
+				assert.Nil(t, rej)
+			} else {
+				assert.Equal(t, tt.wantStatus, rej.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEventBufferSize is the number of recent events kept for Last-Event-ID resume.
+const DefaultEventBufferSize = 1000
+
+// eventHeartbeatInterval is how often an idle stream sends a comment, so proxies keep the connection open.
+const eventHeartbeatInterval = 15 * time.Second
+
+// eventTailLookback is how long the EventTailer reads skipped outbox IDs again. IDs are taken when an entry is
+// inserted but become visible when its transaction commits, so an entry may show up after one with a higher ID.
+const eventTailLookback = 30 * time.Second
+
+// subscriberBufferSize is the number of events queued for a slow client before its stream is closed.
+const subscriberBufferSize = 64
+
+// EventResetType is streamed first when a Last-Event-ID is no longer in the replay buffer, so the client
+// knows it missed events and should reload.
+const EventResetType = "stream.reset"
+
+// eventResources lists the resource types accepted by ?resource=.
+var eventResources = []string{"service", "service_version", "user"}
+
+// eventBroker fans events out to /v1/events streams. It is nil when sse is not in the outbox sinks.
+var eventBroker *EventBroker
+
+// EventBroker serves GET /v1/events. It keeps the last events in a ring buffer for Last-Event-ID resume and
+// hands every new event to the open streams.
+type EventBroker struct {
+	mu          sync.Mutex
+	size        int
+	buffer      []ChangeEvent
+	subscribers map[chan ChangeEvent]struct{}
+	closed      bool
+}
+
+// NewEventBroker returns a broker that keeps the last size events.
+func NewEventBroker(size int) *EventBroker {
+	return &EventBroker{size: size, subscribers: make(map[chan ChangeEvent]struct{})}
+}
+
+// Publish buffers the event and sends it to every stream. An event that is still buffered is not sent again.
+// Streams that fall behind are closed and resume from the buffer.
+func (b *EventBroker) Publish(event ChangeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, buffered := range b.buffer {
+		if buffered.ID == event.ID {
+			return
+		}
+	}
+	b.buffer = append(b.buffer, event)
+	if len(b.buffer) > b.size {
+		b.buffer = slices.Delete(b.buffer, 0, len(b.buffer)-b.size)
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe opens a stream. With a lastEventID it also returns the buffered events after it, and reports
+// whether the ID was found; without one every new event is sent.
+func (b *EventBroker) Subscribe(lastEventID string) ([]ChangeEvent, chan ChangeEvent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan ChangeEvent, subscriberBufferSize)
+	if b.closed {
+		close(ch)
+		return nil, ch, true
+	}
+	b.subscribers[ch] = struct{}{}
+
+	if lastEventID == "" {
+		return nil, ch, true
+	}
+	for i, event := range b.buffer {
+		if event.ID == lastEventID {
+			return slices.Clone(b.buffer[i+1:]), ch, true
+		}
+	}
+	return nil, ch, false
+}
+
+// Unsubscribe closes a stream opened by Subscribe.
+func (b *EventBroker) Unsubscribe(ch chan ChangeEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Close ends every stream and every stream opened later, so the server can shut down. Clients reconnect with
+// Last-Event-ID to another server, whose EventTailer buffered the same events.
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// EventTailer feeds the EventBroker of this server from the outbox. Every server tails the outbox on a cursor
+// of its own instead of taking part in the leased OutboxRelay, so each one streams every event, whichever
+// server relayed it.
+type EventTailer struct {
+	Broker       *EventBroker
+	PollInterval time.Duration
+
+	started bool
+	cursor  uint
+	// gaps are the runs of IDs below the cursor that were not visible yet
+	gaps []idGap
+}
+
+// idGap is a run of outbox IDs the EventTailer skipped over, read again until eventTailLookback passed.
+type idGap struct {
+	from, to  uint
+	skippedAt time.Time
+}
+
+// newEventTailer builds the tailer and the broker of GET /v1/events, or returns nil when sse is not in the
+// outbox sinks.
+func newEventTailer(config OutboxConfig) *EventTailer {
+	if !slices.Contains(config.Sinks, "sse") {
+		return nil
+	}
+	eventBroker = NewEventBroker(config.EventsBuffer)
+	return &EventTailer{Broker: eventBroker, PollInterval: config.PollInterval}
+}
+
+// Run tails the outbox until ctx is cancelled.
+func (t *EventTailer) Run(ctx context.Context) {
+	lifecycle.WorkerStarted("event_stream", t.PollInterval)
+	defer lifecycle.WorkerStopped("event_stream")
+
+	ticker := time.NewTicker(t.PollInterval)
+	defer ticker.Stop()
+	for {
+		err := t.TailOnce(ctx)
+		if err != nil {
+			slog.Error("Tailing the outbox failed", "error", err)
+		}
+		lifecycle.WorkerRan("event_stream", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TailOnce publishes the outbox entries written since the last call. The first call buffers the newest
+// entries, so a client moving over from another server can resume with its Last-Event-ID.
+func (t *EventTailer) TailOnce(ctx context.Context) error {
+	db := GetDBInstance().WithContext(ctx)
+	if !t.started {
+		var entries []OutboxEntry
+		if err := db.Order("id DESC").Limit(t.Broker.size).Find(&entries).Error; err != nil {
+			return err
+		}
+		slices.Reverse(entries)
+		for _, entry := range entries {
+			t.publish(entry)
+		}
+		if len(entries) > 0 {
+			t.cursor = entries[len(entries)-1].ID
+		}
+		t.started = true
+		return nil
+	}
+
+	now := time.Now()
+	t.gaps = slices.DeleteFunc(t.gaps, func(gap idGap) bool { return now.Sub(gap.skippedAt) > eventTailLookback })
+	for {
+		query := db.Where("id > ?", t.cursor)
+		for _, gap := range t.gaps {
+			query = query.Or("id BETWEEN ? AND ?", gap.from, gap.to)
+		}
+		var entries []OutboxEntry
+		if err := query.Order("id").Limit(outboxBatchSize).Find(&entries).Error; err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			t.advance(entry.ID, now)
+			t.publish(entry)
+		}
+		if len(entries) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// advance records that the entry with id was read at now: beyond the cursor it moves the cursor and remembers the
+// IDs skipped over as a gap, within a gap it splits the gap around id.
+func (t *EventTailer) advance(id uint, now time.Time) {
+	if id > t.cursor {
+		if id > t.cursor+1 {
+			t.gaps = append(t.gaps, idGap{from: t.cursor + 1, to: id - 1, skippedAt: now})
+		}
+		t.cursor = id
+		return
+	}
+	for i, gap := range t.gaps {
+		if id < gap.from || id > gap.to {
+			continue
+		}
+		var rest []idGap
+		if gap.from < id {
+			rest = append(rest, idGap{from: gap.from, to: id - 1, skippedAt: gap.skippedAt})
+		}
+		if id < gap.to {
+			rest = append(rest, idGap{from: id + 1, to: gap.to, skippedAt: gap.skippedAt})
+		}
+		t.gaps = slices.Replace(t.gaps, i, i+1, rest...)
+		return
+	}
+}
+
+func (t *EventTailer) publish(entry OutboxEntry) {
+	var event ChangeEvent
+	if err := json.Unmarshal(entry.Payload, &event); err != nil {
+		slog.Error("Skipping an outbox entry with an invalid payload", "event_id", entry.EventID, "error", err)
+		return
+	}
+	t.Broker.Publish(event)
+}
+
+// EventFilter selects the events sent to one stream.
+type EventFilter struct {
+	Resources []string
+	ServiceID uint
+	// Admin allows user events, which carry user management data.
+	Admin bool
+}
+
+// Matches reports whether the event passes the filter.
+func (f EventFilter) Matches(event ChangeEvent) bool {
+	resource, _, _ := strings.Cut(event.Type, ".")
+	if resource == "user" && !f.Admin {
+		return false
+	}
+	if len(f.Resources) > 0 && !slices.Contains(f.Resources, resource) {
+		return false
+	}
+	if f.ServiceID == 0 {
+		return true
+	}
+
+	// Services carry their ID and versions the ID of their service, users belong to no service
+	var data struct {
+		ID        uint `json:"ID"`
+		ServiceID uint `json:"service_id"`
+	}
+	json.Unmarshal(event.Data, &data)
+	switch resource {
+	case "service":
+		return data.ID == f.ServiceID
+	case "service_version":
+		return data.ServiceID == f.ServiceID
+	}
+	return false
+}
+
+// parseEventFilter reads ?resource= and ?service_id=. Only admins may ask for user events.
+func parseEventFilter(r *http.Request) (EventFilter, error) {
+	filter := EventFilter{Admin: RoleFromContext(r.Context()) == "admin"}
+	query := r.URL.Query()
+
+	for _, resource := range splitList(query.Get("resource")) {
+		if !slices.Contains(eventResources, resource) {
+			return filter, &QueryError{Param: "resource", Message: fmt.Sprintf("unknown resource %q, allowed: %s", resource, strings.Join(eventResources, ", "))}
+		}
+		filter.Resources = append(filter.Resources, resource)
+	}
+
+	if value := query.Get("service_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == 0 {
+			return filter, &QueryError{Param: "service_id", Message: "must be a positive integer"}
+		}
+		filter.ServiceID = uint(id)
+	}
+	return filter, nil
+}
+
+// StreamEvents streams catalog change events as Server-Sent Events until the client disconnects.
+//
+// Every event has the change event ID as its id, the event type as its event name and the same JSON body
+// as a webhook delivery as its data. A client that reconnects with Last-Event-ID first gets the buffered
+// events it missed; if that ID is no longer buffered, a stream.reset event is sent instead.
+func StreamEvents(w http.ResponseWriter, r *http.Request) {
+	if eventBroker == nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, CodeNotConfigured, "The event stream is not configured, add sse to SERVICE_DASHBOARD_OUTBOX_SINKS")
+		return
+	}
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		writeQueryProblem(w, r, err)
+		return
+	}
+	if slices.Contains(filter.Resources, "user") && !filter.Admin {
+		writeProblem(w, r, http.StatusForbidden, CodeForbidden, "Only admins may stream user events")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Streaming is not supported")
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	replay, ch, found := eventBroker.Subscribe(lastEventID)
+	defer eventBroker.Unsubscribe(ch)
+
+	// A stream lasts longer than any write timeout
+	disableWriteTimeout(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if !found {
+		fmt.Fprintf(w, "event: %s\ndata: {}\n\n", EventResetType)
+	}
+	for _, event := range replay {
+		if filter.Matches(event) {
+			writeServerSentEvent(w, event)
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-ch:
+			if !ok {
+				return
+			}
+			if !filter.Matches(event) {
+				continue
+			}
+			writeServerSentEvent(w, event)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event ChangeEvent) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func changeEvent(id, eventType string, data interface{}) ChangeEvent {
+	encoded, _ := json.Marshal(data)
+	return ChangeEvent{ID: id, Type: eventType, Data: encoded}
+}
+
+func TestEventBroker(t *testing.T) {
+	broker := NewEventBroker(3)
+	for i := 1; i <= 4; i++ {
+		broker.Publish(changeEvent(fmt.Sprint(i), EventServiceCreated, nil))
+	}
+	// Relaying an event again does not buffer it twice
+	broker.Publish(changeEvent("4", EventServiceCreated, nil))
+
+	replay, ch, found := broker.Subscribe("2")
+	assert.True(t, found)
+	assert.Equal(t, []string{"3", "4"}, []string{replay[0].ID, replay[1].ID})
+	broker.Unsubscribe(ch)
+
+	// The oldest event has left the buffer
+	_, ch, found = broker.Subscribe("1")
+	assert.False(t, found)
+
+	broker.Publish(changeEvent("5", EventServiceUpdated, nil))
+	assert.Equal(t, "5", (<-ch).ID)
+
+	// A stream that falls behind is closed
+	for i := 0; i <= subscriberBufferSize; i++ {
+		broker.Publish(changeEvent(fmt.Sprint("slow-", i), EventServiceUpdated, nil))
+	}
+	for range ch {
+	}
+	broker.Unsubscribe(ch)
+
+	// Closing ends open streams and streams opened later
+	_, ch, _ = broker.Subscribe("")
+	broker.Close()
+	_, ok := <-ch
+	assert.False(t, ok)
+	_, ch, _ = broker.Subscribe("")
+	_, ok = <-ch
+	assert.False(t, ok)
+}
+
+func TestEventTailer(t *testing.T) {
+	db := GetDBInstance()
+	assert.NoError(t, recordEvent(db, EventServiceUpdated, Service{ServiceName: "Tailed before"}))
+
+	// Servers tailing the same outbox each stream every event, and buffer the newest ones on start
+	first := &EventTailer{Broker: NewEventBroker(1)}
+	second := &EventTailer{Broker: NewEventBroker(10)}
+	for _, tailer := range []*EventTailer{first, second} {
+		assert.NoError(t, tailer.TailOnce(context.Background()))
+	}
+	assert.Len(t, first.Broker.buffer, 1)
+	assert.Contains(t, string(first.Broker.buffer[0].Data), "Tailed before")
+
+	_, ch, _ := second.Broker.Subscribe("")
+	assert.NoError(t, recordEvent(db, EventServiceUpdated, Service{ServiceName: "Tailed after"}))
+	for _, tailer := range []*EventTailer{first, second} {
+		assert.NoError(t, tailer.TailOnce(context.Background()))
+	}
+	assert.Contains(t, string(first.Broker.buffer[0].Data), "Tailed after")
+	assert.Contains(t, string((<-ch).Data), "Tailed after")
+
+	// Entries are published once
+	assert.NoError(t, second.TailOnce(context.Background()))
+	assert.Len(t, ch, 0)
+}
+
+func TestEventTailerGaps(t *testing.T) {
+	now := time.Now()
+	tailer := &EventTailer{cursor: 10}
+
+	// IDs skipped over are remembered however many there are
+	tailer.advance(5000, now)
+	assert.Equal(t, uint(5000), tailer.cursor)
+	assert.Equal(t, []idGap{{from: 11, to: 4999, skippedAt: now}}, tailer.gaps)
+
+	// Entries committed late split their gap
+	tailer.advance(11, now)
+	tailer.advance(100, now)
+	tailer.advance(4999, now)
+	assert.Equal(t, []idGap{{from: 12, to: 99, skippedAt: now}, {from: 101, to: 4998, skippedAt: now}}, tailer.gaps)
+	assert.Equal(t, uint(5000), tailer.cursor)
+
+	tailer.advance(5001, now)
+	assert.Len(t, tailer.gaps, 2, "the next ID leaves no gap")
+}
+
+func TestEventFilter(t *testing.T) {
+	service := changeEvent("1", EventServiceUpdated, Service{Model: gorm.Model{ID: 7}})
+	version := changeEvent("2", EventServiceVersionCreated, ServiceVersion{ServiceID: 7})
+	otherVersion := changeEvent("3", EventServiceVersionDeleted, ServiceVersion{ServiceID: 8})
+	user := changeEvent("4", EventUserCreated, map[string]string{"username": "alice"})
+
+	tests := []struct {
+		name    string
+		filter  EventFilter
+		matches []bool
+	}{
+		{"Admin", EventFilter{Admin: true}, []bool{true, true, true, true}},
+		{"User", EventFilter{}, []bool{true, true, true, false}},
+		{"Resource", EventFilter{Admin: true, Resources: []string{"service_version", "user"}}, []bool{false, true, true, true}},
+		{"ServiceID", EventFilter{Admin: true, ServiceID: 7}, []bool{true, true, false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var matches []bool
+			for _, event := range []ChangeEvent{service, version, otherVersion, user} {
+				matches = append(matches, tt.filter.Matches(event))
+			}
+			assert.Equal(t, tt.matches, matches)
+		})
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	defer func(previous *EventBroker) { eventBroker = previous }(eventBroker)
+	eventBroker = NewEventBroker(10)
+	eventBroker.Publish(changeEvent("1", EventServiceCreated, Service{Model: gorm.Model{ID: 1}}))
+	eventBroker.Publish(changeEvent("2", EventUserCreated, nil))
+	eventBroker.Publish(changeEvent("3", EventServiceUpdated, Service{Model: gorm.Model{ID: 1}}))
+
+	// Stands in for RoleBasedMiddleware
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), roleKey{}, r.Header.Get("X-Test-Role"))
+		StreamEvents(w, r.WithContext(ctx))
+	}))
+	defer server.Close()
+
+	stream := func(role, query, lastEventID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest("GET", server.URL+"/v1/events"+query, nil)
+		req.Header.Set("X-Test-Role", role)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return resp, bufio.NewReader(resp.Body)
+	}
+	next := func(reader *bufio.Reader) string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	t.Run("ResumeSkipsUserEvents", func(t *testing.T) {
+		resp, reader := stream("user", "", "1")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		assert.True(t, strings.HasPrefix(next(reader), "id: 3\nevent: service.updated\ndata: {"))
+	})
+
+	t.Run("AdminResume", func(t *testing.T) {
+		resp, reader := stream("admin", "", "1")
+		defer resp.Body.Close()
+		assert.Contains(t, next(reader), "event: user.created")
+		assert.Contains(t, next(reader), "event: service.updated")
+	})
+
+	t.Run("UnknownLastEventID", func(t *testing.T) {
+		resp, reader := stream("admin", "?resource=service", "gone")
+		defer resp.Body.Close()
+		assert.Equal(t, "event: stream.reset\ndata: {}\n", next(reader))
+
+		eventBroker.Publish(changeEvent("4", EventServiceVersionCreated, ServiceVersion{ServiceID: 1}))
+		eventBroker.Publish(changeEvent("5", EventServiceDeleted, Service{Model: gorm.Model{ID: 1}}))
+		assert.Contains(t, next(reader), "id: 5\n")
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		resp, _ := stream("user", "?resource=user", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+		resp, _ = stream("admin", "?service_id=abc", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
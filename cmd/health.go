@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Health statuses of a version, from its latest probe, and of a service, from the latest probes of its versions.
+const (
+	HealthUp       = "up"
+	HealthDown     = "down"
+	HealthDegraded = "degraded"
+	HealthUnknown  = "unknown"
+)
+
+// Prober defaults, each overridable with a SERVICE_DASHBOARD_PROBE_* variable.
+const (
+	DefaultProbeInterval       = time.Minute
+	DefaultProbeTimeout        = 5 * time.Second
+	DefaultProbeConcurrency    = 10
+	DefaultProbeExpectedStatus = "200-399"
+)
+
+// HealthRetention is how long probe results are kept, matching the longest uptime window.
+const HealthRetention = 30 * 24 * time.Hour
+
+// Health is the current status and uptime of a service or version, added to responses by ?expand=health.
+// The details of the latest probe are only set on versions.
+type Health struct {
+	Status     string     `json:"status"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+	StatusCode int        `json:"status_code,omitempty"`
+	LatencyMs  *int64     `json:"latency_ms,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Uptime     Uptime     `json:"uptime"`
+}
+
+// Uptime is the percentage of successful probes per window, or null when there were none.
+type Uptime struct {
+	Day   *float64 `json:"24h"`
+	Week  *float64 `json:"7d"`
+	Month *float64 `json:"30d"`
+}
+
+// StatusRanges is a set of inclusive HTTP status code ranges, parsed from a list like "200-299,301".
+type StatusRanges [][2]int
+
+// ParseStatusRanges parses a comma-separated list of status codes and code ranges.
+func ParseStatusRanges(s string) (StatusRanges, error) {
+	var ranges StatusRanges
+	for _, item := range splitList(s) {
+		low, high, isRange := strings.Cut(item, "-")
+		if !isRange {
+			high = low
+		}
+		from, err := strconv.Atoi(low)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q", low)
+		}
+		to, err := strconv.Atoi(high)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status code %q", high)
+		}
+		if from < 100 || to > 599 || from > to {
+			return nil, fmt.Errorf("invalid status range %q", item)
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no status codes given")
+	}
+	return ranges, nil
+}
+
+// Contains reports whether code falls in one of the ranges.
+func (r StatusRanges) Contains(code int) bool {
+	for _, bounds := range r {
+		if code >= bounds[0] && code <= bounds[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthProber periodically probes the URL of every service version and stores the results as HealthChecks.
+//
+// Versions with an http or https URL are probed; other protocols cannot be checked with an HTTP request.
+// A version's health_path, when set, replaces the path of its URL. A probe is healthy when it returns one of
+// the Expected status codes within the client timeout. Redirects are not followed.
+type HealthProber struct {
+	Client      *http.Client
+	Interval    time.Duration
+	Method      string
+	Expected    StatusRanges
+	Concurrency int
+}
+
+// newHealthProber builds the prober from its validated configuration, or returns nil when probing is
+// switched off.
+func newHealthProber(config ProbeConfig) *HealthProber {
+	if !config.Enabled {
+		return nil
+	}
+
+	expected, _ := ParseStatusRanges(config.ExpectedStatus)
+	return &HealthProber{
+		Client: &http.Client{
+			Timeout: config.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Interval:    config.Interval,
+		Method:      strings.ToUpper(config.Method),
+		Expected:    expected,
+		Concurrency: config.Concurrency,
+	}
+}
+
+// Run probes immediately and then on every interval until ctx is cancelled.
+func (p *HealthProber) Run(ctx context.Context) {
+	lifecycle.WorkerStarted("health_prober", p.Interval)
+	defer lifecycle.WorkerStopped("health_prober")
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		err := p.ProbeOnce(ctx)
+		if err != nil {
+			slog.Error("Health probing failed", "error", err)
+		}
+		lifecycle.WorkerRan("health_prober", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProbeOnce probes every version once, at most Concurrency at a time, stores the results and drops
+// results older than HealthRetention.
+func (p *HealthProber) ProbeOnce(ctx context.Context) error {
+	db := GetDBInstance().WithContext(ctx)
+
+	var versions []ServiceVersion
+	if err := db.Select("id", "service_version_url", "health_path").
+		Where("service_version_url LIKE ? OR service_version_url LIKE ?", "http://%", "https://%").
+		Find(&versions).Error; err != nil {
+		return err
+	}
+
+	checks := make([]HealthCheck, len(versions))
+	slots := make(chan struct{}, max(p.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, version := range versions {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			checks[i] = p.probe(ctx, version)
+		}()
+	}
+	wg.Wait()
+
+	if len(checks) > 0 {
+		if err := db.CreateInBatches(checks, 100).Error; err != nil {
+			return err
+		}
+	}
+	return db.Where("checked_at < ?", time.Now().Add(-HealthRetention)).Delete(&HealthCheck{}).Error
+}
+
+// probe sends one request to the version's health endpoint.
+func (p *HealthProber) probe(ctx context.Context, version ServiceVersion) HealthCheck {
+	check := HealthCheck{ServiceVersionID: version.ID, CheckedAt: time.Now()}
+
+	target, err := probeTarget(version)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	req, err := http.NewRequestWithContext(ctx, p.Method, target, nil)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	req.Header.Set("User-Agent", "kong-service-dashboard-prober")
+
+	resp, err := p.Client.Do(req)
+	check.LatencyMs = time.Since(check.CheckedAt).Milliseconds()
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	check.StatusCode = resp.StatusCode
+	check.Healthy = p.Expected.Contains(resp.StatusCode)
+	if !check.Healthy {
+		check.Error = "unexpected status " + resp.Status
+	}
+	return check
+}
+
+// probeTarget is the URL to probe: the version URL, with its path replaced by the health path when one is set.
+func probeTarget(version ServiceVersion) (string, error) {
+	base, err := url.Parse(version.ServiceVersionURL)
+	if err != nil {
+		return "", err
+	}
+	if version.HealthPath == "" {
+		return base.String(), nil
+	}
+	ref, err := url.Parse(version.HealthPath)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// loadHealth fills in the Health of fetched services or versions. A service also gets the health of
+// its versions when they were expanded.
+func loadHealth(data interface{}) error {
+	var services []*Service
+	var versions []*ServiceVersion
+	switch v := data.(type) {
+	case *Service:
+		services = append(services, v)
+	case *[]Service:
+		for i := range *v {
+			services = append(services, &(*v)[i])
+		}
+	case *ServiceVersion:
+		versions = append(versions, v)
+	case *[]ServiceVersion:
+		for i := range *v {
+			versions = append(versions, &(*v)[i])
+		}
+	default:
+		return fmt.Errorf("health cannot be loaded for %T", data)
+	}
+	for _, service := range services {
+		for i := range service.Versions {
+			versions = append(versions, &service.Versions[i])
+		}
+	}
+
+	db := GetDBInstance()
+	now := time.Now()
+
+	versionIDs := make([]uint, 0, len(versions))
+	for _, version := range versions {
+		versionIDs = append(versionIDs, version.ID)
+	}
+	serviceIDs := make([]uint, 0, len(services))
+	for _, service := range services {
+		serviceIDs = append(serviceIDs, service.ID)
+	}
+
+	// The status of a service comes from all of its versions, expanded or not
+	var serviceVersions []ServiceVersion
+	if len(serviceIDs) > 0 {
+		if err := db.Select("id", "service_id").Where("service_id IN ?", serviceIDs).Find(&serviceVersions).Error; err != nil {
+			return err
+		}
+	}
+	latestIDs := append([]uint(nil), versionIDs...)
+	for _, version := range serviceVersions {
+		latestIDs = append(latestIDs, version.ID)
+	}
+
+	latest, err := latestHealthChecks(db, latestIDs)
+	if err != nil {
+		return err
+	}
+	versionUptime, err := queryUptime(db, "service_versions.id", versionIDs, now)
+	if err != nil {
+		return err
+	}
+	serviceUptime, err := queryUptime(db, "service_versions.service_id", serviceIDs, now)
+	if err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		health := &Health{Status: HealthUnknown, Uptime: versionUptime[version.ID]}
+		if check, ok := latest[version.ID]; ok {
+			health.Status = HealthDown
+			if check.Healthy {
+				health.Status = HealthUp
+			}
+			health.CheckedAt = &check.CheckedAt
+			health.StatusCode = check.StatusCode
+			health.LatencyMs = &check.LatencyMs
+			health.Error = check.Error
+		}
+		version.Health = health
+	}
+
+	up := make(map[uint]int)
+	probed := make(map[uint]int)
+	for _, version := range serviceVersions {
+		if check, ok := latest[version.ID]; ok {
+			probed[version.ServiceID]++
+			if check.Healthy {
+				up[version.ServiceID]++
+			}
+		}
+	}
+	for _, service := range services {
+		health := &Health{Status: HealthUnknown, Uptime: serviceUptime[service.ID]}
+		switch {
+		case probed[service.ID] == 0:
+		case up[service.ID] == probed[service.ID]:
+			health.Status = HealthUp
+		case up[service.ID] == 0:
+			health.Status = HealthDown
+		default:
+			health.Status = HealthDegraded
+		}
+		service.Health = health
+	}
+	return nil
+}
+
+// latestHealthChecks returns the most recent check of each version.
+func latestHealthChecks(db *gorm.DB, versionIDs []uint) (map[uint]HealthCheck, error) {
+	latest := make(map[uint]HealthCheck)
+	if len(versionIDs) == 0 {
+		return latest, nil
+	}
+	var checks []HealthCheck
+	err := db.Raw(`SELECT DISTINCT ON (service_version_id) * FROM health_checks
+		WHERE service_version_id IN ? ORDER BY service_version_id, checked_at DESC`, versionIDs).Scan(&checks).Error
+	for _, check := range checks {
+		latest[check.ServiceVersionID] = check
+	}
+	return latest, err
+}
+
+// queryUptime computes the uptime of the probes of live versions, grouped by key, a trusted column of service_versions.
+func queryUptime(db *gorm.DB, key string, ids []uint, now time.Time) (map[uint]Uptime, error) {
+	uptime := make(map[uint]Uptime)
+	if len(ids) == 0 {
+		return uptime, nil
+	}
+
+	var rows []struct {
+		GroupKey uint
+		Day      *float64
+		Week     *float64
+		Month    *float64
+	}
+	const share = "AVG(CASE WHEN health_checks.healthy THEN 100.0 ELSE 0 END) FILTER (WHERE health_checks.checked_at >= ?)"
+	err := db.Table("health_checks").
+		Joins("JOIN service_versions ON service_versions.id = health_checks.service_version_id AND service_versions.deleted_at IS NULL").
+		Select(key+" AS group_key, "+share+" AS day, "+share+" AS week, "+share+" AS month",
+			now.Add(-24*time.Hour), now.Add(-7*24*time.Hour), now.Add(-HealthRetention)).
+		Where(key+" IN ? AND health_checks.checked_at >= ?", ids, now.Add(-HealthRetention)).
+		Group(key).
+		Scan(&rows).Error
+	for _, row := range rows {
+		uptime[row.GroupKey] = Uptime{Day: roundPercent(row.Day), Week: roundPercent(row.Week), Month: roundPercent(row.Month)}
+	}
+	return uptime, err
+}
+
+func roundPercent(value *float64) *float64 {
+	if value == nil {
+		return nil
+	}
+	rounded := math.Round(*value*100) / 100
+	return &rounded
+}
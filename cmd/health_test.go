@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestParseStatusRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected StatusRanges
+		err      bool
+	}{
+		{"SingleCode", "200", StatusRanges{{200, 200}}, false},
+		{"RangesAndCodes", "200-299, 301", StatusRanges{{200, 299}, {301, 301}}, false},
+		{"Empty", "", nil, true},
+		{"NotANumber", "2xx", nil, true},
+		{"Reversed", "299-200", nil, true},
+		{"OutOfRange", "200-600", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges, err := ParseStatusRanges(tt.value)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, ranges)
+		})
+	}
+
+	ranges, _ := ParseStatusRanges("200-299,301")
+	assert.True(t, ranges.Contains(204))
+	assert.True(t, ranges.Contains(301))
+	assert.False(t, ranges.Contains(302))
+}
+
+func TestProbeTarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		healthPath string
+		expected   string
+	}{
+		{"VersionURL", "http://example.com/api", "", "http://example.com/api"},
+		{"HealthPathReplacesPath", "http://example.com/api", "/healthz", "http://example.com/healthz"},
+		{"HealthPathWithQuery", "https://example.com:8443/api", "/status?full=1", "https://example.com:8443/status?full=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := probeTarget(ServiceVersion{ServiceVersionURL: tt.url, HealthPath: tt.healthPath})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, target)
+		})
+	}
+}
+
+func TestHealthProberProbe(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.WriteHeader(http.StatusNoContent)
+		case "/moved":
+			http.Redirect(w, r, "/healthz", http.StatusFound)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer upstream.Close()
+
+	expected, _ := ParseStatusRanges("200-299")
+	prober := &HealthProber{
+		Client: &http.Client{
+			Timeout:       50 * time.Millisecond,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		Method:   "HEAD",
+		Expected: expected,
+	}
+
+	tests := []struct {
+		name       string
+		healthPath string
+		healthy    bool
+		statusCode int
+		err        string
+	}{
+		{"Healthy", "/healthz", true, http.StatusNoContent, ""},
+		{"UnexpectedStatus", "/down", false, http.StatusServiceUnavailable, "unexpected status 503 Service Unavailable"},
+		{"RedirectNotFollowed", "/moved", false, http.StatusFound, "unexpected status 302 Found"},
+		{"Timeout", "/slow", false, 0, "Client.Timeout exceeded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := prober.probe(context.Background(), ServiceVersion{Model: gorm.Model{ID: 7}, ServiceVersionURL: upstream.URL, HealthPath: tt.healthPath})
+
+			assert.Equal(t, uint(7), check.ServiceVersionID)
+			assert.Equal(t, tt.healthy, check.Healthy)
+			assert.Equal(t, tt.statusCode, check.StatusCode)
+			if tt.err == "" {
+				assert.Empty(t, check.Error)
+			} else {
+				assert.Contains(t, check.Error, tt.err)
+			}
+		})
+	}
+}
+
+func TestHealthExpansion(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	var fail atomic.Bool
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer flaky.Close()
+
+	db := GetDBInstance()
+	service := Service{ServiceName: "Probed Service"}
+	assert.NoError(t, db.Create(&service).Error)
+	assert.NoError(t, db.Create(&ServiceVersion{ServiceID: service.ID, ServiceVersionName: "stable", ServiceVersionURL: healthy.URL}).Error)
+	flakyVersion := ServiceVersion{ServiceID: service.ID, ServiceVersionName: "flaky", ServiceVersionURL: flaky.URL, HealthPath: "/healthz"}
+	assert.NoError(t, db.Create(&flakyVersion).Error)
+
+	// Set the versions other tests seeded with real hosts aside while probing, so only the test servers are probed
+	var others []uint
+	assert.NoError(t, db.Model(&ServiceVersion{}).Where("service_id <> ?", service.ID).Pluck("id", &others).Error)
+	assert.NoError(t, db.Where("id IN ?", others).Delete(&ServiceVersion{}).Error)
+	expected, _ := ParseStatusRanges(DefaultProbeExpectedStatus)
+	prober := &HealthProber{Client: &http.Client{Timeout: time.Second}, Method: "GET", Expected: expected, Concurrency: 2}
+	assert.NoError(t, prober.ProbeOnce(context.Background()))
+	fail.Store(true)
+	assert.NoError(t, prober.ProbeOnce(context.Background()))
+	assert.NoError(t, db.Unscoped().Model(&ServiceVersion{}).Where("id IN ?", others).Update("deleted_at", nil).Error)
+
+	req := httptest.NewRequest("GET", "/v1/services?name=Probed+Service&expand=versions,health", nil)
+	rr := httptest.NewRecorder()
+	GetServices(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var result Service
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.Equal(t, HealthDegraded, result.Health.Status)
+	assert.Equal(t, 75.0, *result.Health.Uptime.Day)
+	for _, version := range result.Versions {
+		switch version.ServiceVersionName {
+		case "stable":
+			assert.Equal(t, HealthUp, version.Health.Status)
+			assert.Equal(t, 100.0, *version.Health.Uptime.Week)
+		case "flaky":
+			assert.Equal(t, HealthDown, version.Health.Status)
+			assert.Equal(t, http.StatusInternalServerError, version.Health.StatusCode)
+			assert.Equal(t, 50.0, *version.Health.Uptime.Month)
+		}
+	}
+
+	req = httptest.NewRequest("GET", "/v1/service_versions?service_version_name=stable&expand=health", nil)
+	rr = httptest.NewRecorder()
+	GetServiceVersions(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"up"`)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyKeyHeader is the request header carrying a client-chosen idempotency key.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Error codes for idempotent requests.
+const (
+	CodeIdempotencyKeyReused     = "idempotency_key_reused"
+	CodeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+)
+
+// DefaultIdempotencyTTL is how long responses are kept for replay unless configured otherwise.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// IdempotencyMiddleware makes POST handlers safe to retry.
+//
+// The first request with a given Idempotency-Key runs normally and its status, body and ETag are stored for ttl.
+// Keys are scoped to the caller and the route, so callers choosing the same key do not collide. Retries with
+// the same key, query and body get the stored response replayed, marked with Idempotent-Replayed. Reusing a key
+// with a different query or body returns 422, and a retry arriving while the original request is still running
+// returns 409. Server errors are not stored, so they can be retried.
+func IdempotencyMiddleware(ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > 255 {
+				writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, IdempotencyKeyHeader+" must be at most 255 characters")
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeReadProblem(w, r, err, CodeInvalidJSON, "Failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			// The record must be settled even when the client gives up, which is when it retries
+			db := GetDBInstance().WithContext(context.WithoutCancel(r.Context()))
+			hash := requestHash(r.URL.Query().Encode(), body)
+			record := IdempotencyRecord{
+				Scope:       idempotencyScope(r),
+				Key:         key,
+				RequestHash: hash,
+				ExpiresAt:   time.Now().Add(ttl),
+			}
+
+			claimed, err := claimIdempotencyKey(db, &record)
+			if err != nil {
+				writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to process "+IdempotencyKeyHeader)
+				requestLogger(r).Error("Idempotency error", "error", err)
+				return
+			}
+			if !claimed {
+				replayIdempotentResponse(w, r, &record, hash)
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			if recorder.status >= http.StatusInternalServerError {
+				db.Delete(&record)
+				return
+			}
+			record.StatusCode = recorder.status
+			record.ContentType = recorder.Header().Get("Content-Type")
+			record.ETag = recorder.Header().Get("ETag")
+			record.ResponseBody = recorder.body.Bytes()
+			if err := db.Save(&record).Error; err != nil {
+				requestLogger(r).Error("Failed to store idempotent response", "error", err)
+			}
+		})
+	}
+}
+
+// claimIdempotencyKey inserts a placeholder for the key and reports whether this request owns it.
+// When another request already owns an unexpired key, record is replaced by the stored one.
+func claimIdempotencyKey(db *gorm.DB, record *IdempotencyRecord) (bool, error) {
+	if err := db.Where("expires_at < ?", time.Now()).Delete(&IdempotencyRecord{}).Error; err != nil {
+		return false, err
+	}
+
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return true, nil
+	}
+
+	claim := *record
+	if err := db.First(record, "scope = ? AND key = ?", claim.Scope, claim.Key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The owner failed and released the key between our insert and lookup
+			*record = claim
+			return claimIdempotencyKey(db, record)
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// replayIdempotentResponse answers a retry from the stored record, provided the request body is unchanged.
+func replayIdempotentResponse(w http.ResponseWriter, r *http.Request, record *IdempotencyRecord, hash string) {
+	switch {
+	case record.RequestHash != hash:
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, IdempotencyKeyHeader+" was already used with a different query or request body")
+	case record.StatusCode == 0:
+		writeProblem(w, r, http.StatusConflict, CodeIdempotencyKeyInProgress, "A request with this "+IdempotencyKeyHeader+" is still being processed")
+	default:
+		if record.ContentType != "" {
+			w.Header().Set("Content-Type", record.ContentType)
+		}
+		if record.ETag != "" {
+			w.Header().Set("ETag", record.ETag)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.StatusCode)
+		w.Write(record.ResponseBody)
+	}
+}
+
+// idempotencyScope is the route of r and its authenticated caller, hashed so long usernames fit the column.
+func idempotencyScope(r *http.Request) string {
+	caller := sha256.Sum256([]byte(SubjectFromContext(r.Context())))
+	return r.Method + " " + r.URL.Path + " " + hex.EncodeToString(caller[:8])
+}
+
+// requestHash identifies a request by its canonical query and its body.
+func requestHash(query string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(query))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder passes a response through while keeping a copy of its status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController, so streamed responses can be flushed.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
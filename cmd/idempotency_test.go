@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	handler := IdempotencyMiddleware(time.Hour)(http.HandlerFunc(CreateService))
+
+	tests := []struct {
+		name       string
+		subject    string
+		key        string
+		query      string
+		payload    string
+		statusCode int
+		replayed   string
+		body       string
+	}{
+		{"FirstRequest", "user:alice", "key-1", "", `{"service_name": "IdempotentService"}`, http.StatusCreated, "", "IdempotentService"},
+		{"RetryIsReplayed", "user:alice", "key-1", "", `{"service_name": "IdempotentService"}`, http.StatusCreated, "true", "IdempotentService"},
+		{"ReusedKeyDifferentBody", "user:alice", "key-1", "", `{"service_name": "OtherService"}`, http.StatusUnprocessableEntity, "", CodeIdempotencyKeyReused},
+		{"ReusedKeyDifferentQuery", "user:alice", "key-1", "?mode=atomic", `{"service_name": "IdempotentService"}`, http.StatusUnprocessableEntity, "", CodeIdempotencyKeyReused},
+		{"OtherCallerSameKey", "cert:alice", "key-1", "", `{"service_name": "OtherIdempotentService"}`, http.StatusCreated, "", "OtherIdempotentService"},
+		{"WithoutKeyHitsConflict", "user:alice", "", "", `{"service_name": "IdempotentService"}`, http.StatusConflict, "", "Service already exists"},
+	}
+
+	var firstETag string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/v1/services"+tt.query, strings.NewReader(tt.payload))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.key != "" {
+				req.Header.Set(IdempotencyKeyHeader, tt.key)
+			}
+			req = req.WithContext(context.WithValue(req.Context(), subjectKey{}, tt.subject))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			assert.Equal(t, tt.replayed, rr.Header().Get("Idempotent-Replayed"))
+			switch tt.name {
+			case "FirstRequest":
+				firstETag = rr.Header().Get("ETag")
+				assert.NotEmpty(t, firstETag)
+			case "RetryIsReplayed":
+				assert.Equal(t, firstETag, rr.Header().Get("ETag"))
+			}
+			assert.Contains(t, rr.Body.String(), tt.body)
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// KongProtocols lists the protocols a Kong service can proxy to.
+var KongProtocols = []string{"http", "https", "grpc", "grpcs", "tcp", "tls", "udp", "ws", "wss"}
+
+// KongVersionName names the version that holds the upstream of an imported Kong service.
+const KongVersionName = "kong"
+
+// KongConfig is the subset of a Kong declarative configuration (decK) file the importer reads.
+// Routes and plugins are kept as loose objects, so every attribute ends up in the version metadata.
+type KongConfig struct {
+	FormatVersion string                   `yaml:"_format_version"`
+	Services      []KongService            `yaml:"services"`
+	Routes        []map[string]interface{} `yaml:"routes"`
+	Plugins       []map[string]interface{} `yaml:"plugins"`
+}
+
+// KongService is a Kong service as written in decK files and returned by the Admin API.
+type KongService struct {
+	ID       string                   `yaml:"id" json:"id"`
+	Name     string                   `yaml:"name" json:"name"`
+	URL      string                   `yaml:"url" json:"url"`
+	Protocol string                   `yaml:"protocol" json:"protocol"`
+	Host     string                   `yaml:"host" json:"host"`
+	Port     int                      `yaml:"port" json:"port"`
+	Path     string                   `yaml:"path" json:"path"`
+	Tags     []string                 `yaml:"tags" json:"tags"`
+	Routes   []map[string]interface{} `yaml:"routes" json:"-"`
+	Plugins  []map[string]interface{} `yaml:"plugins" json:"-"`
+}
+
+// kongMetadata is stored as the metadata of the imported version.
+type kongMetadata struct {
+	Source        string                   `json:"source"`
+	FormatVersion string                   `json:"format_version,omitempty"`
+	Tags          []string                 `json:"tags,omitempty"`
+	Routes        []map[string]interface{} `json:"routes"`
+	Plugins       []map[string]interface{} `json:"plugins"`
+}
+
+// ImportKongConfig imports a Kong declarative configuration in YAML or JSON.
+//
+// Each Kong service becomes a Service of the same name with a single version named "kong". The version URL
+// carries the service's protocol, host, port and path, and its metadata keeps the service's tags, routes and
+// plugins, including top-level ones that reference it. Importing the same file again changes nothing, and
+// importing an edited file updates the versions in place. With ?dry_run=true nothing is stored.
+func ImportKongConfig(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeReadProblem(w, r, err, CodeInvalidJSON, "Failed to read Kong configuration")
+		return
+	}
+	// YAML is a superset of JSON, so one decoder covers both formats
+	var config KongConfig
+	if err := yaml.Unmarshal(body, &config); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidJSON, "Invalid Kong configuration: "+err.Error())
+		return
+	}
+
+	versions, fieldErrors := mapKongConfig(config)
+	if config.FormatVersion == "" {
+		fieldErrors = append([]FieldError{{Field: "_format_version", Code: "required", Message: "is required"}}, fieldErrors...)
+	}
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	report := ImportReport{DryRun: dryRun, OnConflict: ConflictOverwrite, Summary: map[string]int{}, Changes: []ImportChange{}}
+	err = GetDBInstance().WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		for i, service := range config.Services {
+			if err := importKongService(tx, service.Name, versions[i], &report); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		if writeUniqueViolation(w, r, err, "Kong configuration conflicts with stored data") {
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to import Kong configuration")
+		requestLogger(r).Error("Error importing Kong configuration", "error", err)
+		return
+	}
+
+	for _, change := range report.Changes {
+		report.Summary[change.Action]++
+	}
+	setJSONHeader(w)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// mapKongConfig turns every Kong service into the version to store for it, in the same order.
+func mapKongConfig(config KongConfig) ([]ServiceVersion, []FieldError) {
+	var fieldErrors []FieldError
+	metadata := make([]kongMetadata, len(config.Services))
+	byName := make(map[string]int)
+	for i, service := range config.Services {
+		path := fmt.Sprintf("services[%d]", i)
+		switch _, duplicate := byName[service.Name]; {
+		case service.Name == "":
+			fieldErrors = append(fieldErrors, FieldError{Field: path + ".name", Code: "required", Message: "is required"})
+		case duplicate:
+			fieldErrors = append(fieldErrors, FieldError{Field: path + ".name", Code: "unique", Message: "is used by another service"})
+		}
+		byName[service.Name] = i
+		metadata[i] = kongMetadata{
+			Source:        "kong",
+			FormatVersion: config.FormatVersion,
+			Tags:          service.Tags,
+			Routes:        append([]map[string]interface{}{}, service.Routes...),
+			Plugins:       append([]map[string]interface{}{}, service.Plugins...),
+		}
+	}
+
+	// Top-level routes and plugins name their service, attach them to it
+	for i, route := range config.Routes {
+		name := kongReference(route["service"])
+		index, ok := byName[name]
+		if !ok {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("routes[%d].service", i), Code: "reference", Message: fmt.Sprintf("refers to unknown service %q", name)})
+			continue
+		}
+		delete(route, "service")
+		metadata[index].Routes = append(metadata[index].Routes, route)
+	}
+	for i, plugin := range config.Plugins {
+		if plugin["service"] == nil {
+			// Global and route-only plugins do not belong to a single service
+			continue
+		}
+		name := kongReference(plugin["service"])
+		index, ok := byName[name]
+		if !ok {
+			fieldErrors = append(fieldErrors, FieldError{Field: fmt.Sprintf("plugins[%d].service", i), Code: "reference", Message: fmt.Sprintf("refers to unknown service %q", name)})
+			continue
+		}
+		delete(plugin, "service")
+		metadata[index].Plugins = append(metadata[index].Plugins, plugin)
+	}
+
+	versions := make([]ServiceVersion, len(config.Services))
+	for i, service := range config.Services {
+		path := fmt.Sprintf("services[%d]", i)
+		serviceURL, err := kongServiceURL(service)
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: path, Code: "service_url", Message: err.Error()})
+			continue
+		}
+		encoded, err := json.Marshal(metadata[i])
+		if err != nil {
+			fieldErrors = append(fieldErrors, FieldError{Field: path, Code: "invalid", Message: "routes and plugins must be JSON compatible"})
+			continue
+		}
+		versions[i] = ServiceVersion{
+			ServiceVersionName:        KongVersionName,
+			ServiceVersionURL:         serviceURL,
+			ServiceVersionDescription: "Imported from Kong declarative configuration",
+			Metadata:                  encoded,
+		}
+		for _, fe := range validationErrors(&versions[i]) {
+			fe.Field = path + "." + fe.Field
+			fieldErrors = append(fieldErrors, fe)
+		}
+	}
+	return versions, fieldErrors
+}
+
+// kongServiceURL builds the upstream URL of a Kong service, from its url shorthand or its separate fields.
+// Kong defaults apply: protocol http, port 80 for http and 443 for https.
+func kongServiceURL(service KongService) (string, error) {
+	if service.URL != "" {
+		return service.URL, nil
+	}
+	if service.Host == "" {
+		return "", fmt.Errorf("host or url is required")
+	}
+
+	protocol := service.Protocol
+	if protocol == "" {
+		protocol = "http"
+	}
+	port := service.Port
+	if port == 0 {
+		port = 80
+		if protocol == "https" || protocol == "grpcs" || protocol == "tls" || protocol == "wss" {
+			port = 443
+		}
+	}
+	u := url.URL{Scheme: protocol, Host: service.Host + ":" + strconv.Itoa(port), Path: service.Path}
+	return u.String(), nil
+}
+
+// kongReference resolves a reference to a service, which decK writes either as a name or as {"name": ...}.
+func kongReference(ref interface{}) string {
+	switch v := ref.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok {
+			return name
+		}
+		if id, ok := v["id"].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// importKongService creates or updates the service and its Kong version, recording the outcome in report.
+func importKongService(tx *gorm.DB, name string, version ServiceVersion, report *ImportReport) error {
+	var service Service
+	err := tx.Where("service_name = ?", name).First(&service).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if service, err = createOrRestoreService(tx, name, "Imported from Kong"); err != nil {
+			return err
+		}
+		report.Changes = append(report.Changes, ImportChange{Kind: "service", Name: name, Action: ActionCreated})
+	} else if err != nil {
+		return err
+	} else {
+		report.Changes = append(report.Changes, ImportChange{Kind: "service", Name: name, Action: ActionUnchanged})
+	}
+
+	var existing ServiceVersion
+	err = tx.Where("service_id = ? AND service_version_name = ?", service.ID, KongVersionName).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		version.ServiceID = service.ID
+		if err := createResource(tx, &version); err != nil {
+			return err
+		}
+		report.Changes = append(report.Changes, ImportChange{Kind: "version", Service: name, Name: KongVersionName, Action: ActionCreated})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var fields []string
+	if existing.ServiceVersionURL != version.ServiceVersionURL {
+		fields = append(fields, "url")
+	}
+	if !jsonEqual(existing.Metadata, version.Metadata) {
+		fields = append(fields, "metadata")
+	}
+	if len(fields) == 0 {
+		report.Changes = append(report.Changes, ImportChange{Kind: "version", Service: name, Name: KongVersionName, Action: ActionUnchanged})
+		return nil
+	}
+
+	existing.ServiceVersionURL = version.ServiceVersionURL
+	existing.Metadata = version.Metadata
+	if err := updateWithRevision(tx, &existing, existing.Revision); err != nil {
+		return err
+	}
+	report.Changes = append(report.Changes, ImportChange{Kind: "version", Service: name, Name: KongVersionName, Action: ActionUpdated, Fields: fields})
+	return nil
+}
+
+// jsonEqual compares two JSON documents by value, since jsonb does not preserve key order or whitespace.
+func jsonEqual(a, b []byte) bool {
+	var x, y interface{}
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return string(a) == string(b)
+	}
+	encodedX, _ := json.Marshal(x)
+	encodedY, _ := json.Marshal(y)
+	return string(encodedX) == string(encodedY)
+}
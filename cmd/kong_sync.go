@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DefaultKongSyncInterval is how often the Admin API is polled unless configured otherwise.
+const DefaultKongSyncInterval = time.Minute
+
+// kongPageSize is the page size requested from the Admin API.
+const kongPageSize = 100
+
+// kongSyncLockKey identifies the Postgres advisory lock held while syncing, so servers sharing a database never
+// sync, and push the same services to Kong, at the same time.
+const kongSyncLockKey = 0x6b6f6e67
+
+// errKongSyncRunning is returned by a sync while another server holds the lock.
+var errKongSyncRunning = errors.New("Kong sync is running on another server")
+
+// Problem codes of the Kong sync endpoints.
+const (
+	// CodeNotConfigured is returned by endpoints of optional features that are switched off.
+	CodeNotConfigured = "not_configured"
+	// CodeUpstreamFailed is returned when the Kong Admin API cannot be read or written.
+	CodeUpstreamFailed = "upstream_failed"
+)
+
+// Drift kinds reported by the sync worker.
+const (
+	// DriftMissingInDashboard is a Kong service that had no version in the dashboard yet.
+	DriftMissingInDashboard = "missing_in_dashboard"
+	// DriftChangedInKong is a Kong service whose upstream or routes changed since the last sync.
+	DriftChangedInKong = "changed_in_kong"
+	// DriftMissingInKong is a dashboard service that Kong does not know about.
+	DriftMissingInKong = "missing_in_kong"
+	// DriftRemovedFromKong is a service mirrored from Kong that Kong no longer has.
+	DriftRemovedFromKong = "removed_from_kong"
+)
+
+// kongSync is the running sync worker, or nil when SERVICE_DASHBOARD_KONG_ADMIN_URL is not set.
+var kongSync *KongSyncWorker
+
+// KongAdminClient talks to the Kong Admin API.
+type KongAdminClient struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// kongPage is one page of an Admin API list endpoint.
+type kongPage struct {
+	Data json.RawMessage `json:"data"`
+	Next *string         `json:"next"`
+}
+
+// ListServices returns every Kong service, following the Admin API pagination.
+func (c *KongAdminClient) ListServices(ctx context.Context) ([]KongService, error) {
+	var services []KongService
+	err := c.list(ctx, "/services", func(data json.RawMessage) error {
+		var page []KongService
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		services = append(services, page...)
+		return nil
+	})
+	return services, err
+}
+
+// ListRoutes returns every Kong route as a loose object, following the Admin API pagination.
+func (c *KongAdminClient) ListRoutes(ctx context.Context) ([]map[string]interface{}, error) {
+	var routes []map[string]interface{}
+	err := c.list(ctx, "/routes", func(data json.RawMessage) error {
+		var page []map[string]interface{}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+		routes = append(routes, page...)
+		return nil
+	})
+	return routes, err
+}
+
+// CreateService registers a service in Kong.
+func (c *KongAdminClient) CreateService(ctx context.Context, name, serviceURL string) error {
+	body, _ := json.Marshal(map[string]string{"name": name, "url": serviceURL})
+	resp, err := c.do(ctx, "POST", "/services", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *KongAdminClient) list(ctx context.Context, path string, collect func(json.RawMessage) error) error {
+	next := fmt.Sprintf("%s?size=%d", path, kongPageSize)
+	for next != "" {
+		resp, err := c.do(ctx, "GET", next, nil)
+		if err != nil {
+			return err
+		}
+		var page kongPage
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding %s: %w", path, err)
+		}
+		if err := collect(page.Data); err != nil {
+			return fmt.Errorf("decoding %s: %w", path, err)
+		}
+
+		next = ""
+		if page.Next != nil {
+			next = *page.Next
+		}
+	}
+	return nil
+}
+
+// do sends a request to the Admin API and fails on any non-2xx response.
+// Paths may be absolute URLs, since some Kong versions return "next" that way.
+func (c *KongAdminClient) do(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.resolve(path), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Kong-Admin-Token", c.Token)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("kong admin API %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// resolve returns the URL of path on the Admin API. An absolute URL is only followed to the scheme and host of
+// BaseURL, so the admin token is never sent elsewhere; on any other host its path is resolved against BaseURL.
+func (c *KongAdminClient) resolve(path string) string {
+	base := strings.TrimSuffix(c.BaseURL, "/")
+	target, err := url.Parse(path)
+	if err != nil || !target.IsAbs() {
+		return base + path
+	}
+	if admin, err := url.Parse(c.BaseURL); err == nil && target.Scheme == admin.Scheme && target.Host == admin.Host {
+		return target.String()
+	}
+	return base + target.RequestURI()
+}
+
+// KongDrift is a difference between Kong and the dashboard found by a sync.
+// Resolved tells whether the sync fixed it, by updating the dashboard or by pushing to Kong.
+type KongDrift struct {
+	Service  string   `json:"service"`
+	Kind     string   `json:"kind"`
+	Fields   []string `json:"fields,omitempty"`
+	Resolved bool     `json:"resolved"`
+}
+
+// KongSyncStatus describes the last sync run.
+type KongSyncStatus struct {
+	LastRunAt time.Time      `json:"last_run_at"`
+	Error     string         `json:"error,omitempty"`
+	Changes   []ImportChange `json:"changes"`
+	Drift     []KongDrift    `json:"drift"`
+}
+
+// KongSyncWorker mirrors the services and routes of a Kong gateway into the dashboard.
+//
+// Every run pages through the Admin API, reconciles each named Kong service into a Service with a "kong"
+// version exactly like ImportKongConfig, and flags dashboard services that Kong does not know about. With
+// Push set, those services are created in Kong from the URL of their newest version, unless they were mirrored
+// from Kong in the first place: those were removed from Kong, and pushing them would undo the removal.
+type KongSyncWorker struct {
+	Client   *KongAdminClient
+	Interval time.Duration
+	Push     bool
+
+	mu      sync.Mutex
+	running sync.Mutex
+	status  KongSyncStatus
+}
+
+// newKongSyncWorker builds the worker from its validated configuration, or returns nil when no Admin API URL
+// is set.
+func newKongSyncWorker(config KongSyncConfig) *KongSyncWorker {
+	if config.AdminURL == "" {
+		return nil
+	}
+
+	return &KongSyncWorker{
+		Client: &KongAdminClient{
+			BaseURL:    config.AdminURL,
+			Token:      config.AdminToken,
+			HTTPClient: &http.Client{Timeout: 30 * time.Second, Transport: tracedTransport(nil)},
+		},
+		Interval: config.SyncInterval,
+		Push:     config.Push,
+	}
+}
+
+// Run syncs immediately and then on every interval until ctx is cancelled.
+func (w *KongSyncWorker) Run(ctx context.Context) {
+	lifecycle.WorkerStarted("kong_sync", w.Interval)
+	defer lifecycle.WorkerStopped("kong_sync")
+
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		_, err := w.SyncOnce(ctx)
+		if errors.Is(err, errKongSyncRunning) {
+			// Another server synced this round
+			err = nil
+		}
+		if err != nil {
+			slog.Error("Kong sync failed", "error", err)
+		}
+		lifecycle.WorkerRan("kong_sync", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns the outcome of the last run.
+func (w *KongSyncWorker) Status() KongSyncStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// SyncOnce runs a single sync and records its outcome as the current status. It returns errKongSyncRunning and
+// the status of the last run of this server while another server syncs.
+func (w *KongSyncWorker) SyncOnce(ctx context.Context) (KongSyncStatus, error) {
+	// Runs never overlap, so a manual trigger waits for the scheduled one
+	w.running.Lock()
+	defer w.running.Unlock()
+
+	unlock, err := lockKongSync(ctx)
+	if errors.Is(err, errKongSyncRunning) {
+		return w.Status(), err
+	}
+	status := KongSyncStatus{Changes: []ImportChange{}, Drift: []KongDrift{}}
+	if err == nil {
+		defer unlock()
+		status, err = w.sync(ctx)
+	}
+	status.LastRunAt = time.Now()
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	w.mu.Lock()
+	w.status = status
+	w.mu.Unlock()
+	return status, err
+}
+
+// lockKongSync takes the sync lock on a connection of its own, which holds it until unlock is called or the
+// connection drops. It returns errKongSyncRunning when another server holds it.
+func lockKongSync(ctx context.Context) (func(), error) {
+	sqlDB, err := GetDBInstance().DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", kongSyncLockKey).Scan(&locked); err != nil || !locked {
+		conn.Close()
+		if err == nil {
+			err = errKongSyncRunning
+		}
+		return nil, err
+	}
+	return func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", kongSyncLockKey); err != nil {
+			slog.Error("Error releasing the Kong sync lock", "error", err)
+		}
+		conn.Close()
+	}, nil
+}
+
+func (w *KongSyncWorker) sync(ctx context.Context) (KongSyncStatus, error) {
+	status := KongSyncStatus{Changes: []ImportChange{}, Drift: []KongDrift{}}
+
+	services, err := w.Client.ListServices(ctx)
+	if err != nil {
+		return status, err
+	}
+	routes, err := w.Client.ListRoutes(ctx)
+	if err != nil {
+		return status, err
+	}
+
+	// Attach routes to their service, which the Admin API references by ID
+	byID := make(map[string]int)
+	var named []KongService
+	for _, service := range services {
+		if service.Name == "" {
+			slog.Warn("Kong sync: skipping unnamed service", "kong_id", service.ID)
+			continue
+		}
+		byID[service.ID] = len(named)
+		service.Routes = []map[string]interface{}{}
+		named = append(named, service)
+	}
+	for _, route := range routes {
+		if index, ok := byID[kongReference(route["service"])]; ok {
+			delete(route, "service")
+			named[index].Routes = append(named[index].Routes, route)
+		}
+	}
+
+	versions, fieldErrors := mapKongConfig(KongConfig{Services: named})
+	if len(fieldErrors) > 0 {
+		return status, fmt.Errorf("kong services cannot be mirrored: %s %s", fieldErrors[0].Field, fieldErrors[0].Message)
+	}
+
+	report := ImportReport{OnConflict: ConflictOverwrite}
+	var dashboardOnly []Service
+	err = GetDBInstance().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i, service := range named {
+			if err := importKongService(tx, service.Name, versions[i], &report); err != nil {
+				return err
+			}
+		}
+
+		inKong := make(map[string]bool)
+		for _, service := range named {
+			inKong[service.Name] = true
+		}
+		var stored []Service
+		if err := tx.Preload("Versions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at DESC")
+		}).Order("service_name").Find(&stored).Error; err != nil {
+			return err
+		}
+		for _, service := range stored {
+			if !inKong[service.ServiceName] {
+				dashboardOnly = append(dashboardOnly, service)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return status, err
+	}
+
+	status.Changes = append(status.Changes, report.Changes...)
+	for _, change := range report.Changes {
+		switch {
+		case change.Kind == "service" && change.Action == ActionCreated:
+			status.Drift = append(status.Drift, KongDrift{Service: change.Name, Kind: DriftMissingInDashboard, Resolved: true})
+		case change.Kind == "version" && change.Action == ActionUpdated:
+			status.Drift = append(status.Drift, KongDrift{Service: change.Service, Kind: DriftChangedInKong, Fields: change.Fields, Resolved: true})
+		}
+	}
+
+	var pushErrors []error
+	for _, service := range dashboardOnly {
+		if mirroredFromKong(service) {
+			status.Drift = append(status.Drift, KongDrift{Service: service.ServiceName, Kind: DriftRemovedFromKong})
+			continue
+		}
+		drift := KongDrift{Service: service.ServiceName, Kind: DriftMissingInKong}
+		if serviceURL := pushableURL(service); w.Push && serviceURL != "" {
+			if err := w.Client.CreateService(ctx, service.ServiceName, serviceURL); err != nil {
+				pushErrors = append(pushErrors, err)
+			} else {
+				drift.Resolved = true
+			}
+		}
+		status.Drift = append(status.Drift, drift)
+	}
+	return status, errors.Join(pushErrors...)
+}
+
+// mirroredFromKong tells whether service has a version written by a Kong import or sync.
+func mirroredFromKong(service Service) bool {
+	for _, version := range service.Versions {
+		var metadata kongMetadata
+		if version.ServiceVersionName == KongVersionName && json.Unmarshal(version.Metadata, &metadata) == nil && metadata.Source == "kong" {
+			return true
+		}
+	}
+	return false
+}
+
+// pushableURL picks the upstream URL Kong should use for a dashboard service: its newest version with a URL.
+func pushableURL(service Service) string {
+	for _, version := range service.Versions {
+		if u, err := url.Parse(version.ServiceVersionURL); err == nil && u.Host != "" {
+			return version.ServiceVersionURL
+		}
+	}
+	return ""
+}
+
+// GetKongSyncStatus returns the outcome of the last Kong sync, including the drift it found.
+func GetKongSyncStatus(w http.ResponseWriter, r *http.Request) {
+	if kongSync == nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, CodeNotConfigured, "Kong sync is not configured, set SERVICE_DASHBOARD_KONG_ADMIN_URL")
+		return
+	}
+	setJSONHeader(w)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(kongSync.Status())
+}
+
+// TriggerKongSync runs a Kong sync right away and returns its outcome.
+func TriggerKongSync(w http.ResponseWriter, r *http.Request) {
+	if kongSync == nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, CodeNotConfigured, "Kong sync is not configured, set SERVICE_DASHBOARD_KONG_ADMIN_URL")
+		return
+	}
+	status, err := kongSync.SyncOnce(r.Context())
+	if errors.Is(err, errKongSyncRunning) {
+		writeProblem(w, r, http.StatusConflict, CodeConflict, "Kong sync is running on another server, try again later")
+		return
+	}
+	if err != nil {
+		writeProblem(w, r, http.StatusBadGateway, CodeUpstreamFailed, "Kong sync failed: "+err.Error())
+		requestLogger(r).Error("Kong sync failed", "error", err)
+		return
+	}
+	setJSONHeader(w)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKongAdmin is an in-memory Kong Admin API serving /services and /routes one item per page.
+type fakeKongAdmin struct {
+	mu       sync.Mutex
+	token    string
+	services []map[string]interface{}
+	routes   []map[string]interface{}
+	created  []map[string]string
+	// nextBase is prefixed to "next", which Kong may return as an absolute URL
+	nextBase string
+}
+
+func (f *fakeKongAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Kong-Admin-Token") != f.token {
+		http.Error(w, `{"message":"Invalid credentials"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var items []map[string]interface{}
+	switch {
+	case r.URL.Path == "/services" && r.Method == "POST":
+		var service map[string]string
+		json.NewDecoder(r.Body).Decode(&service)
+		f.created = append(f.created, service)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(service)
+		return
+	case r.URL.Path == "/services":
+		items = f.services
+	case r.URL.Path == "/routes":
+		items = f.routes
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	// Page by one item and hand out the offset as "next", to exercise pagination
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	page := map[string]interface{}{"data": items[offset:min(offset+1, len(items))], "next": nil}
+	if offset+1 < len(items) {
+		page["next"] = fmt.Sprintf("%s%s?offset=%d", f.nextBase, r.URL.Path, offset+1)
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func newFakeKongAdmin() *fakeKongAdmin {
+	return &fakeKongAdmin{
+		token: "secret",
+		services: []map[string]interface{}{
+			{"id": "svc-1", "name": "billing", "protocol": "http", "host": "billing.internal", "port": 8080, "path": "/api"},
+			{"id": "svc-2", "name": "search", "protocol": "https", "host": "search.internal", "port": 443},
+			{"id": "svc-3", "protocol": "http", "host": "anonymous.internal", "port": 80},
+		},
+		routes: []map[string]interface{}{
+			{"id": "route-1", "name": "billing-route", "paths": []string{"/billing"}, "service": map[string]interface{}{"id": "svc-1"}},
+			{"id": "route-2", "name": "search-route", "paths": []string{"/search"}, "service": map[string]interface{}{"id": "svc-2"}},
+		},
+	}
+}
+
+func TestKongAdminClient(t *testing.T) {
+	fake := newFakeKongAdmin()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := &KongAdminClient{BaseURL: server.URL, Token: "secret"}
+
+	services, err := client.ListServices(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, services, 3)
+	assert.Equal(t, KongService{ID: "svc-1", Name: "billing", Protocol: "http", Host: "billing.internal", Port: 8080, Path: "/api"}, services[0])
+
+	routes, err := client.ListRoutes(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, routes, 2)
+	assert.Equal(t, "svc-2", kongReference(routes[1]["service"]))
+
+	assert.NoError(t, client.CreateService(context.Background(), "orders", "http://orders.internal"))
+	assert.Equal(t, []map[string]string{{"name": "orders", "url": "http://orders.internal"}}, fake.created)
+
+	// An absolute "next" is followed on the Admin API only, never to the host it names
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request with token %q sent to another host", r.Header.Get("Kong-Admin-Token"))
+	}))
+	defer elsewhere.Close()
+	for _, nextBase := range []string{server.URL, elsewhere.URL} {
+		fake.nextBase = nextBase
+		services, err = client.ListServices(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, services, 3)
+	}
+
+	client.Token = "wrong"
+	_, err = client.ListServices(context.Background())
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestKongAdminClientResolve(t *testing.T) {
+	client := &KongAdminClient{BaseURL: "https://kong.internal:8444/admin/"}
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/services?size=100", "https://kong.internal:8444/admin/services?size=100"},
+		{"https://kong.internal:8444/admin/services?offset=abc", "https://kong.internal:8444/admin/services?offset=abc"},
+		{"https://attacker.example/services?offset=abc", "https://kong.internal:8444/admin/services?offset=abc"},
+		{"http://kong.internal:8444/services?offset=abc", "https://kong.internal:8444/admin/services?offset=abc"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, client.resolve(tt.path), tt.path)
+	}
+}
+
+func TestKongSyncEndpointsNotConfigured(t *testing.T) {
+	kongSync = nil
+	for _, method := range []string{"GET", "POST"} {
+		t.Run(method, func(t *testing.T) {
+			req := httptest.NewRequest(method, "/v1/kong/sync", nil)
+			rr := httptest.NewRecorder()
+			if method == "GET" {
+				GetKongSyncStatus(rr, req)
+			} else {
+				TriggerKongSync(rr, req)
+			}
+
+			assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+			var problem Problem
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+			assert.Equal(t, CodeNotConfigured, problem.Code)
+		})
+	}
+}
+
+// testKongDrift keeps the drift of the services a test created, since the shared database has others too.
+func testKongDrift(drift []KongDrift) []KongDrift {
+	var own []KongDrift
+	for _, d := range drift {
+		if slices.Contains([]string{"billing", "search", "orders", "legacy"}, d.Service) {
+			own = append(own, d)
+		}
+	}
+	return own
+}
+
+func TestKongSyncWorker(t *testing.T) {
+	fake := newFakeKongAdmin()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	db := GetDBInstance()
+	orders := Service{ServiceName: "orders", ServiceDescription: "Only in the dashboard"}
+	assert.NoError(t, db.Create(&orders).Error)
+	assert.NoError(t, db.Create(&ServiceVersion{ServiceID: orders.ID, ServiceVersionName: "v1", ServiceVersionURL: "http://orders.internal"}).Error)
+	// A service mirrored from Kong earlier, which Kong no longer has
+	legacy := Service{ServiceName: "legacy", ServiceDescription: "Removed from Kong"}
+	assert.NoError(t, db.Create(&legacy).Error)
+	assert.NoError(t, db.Create(&ServiceVersion{ServiceID: legacy.ID, ServiceVersionName: KongVersionName, ServiceVersionURL: "http://legacy.internal",
+		Metadata: Metadata(`{"source":"kong","routes":[],"plugins":[]}`)}).Error)
+
+	worker := &KongSyncWorker{Client: &KongAdminClient{BaseURL: server.URL, Token: "secret"}, Push: true}
+	status, err := worker.SyncOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []KongDrift{
+		{Service: "billing", Kind: DriftMissingInDashboard, Resolved: true},
+		{Service: "search", Kind: DriftMissingInDashboard, Resolved: true},
+		{Service: "legacy", Kind: DriftRemovedFromKong},
+		{Service: "orders", Kind: DriftMissingInKong, Resolved: true},
+	}, testKongDrift(status.Drift))
+	assert.Contains(t, fake.created, map[string]string{"name": "orders", "url": "http://orders.internal"})
+	for _, created := range fake.created {
+		assert.NotEqual(t, "legacy", created["name"], "services mirrored from Kong are never pushed back")
+	}
+
+	var version ServiceVersion
+	assert.NoError(t, db.Joins("JOIN services ON services.id = service_versions.service_id").
+		Where("services.service_name = ? AND service_version_name = ?", "billing", KongVersionName).First(&version).Error)
+	assert.Equal(t, "http://billing.internal:8080/api", version.ServiceVersionURL)
+	assert.Contains(t, string(version.Metadata), "billing-route")
+
+	// A change in Kong is picked up by the next run, and the pushed service is no longer drift
+	fake.mu.Lock()
+	fake.services[0]["port"] = 9090
+	fake.services = append(fake.services, map[string]interface{}{"id": "svc-4", "name": "orders", "url": "http://orders.internal"})
+	fake.mu.Unlock()
+
+	req := httptest.NewRequest("POST", "/v1/kong/sync", nil)
+	rr := httptest.NewRecorder()
+	kongSync = worker
+	defer func() { kongSync = nil }()
+	TriggerKongSync(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.Equal(t, []KongDrift{
+		{Service: "billing", Kind: DriftChangedInKong, Fields: []string{"url"}, Resolved: true},
+		{Service: "legacy", Kind: DriftRemovedFromKong},
+	}, testKongDrift(status.Drift))
+	assert.Equal(t, status.Drift, worker.Status().Drift)
+
+	// While another server syncs, this one leaves Kong alone
+	unlock, err := lockKongSync(context.Background())
+	assert.NoError(t, err)
+	_, err = worker.SyncOnce(context.Background())
+	assert.ErrorIs(t, err, errKongSyncRunning)
+	rr = httptest.NewRecorder()
+	TriggerKongSync(rr, httptest.NewRequest("POST", "/v1/kong/sync", nil))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	unlock()
+	_, err = worker.SyncOnce(context.Background())
+	assert.NoError(t, err)
+}
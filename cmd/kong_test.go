@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+const testKongConfig = `_format_version: "3.0"
+services:
+  - name: billing
+    host: billing.internal
+    port: 8080
+    path: /api
+    tags: [team-payments]
+    routes:
+      - name: billing-route
+        paths: [/billing]
+  - name: search
+    url: https://search.internal/v2
+routes:
+  - name: search-route
+    service: search
+    paths: [/search]
+plugins:
+  - name: rate-limiting
+    service:
+      name: billing
+    config:
+      minute: 5
+  - name: prometheus
+`
+
+func TestKongServiceURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		service KongService
+		url     string
+		err     string
+	}{
+		{"Defaults", KongService{Host: "example.com"}, "http://example.com:80", ""},
+		{"HTTPSDefaultPort", KongService{Protocol: "https", Host: "example.com", Path: "/v1"}, "https://example.com:443/v1", ""},
+		{"ExplicitFields", KongService{Protocol: "grpc", Host: "example.com", Port: 9000}, "grpc://example.com:9000", ""},
+		{"URLShorthand", KongService{URL: "http://example.com/api", Host: "ignored"}, "http://example.com/api", ""},
+		{"MissingHost", KongService{Name: "broken"}, "", "host or url is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := kongServiceURL(tt.service)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.url, url)
+		})
+	}
+}
+
+func TestMapKongConfig(t *testing.T) {
+	var config KongConfig
+	assert.NoError(t, yaml.Unmarshal([]byte(testKongConfig), &config))
+
+	versions, fieldErrors := mapKongConfig(config)
+	assert.Empty(t, fieldErrors)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "http://billing.internal:8080/api", versions[0].ServiceVersionURL)
+	assert.Equal(t, "https://search.internal/v2", versions[1].ServiceVersionURL)
+
+	var billing, search kongMetadata
+	assert.NoError(t, json.Unmarshal(versions[0].Metadata, &billing))
+	assert.NoError(t, json.Unmarshal(versions[1].Metadata, &search))
+	assert.Equal(t, "3.0", billing.FormatVersion)
+	assert.Equal(t, []string{"team-payments"}, billing.Tags)
+	assert.Equal(t, "billing-route", billing.Routes[0]["name"])
+	assert.Equal(t, "rate-limiting", billing.Plugins[0]["name"])
+	assert.Equal(t, "search-route", search.Routes[0]["name"])
+	assert.NotContains(t, search.Routes[0], "service")
+	assert.Empty(t, search.Plugins)
+}
+
+func TestMapKongConfigErrors(t *testing.T) {
+	config := KongConfig{
+		Services: []KongService{{Name: "a", Host: "a.internal"}, {Name: "a", Host: "b.internal"}, {Host: "c.internal"}, {Name: "d"}, {Name: "e", URL: "ftp://e.internal"}},
+		Routes:   []map[string]interface{}{{"name": "orphan", "service": "missing"}},
+	}
+
+	var fields []string
+	_, fieldErrors := mapKongConfig(config)
+	for _, fe := range fieldErrors {
+		fields = append(fields, fe.Field)
+	}
+	assert.ElementsMatch(t, []string{"services[1].name", "services[2].name", "routes[0].service", "services[3]", "services[4].service_version_url"}, fields)
+}
+
+func TestJSONEqual(t *testing.T) {
+	assert.True(t, jsonEqual([]byte(`{"a": 1, "b": [1, 2]}`), []byte(`{"b":[1,2],"a":1}`)))
+	assert.False(t, jsonEqual([]byte(`{"a": 1}`), []byte(`{"a": 2}`)))
+	assert.False(t, jsonEqual(nil, []byte(`{}`)))
+}
+
+func TestRunImportKong(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/import/kong", r.URL.Path)
+		json.NewEncoder(w).Encode(ImportReport{
+			DryRun:  r.URL.Query().Get("dry_run") == "true",
+			Summary: map[string]int{ActionCreated: 1, ActionUpdated: 1},
+			Changes: []ImportChange{
+				{Kind: "service", Name: "billing", Action: ActionCreated},
+				{Kind: "version", Service: "billing", Name: KongVersionName, Action: ActionUpdated, Fields: []string{"url"}},
+			},
+		})
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "kong.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(testKongConfig), 0o600))
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand([]string{"import-kong", "-f", file, "-server", server.URL, "-dry-run"}, &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout.String(), `updated   version "billing/kong" (url)`)
+	assert.Contains(t, stdout.String(), "Dry run, nothing was stored. 1 created, 1 updated, 0 unchanged.")
+}
+
+func TestImportKongConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		config     string
+		statusCode int
+		summary    map[string]int
+	}{
+		{"DryRun", "?dry_run=true", testKongConfig, http.StatusOK, map[string]int{ActionCreated: 4}},
+		{"Import", "", testKongConfig, http.StatusOK, map[string]int{ActionCreated: 4}},
+		{"Idempotent", "", testKongConfig, http.StatusOK, map[string]int{ActionUnchanged: 4}},
+		{"Changed", "", strings.Replace(testKongConfig, "port: 8080", "port: 9090", 1), http.StatusOK, map[string]int{ActionUnchanged: 3, ActionUpdated: 1}},
+		{"Invalid", "", "services: [{name: billing}]", http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/import/kong"+tt.query, strings.NewReader(tt.config))
+			rr := httptest.NewRecorder()
+			ImportKongConfig(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.summary != nil {
+				var report ImportReport
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+				assert.Equal(t, tt.summary, report.Summary)
+			}
+		})
+	}
+
+	var version ServiceVersion
+	db := GetDBInstance()
+	assert.NoError(t, db.Joins("JOIN services ON services.id = service_versions.service_id").
+		Where("services.service_name = ? AND service_version_name = ?", "billing", KongVersionName).First(&version).Error)
+	assert.Equal(t, "http://billing.internal:9090/api", version.ServiceVersionURL)
+	assert.Contains(t, string(version.Metadata), "rate-limiting")
+}
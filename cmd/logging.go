@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Defaults for SERVICE_DASHBOARD_LOG_LEVEL and SERVICE_DASHBOARD_LOG_FORMAT.
+const (
+	DefaultLogLevel  = "info"
+	DefaultLogFormat = "json"
+)
+
+// setupLogging makes the logger of the validated configuration the default one, which the log package
+// also writes through.
+func setupLogging(config LogConfig) {
+	logger, _ := newLogger(os.Stderr, config.Level, config.Format)
+	slog.SetDefault(logger)
+}
+
+// newLogger returns a logger writing to w at level (debug, info, warn or error) in format (json or text).
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var leveler slog.Level
+	if err := leveler.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, allowed: debug, info, warn, error", level)
+	}
+	options := &slog.HandlerOptions{Level: leveler}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, allowed: json, text", format)
+}
+
+// requestInfo is what is known about a request, filled in as it passes the middlewares.
+type requestInfo struct {
+	Route   string
+	Role    string
+	Subject string
+}
+
+type requestInfoKey struct{}
+
+// setAuthInfo records the authenticated caller for the request log, see LoggerMiddleware.
+func setAuthInfo(ctx context.Context, role, subject string) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.Role = role
+		info.Subject = subject
+	}
+}
+
+// requestLogger returns the default logger with the request ID, route and caller of the request attached,
+// so every line logged while handling it can be correlated.
+func requestLogger(r *http.Request) *slog.Logger {
+	logger := slog.Default().With("request_id", RequestIDFromContext(r.Context()))
+	if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsValid() {
+		logger = logger.With("trace_id", spanContext.TraceID().String())
+	}
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		logger = logger.With("route", info.Route, "role", info.Role, "subject", info.Subject)
+	}
+	return logger
+}
+
+// routeTemplate returns the template of the route of router matching r, or unmatchedRoute.
+func routeTemplate(router *mux.Router, r *http.Request) string {
+	var match mux.RouteMatch
+	if router.Match(r, &match) && match.Route != nil {
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return unmatchedRoute
+}
+
+// LoggerMiddleware logs one line per request once it completes, with its request ID, route, caller, status,
+// response size and latency. Server errors are logged at the error level.
+func LoggerMiddleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			startTime := time.Now()
+			info := &requestInfo{Route: routeTemplate(router, r)}
+			r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			level := slog.LevelInfo
+			if recorder.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			requestLogger(r).LogAttrs(r.Context(), level, "Request completed",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", recorder.status),
+				slog.Int64("bytes", recorder.bytes),
+				slog.Float64("latency_ms", float64(time.Since(startTime).Microseconds())/1000),
+			)
+		})
+	}
+}
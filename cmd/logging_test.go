@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		level  string
+		format string
+		valid  bool
+	}{
+		{"info", "json", true},
+		{"DEBUG", "text", true},
+		{"warn", "JSON", true},
+		{"verbose", "json", false},
+		{"info", "logfmt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.level+" "+tt.format, func(t *testing.T) {
+			_, err := newLogger(&bytes.Buffer{}, tt.level, tt.format)
+			assert.Equal(t, tt.valid, err == nil)
+		})
+	}
+
+	var buf bytes.Buffer
+	logger, _ := newLogger(&buf, "warn", "json")
+	logger.Info("dropped")
+	logger.Warn("kept")
+	assert.NotContains(t, buf.String(), "dropped")
+	assert.Contains(t, buf.String(), `"msg":"kept"`)
+}
+
+func TestLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := newLogger(&buf, "info", "json")
+	defer func(previous *slog.Logger) { slog.SetDefault(previous) }(slog.Default())
+	slog.SetDefault(logger)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/services", func(w http.ResponseWriter, r *http.Request) {
+		requestLogger(r).Error("Error updating service", "error", "boom")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("failed"))
+	}).Methods("PUT")
+	handler := RequestIDMiddleware(LoggerMiddleware(router)(RoleBasedMiddleware(router)))
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, CustomClaims{
+		Role: "admin",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "alice",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString(JwtSecretKey)
+	req := httptest.NewRequest("PUT", "/v1/services?id=1", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(RequestIDHeader, "req-1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Unauthenticated requests are logged too
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/unknown", nil))
+
+	var lines []map[string]interface{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var entry map[string]interface{}
+		assert.NoError(t, json.Unmarshal(line, &entry))
+		lines = append(lines, entry)
+	}
+	assert.Len(t, lines, 3)
+
+	for _, entry := range lines[:2] {
+		assert.Equal(t, "ERROR", entry["level"])
+		assert.Equal(t, "req-1", entry["request_id"])
+		assert.Equal(t, "/v1/services", entry["route"])
+		assert.Equal(t, "admin", entry["role"])
+		assert.Equal(t, "alice", entry["subject"])
+	}
+	assert.Equal(t, "boom", lines[0]["error"])
+	assert.Equal(t, "Request completed", lines[1]["msg"])
+	assert.Equal(t, float64(http.StatusInternalServerError), lines[1]["status"])
+	assert.Equal(t, float64(len("failed")), lines[1]["bytes"])
+	assert.Contains(t, lines[1], "latency_ms")
+
+	assert.Equal(t, "INFO", lines[2]["level"])
+	assert.Equal(t, unmatchedRoute, lines[2]["route"])
+	assert.Equal(t, "", lines[2]["role"])
+	assert.Equal(t, float64(http.StatusUnauthorized), lines[2]["status"])
+	assert.NotEmpty(t, lines[2]["request_id"])
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 
@@ -11,32 +18,197 @@ import (
 )
 
 func main() {
-	InitDB()
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
+	// Flags override the environment, which overrides the configuration file
+	config, err := LoadConfig("kong-service-dashboard", os.Args[1:], os.Stderr)
+	if err == errInvalidFlags {
+		os.Exit(2)
+	}
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	appConfig = config
+	setupLogging(config.Log)
+	JwtSecretKey = []byte(config.Auth.JWTSecret)
+	TokenTTL = config.Auth.TokenTTL
+	ClientCertificateRoles, _ = parseClientRoles(config.TLS.ClientRoles)
+
+	// Export traces when an exporter is configured; traceparent headers are propagated either way
+	shutdownTracing, err := setupTracing(context.Background(), config.Tracing.Exporter)
+	if err != nil {
+		slog.Error("Error setting up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	// POST handlers replay stored responses for retried Idempotency-Key requests
+	idempotent := IdempotencyMiddleware(config.Idempotency.TTL)
 
 	router := mux.NewRouter()
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		log.Println("ok")
-	})
+	router.NotFoundHandler = notFoundHandler()
+	router.MethodNotAllowedHandler = methodNotAllowedHandler()
+	router.HandleFunc("/livez", LivenessHandler).Methods("GET")
+	router.HandleFunc("/readyz", ReadinessHandler).Methods("GET")
+	router.HandleFunc("/health", LivenessHandler).Methods("GET")
 	router.HandleFunc("/v1/services", GetServices).Methods("GET")
-	router.HandleFunc("/v1/services", CreateService).Methods("POST")
+	router.Handle("/v1/services", idempotent(http.HandlerFunc(CreateService))).Methods("POST")
 	router.HandleFunc("/v1/services", UpdateService).Methods("PUT")
+	router.HandleFunc("/v1/services", PatchService).Methods("PATCH")
 	router.HandleFunc("/v1/services", DeleteService).Methods("DELETE")
-	router.HandleFunc("/v1/service_versions", CreateServiceVersion).Methods("POST")
+	router.Handle("/v1/services/bulk", idempotent(http.HandlerFunc(BulkCreateServices))).Methods("POST")
+	router.HandleFunc("/v1/services/bulk", BulkUpdateServices).Methods("PUT")
+	router.HandleFunc("/v1/services/bulk", BulkDeleteServices).Methods("DELETE")
+	router.HandleFunc("/v1/service_versions", GetServiceVersions).Methods("GET")
+	router.Handle("/v1/service_versions", idempotent(http.HandlerFunc(CreateServiceVersion))).Methods("POST")
 	router.HandleFunc("/v1/service_versions", UpdateServiceVersion).Methods("PUT")
+	router.HandleFunc("/v1/service_versions", PatchServiceVersion).Methods("PATCH")
 	router.HandleFunc("/v1/service_versions", DeleteServiceVersion).Methods("DELETE")
+	router.Handle("/v1/service_versions/bulk", idempotent(http.HandlerFunc(BulkCreateServiceVersions))).Methods("POST")
+	router.HandleFunc("/v1/service_versions/bulk", BulkUpdateServiceVersions).Methods("PUT")
+	router.HandleFunc("/v1/service_versions/bulk", BulkDeleteServiceVersions).Methods("DELETE")
 	router.HandleFunc("/v1/users", GetUsers).Methods("GET")
-	router.HandleFunc("/v1/users", CreateUser).Methods("POST")
+	router.Handle("/v1/users", idempotent(http.HandlerFunc(CreateUser))).Methods("POST")
 	router.HandleFunc("/v1/users", UpdateUser).Methods("PUT")
+	router.HandleFunc("/v1/users", PatchUser).Methods("PATCH")
 	router.HandleFunc("/v1/users", DeleteUser).Methods("DELETE")
+	router.Handle("/v1/users/bulk", idempotent(http.HandlerFunc(BulkCreateUsers))).Methods("POST")
+	router.HandleFunc("/v1/users/bulk", BulkUpdateUsers).Methods("PUT")
+	router.HandleFunc("/v1/users/bulk", BulkDeleteUsers).Methods("DELETE")
+	router.HandleFunc("/v1/export", ExportCatalog).Methods("GET")
+	router.HandleFunc("/v1/import", ImportCatalog).Methods("POST")
+	router.HandleFunc("/v1/apply", ApplyCatalog).Methods("POST")
+	router.HandleFunc("/v1/import/kong", ImportKongConfig).Methods("POST")
+	router.HandleFunc("/v1/kong/sync", GetKongSyncStatus).Methods("GET")
+	router.HandleFunc("/v1/kong/sync", TriggerKongSync).Methods("POST")
+	router.HandleFunc("/v1/webhooks", GetWebhooks).Methods("GET")
+	router.HandleFunc("/v1/webhooks", CreateWebhook).Methods("POST")
+	router.HandleFunc("/v1/webhooks", UpdateWebhook).Methods("PUT")
+	router.HandleFunc("/v1/webhooks", DeleteWebhook).Methods("DELETE")
+	router.HandleFunc("/v1/webhooks/deliveries", GetWebhookDeliveries).Methods("GET")
+	router.HandleFunc("/v1/webhooks/deliveries/redeliver", RedeliverWebhook).Methods("POST")
+	router.HandleFunc("/v1/events", StreamEvents).Methods("GET")
 	router.HandleFunc("/v1/auth", UserAuthentication).Methods("POST")
 
-	// Add logger middleware to the router
-	loggedMux := LoggerMiddleware(router)
+	// Metrics are served without a token on a separate admin port, or to admins on the API port
+	if config.Server.MetricsAddr == "" {
+		router.Handle("/metrics", MetricsHandler(true)).Methods("GET")
+	}
+
+	// Limit the request rate of every caller, once authenticated so limits follow the caller rather than its address
+	limiter := newRateLimiter(config.RateLimit)
+	var limitedMux http.Handler = router
+	if limiter != nil {
+		limitedMux = RateLimitMiddleware(limiter)(router)
+	}
+
 	// Add Role Based middleware to the router
-	roleBasedMux := RoleBasedMiddleware(loggedMux)
+	roleBasedMux := RoleBasedMiddleware(limitedMux)
+	// Limit the requests turned away for their credentials too, by address
+	if limiter != nil {
+		roleBasedMux = UnauthorizedRateLimitMiddleware(limiter)(roleBasedMux)
+	}
+	// Turn API requests away until the database is migrated; probes are always answered
+	startupMux := StartupMiddleware(roleBasedMux)
+	// Count every request, including auth failures
+	metricsMux := MetricsMiddleware(router)(startupMux)
+	// Log every request once it completes, including auth failures
+	loggedMux := LoggerMiddleware(router)(metricsMux)
+	// Assign request IDs first so every response and log line carries one
+	requestIDMux := RequestIDMiddleware(loggedMux)
+	// Trace every request, continuing the trace of the caller
+	tracedMux := TracingMiddleware(router)(requestIDMux)
 
-	if err := http.ListenAndServe(":8080", roleBasedMux); err != nil {
-		fmt.Println("Error starting server:", err)
+	// Listen right away so probes see the server starting while the database is migrated
+	listener, err := net.Listen("tcp", config.Server.Addr)
+	if err != nil {
+		slog.Error("Error starting server", "error", err)
+		os.Exit(1)
+	}
+	server := config.Server.NewServer(tracedMux)
+	// Serve HTTPS when a certificate is configured, picking up renewed files without a restart
+	if config.TLS.Enabled() {
+		reloader, err := newCertificateReloader(config.TLS)
+		if err != nil {
+			slog.Error("Error loading the TLS files", "error", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = reloader.TLSConfig()
+	}
+	servers := []*http.Server{server}
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", "addr", config.Server.Addr, "mode", config.Mode, "tls", config.TLS.Enabled())
+		if server.TLSConfig != nil {
+			serveErr <- server.ServeTLS(listener, "", "")
+			return
+		}
+		serveErr <- server.Serve(listener)
+	}()
+
+	// A first SIGTERM or interrupt shuts down gracefully, a second one exits right away
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stopSignals()
+
+	InitDB()
+
+	workers := NewWorkers()
+	// Probe the version URLs unless switched off
+	if prober := newHealthProber(config.Probe); prober != nil {
+		workers.Go(prober.Run)
+	}
+
+	// Deliver webhook events in the background
+	webhookDispatcher = NewWebhookDispatcher(&http.Client{Timeout: 10 * time.Second, Transport: tracedTransport(nil)}, config.Webhook.PollInterval)
+	workers.Go(webhookDispatcher.Run)
+
+	// Relay change events recorded in the outbox to the configured sinks
+	workers.Go(newOutboxRelay(config.Outbox).Run)
+	// Stream every change event from the outbox to the clients of this server
+	if tailer := newEventTailer(config.Outbox); tailer != nil {
+		workers.Go(tailer.Run)
+		// End event streams on shutdown, which would otherwise hold it up until the deadline
+		server.RegisterOnShutdown(eventBroker.Close)
+	}
+
+	// Mirror a Kong gateway when its Admin API is configured
+	if kongSync = newKongSyncWorker(config.Kong); kongSync != nil {
+		workers.Go(kongSync.Run)
+	}
+
+	// Export the database pool statistics, and serve the metrics port once the database is migrated
+	registerDBMetrics()
+	if metricsAddr := config.Server.MetricsAddr; metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", MetricsHandler(false))
+		metricsServer := &http.Server{Addr: metricsAddr, Handler: metricsMux, ReadHeaderTimeout: config.Server.ReadHeaderTimeout}
+		servers = append(servers, metricsServer)
+		go func() {
+			slog.Info("Serving metrics", "addr", metricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("Error serving metrics", "error", err)
+			}
+		}()
+	}
+
+	lifecycle.SetPhase(PhaseReady)
+	slog.Info("Server is ready")
+
+	select {
+	case err := <-serveErr:
+		slog.Error("Error serving requests", "error", err)
+		os.Exit(1)
+	case <-signals.Done():
+		stopSignals()
+	}
+	if err := shutdown(config.Server, servers, workers, shutdownTracing); err != nil {
+		slog.Error("Error shutting down", "error", err)
+		os.Exit(1)
 	}
-	log.Println("Starting server on :8080")
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes every metric of the dashboard.
+const metricsNamespace = "service_dashboard"
+
+// unmatchedRoute labels requests that match no route, so unknown paths cannot blow up the label cardinality.
+const unmatchedRoute = "unmatched"
+
+// Auth attempt types and results.
+const (
+	AuthLogin       = "login"
+	AuthToken       = "token"
+	AuthCertificate = "certificate"
+	AuthSuccess     = "success"
+	AuthFailure     = "failure"
+)
+
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	authAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "auth_attempts_total",
+		Help:      "Logins with a username and password, and requests authenticated with a token or client certificate, by result.",
+	}, []string{"type", "result"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests answered with 429 by the rate limit rule that applied.",
+	}, []string{"rule"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		httpRequests,
+		httpRequestDuration,
+		authAttempts,
+		rateLimited,
+		catalogCollector{},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// registerDBMetrics exports the connection pool stats of the database handle.
+func registerDBMetrics() {
+	sqlDB, err := GetDBInstance().DB()
+	if err != nil {
+		slog.Warn("Database pool metrics are not available", "error", err)
+		return
+	}
+	metricsRegistry.MustRegister(collectors.NewDBStatsCollector(sqlDB, metricsNamespace))
+}
+
+// recordAuth counts an authentication attempt.
+func recordAuth(attemptType, result string) {
+	authAttempts.WithLabelValues(attemptType, result).Inc()
+}
+
+// recordRateLimited counts a request refused by the rate limit rule of scope.
+func recordRateLimited(scope string) {
+	rateLimited.WithLabelValues(scope).Inc()
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format. With requireAdmin only the admin role may
+// read them, for when they are served on the API port rather than on a separate one.
+func MetricsHandler(requireAdmin bool) http.Handler {
+	handler := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	if !requireAdmin {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RoleFromContext(r.Context()) != "admin" {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "Only admins may read metrics")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// MetricsMiddleware counts and times every request, labeled by the route template it matches on router.
+func MetricsMiddleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := routeTemplate(router, r)
+			startTime := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			status := strconv.Itoa(recorder.status)
+			httpRequests.WithLabelValues(route, r.Method, status).Inc()
+			httpRequestDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(startTime).Seconds())
+		})
+	}
+}
+
+// statusRecorder remembers the status code and size of the response written through it.
+// It keeps streaming working for /v1/events.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wroteHeader {
+		s.status = status
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	s.wroteHeader = true
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+var (
+	servicesDesc = prometheus.NewDesc(metricsNamespace+"_services", "Services in the catalog.", nil, nil)
+	versionsDesc = prometheus.NewDesc(metricsNamespace+"_service_versions", "Service versions in the catalog.", nil, nil)
+	usersDesc    = prometheus.NewDesc(metricsNamespace+"_users", "Users by role.", []string{"role"}, nil)
+)
+
+// catalogCollector counts the catalog contents on every scrape. Soft-deleted rows are not counted.
+type catalogCollector struct{}
+
+func (catalogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- servicesDesc
+	ch <- versionsDesc
+	ch <- usersDesc
+}
+
+func (catalogCollector) Collect(ch chan<- prometheus.Metric) {
+	db := GetDBInstance()
+	var services, versions int64
+	if err := db.Model(&Service{}).Count(&services).Error; err != nil {
+		slog.Error("Error counting services for metrics", "error", err)
+		return
+	}
+	if err := db.Model(&ServiceVersion{}).Count(&versions).Error; err != nil {
+		slog.Error("Error counting service versions for metrics", "error", err)
+		return
+	}
+	var roles []struct {
+		Role  string
+		Count int64
+	}
+	if err := db.Model(&User{}).Select("role, COUNT(*) AS count").Group("role").Scan(&roles).Error; err != nil {
+		slog.Error("Error counting users for metrics", "error", err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(servicesDesc, prometheus.GaugeValue, float64(services))
+	ch <- prometheus.MustNewConstMetric(versionsDesc, prometheus.GaugeValue, float64(versions))
+	// Every known role is reported, so a role without users shows 0 instead of disappearing
+	counts := make(map[string]int64)
+	for _, role := range roles {
+		counts[role.Role] = role.Count
+	}
+	for _, role := range AllowedRoles {
+		ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(counts[role]), role)
+	}
+}
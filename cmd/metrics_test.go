@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/services", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("POST")
+	router.HandleFunc("/v1/events", func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		assert.True(t, ok, "streaming needs a Flusher")
+		w.Write([]byte("data: {}\n\n"))
+	}).Methods("GET")
+	handler := MetricsMiddleware(router)(router)
+
+	tests := []struct {
+		method string
+		path   string
+		route  string
+		status string
+	}{
+		{"POST", "/v1/services?id=1", "/v1/services", "201"},
+		{"GET", "/v1/events", "/v1/events", "200"},
+		{"GET", "/v1/unknown/123", unmatchedRoute, "404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			before := testutil.ToFloat64(httpRequests.WithLabelValues(tt.route, tt.method, tt.status))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, before+1, testutil.ToFloat64(httpRequests.WithLabelValues(tt.route, tt.method, tt.status)))
+		})
+	}
+}
+
+func TestAuthMetrics(t *testing.T) {
+	handler := RoleBasedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	failures := testutil.ToFloat64(authAttempts.WithLabelValues(AuthToken, AuthFailure))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/services", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/services", nil))
+	assert.Equal(t, failures+2, testutil.ToFloat64(authAttempts.WithLabelValues(AuthToken, AuthFailure)))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	MetricsHandler(true).ServeHTTP(rr, req.WithContext(context.WithValue(req.Context(), roleKey{}, "user")))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = httptest.NewRecorder()
+	MetricsHandler(false).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "service_dashboard_services ")
+	assert.Contains(t, rr.Body.String(), `service_dashboard_users{role="admin"}`)
+}
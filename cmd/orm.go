@@ -1,40 +1,194 @@
 package main
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type Service struct {
 	gorm.Model
+	RevisionCounter
 
-	ServiceName        string           `gorm:"unique;not null" json:"service_name"`
-	ServiceDescription string           `gorm:"type:text" json:"service_description"`
-	Versions           []ServiceVersion `gorm:"foreignKey:ServiceID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"service_versions,omitempty"`
+	ServiceName        string           `gorm:"unique;not null" json:"service_name" validate:"required,max=255"`
+	ServiceDescription string           `gorm:"type:text" json:"service_description" validate:"max=4096"`
+	Versions           []ServiceVersion `gorm:"foreignKey:ServiceID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"service_versions,omitempty" validate:"omitempty,dive"`
+	Health             *Health          `gorm:"-" json:"health,omitempty" validate:"-"`
 }
 
 type ServiceVersion struct {
 	gorm.Model
+	RevisionCounter
 
-	ServiceID                 uint   `gorm:"not null" json:"service_id"`
-	ServiceVersionName        string `gorm:"not null" json:"service_version_name"`
-	ServiceVersionURL         string `gorm:"type:text" json:"service_version_url"`
-	ServiceVersionDescription string `gorm:"type:text" json:"service_version_description"`
+	ServiceID                 uint     `gorm:"not null;uniqueIndex:idx_service_versions_service_id_name,where:deleted_at IS NULL" json:"service_id"`
+	ServiceVersionName        string   `gorm:"not null;uniqueIndex:idx_service_versions_service_id_name,where:deleted_at IS NULL" json:"service_version_name" validate:"required,max=255"`
+	ServiceVersionURL         string   `gorm:"type:text" json:"service_version_url" validate:"omitempty,service_url,max=2048"`
+	ServiceVersionDescription string   `gorm:"type:text" json:"service_version_description" validate:"max=4096"`
+	Metadata                  Metadata `gorm:"type:jsonb" json:"metadata,omitempty"`
+	HealthPath                string   `gorm:"type:text" json:"health_path,omitempty" validate:"omitempty,startswith=/,max=2048"`
+	Health                    *Health  `gorm:"-" json:"health,omitempty" validate:"-"`
 }
 
 type User struct {
 	gorm.Model
+	RevisionCounter
 
-	Username    string      `gorm:"unique;not null" json:"username"`
-	Password    string      `gorm:"not null" json:"password"`
-	UserProfile UserProfile `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"user_profile,omitempty"`
-	Role        string      `gorm:"not null" json:"role"`
+	Username    string      `gorm:"unique;not null" json:"username" validate:"required,max=255"`
+	Password    string      `gorm:"not null" json:"password" validate:"required,min=8,max=255"`
+	UserProfile UserProfile `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;" json:"user_profile,omitempty" validate:"omitempty"`
+	Role        string      `gorm:"not null" json:"role" validate:"required,role"`
 }
 
 type UserProfile struct {
 	gorm.Model
 
 	UserID    uint   `gorm:"unique;not null" json:"user_id"`
-	FirstName string `gorm:"type:varchar(255)" json:"first_name"`
-	LastName  string `gorm:"type:varchar(255)" json:"last_name"`
-	Email     string `gorm:"not null" json:"email"`
+	FirstName string `gorm:"type:varchar(255)" json:"first_name" validate:"max=255"`
+	LastName  string `gorm:"type:varchar(255)" json:"last_name" validate:"max=255"`
+	Email     string `gorm:"not null" json:"email" validate:"required,email,max=255"`
+}
+
+type IdempotencyRecord struct {
+	Scope        string `gorm:"primaryKey;type:varchar(255)"`
+	Key          string `gorm:"primaryKey;type:varchar(255)"`
+	RequestHash  string `gorm:"type:char(64);not null"`
+	StatusCode   int    `gorm:"not null;default:0"`
+	ContentType  string `gorm:"type:varchar(255)"`
+	ETag         string `gorm:"column:etag;type:varchar(255)"`
+	ResponseBody []byte
+	CreatedAt    time.Time
+	ExpiresAt    time.Time `gorm:"not null;index"`
+}
+
+// RateLimitBucket is the token bucket of a client under a rate limit rule, shared by every replica.
+// Allowed tells whether the last request took a token from it.
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey;type:text"`
+	Tokens    float64   `gorm:"not null"`
+	Allowed   bool      `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null;index"`
+}
+
+// HealthCheck is the outcome of one probe of a service version URL.
+type HealthCheck struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	ServiceVersionID uint      `gorm:"not null;index:idx_health_checks_version_checked_at,priority:1" json:"service_version_id"`
+	CheckedAt        time.Time `gorm:"not null;index:idx_health_checks_version_checked_at,priority:2;index" json:"checked_at"`
+	Healthy          bool      `gorm:"not null" json:"healthy"`
+	StatusCode       int       `json:"status_code,omitempty"`
+	LatencyMs        int64     `json:"latency_ms"`
+	Error            string    `gorm:"type:text" json:"error,omitempty"`
+}
+
+// Webhook subscribes a URL to catalog change events. Events lists event types such as "service.created",
+// or patterns such as "service.*" and "*".
+type Webhook struct {
+	gorm.Model
+	RevisionCounter
+
+	URL         string     `gorm:"type:text;not null" json:"url" validate:"required,webhook_url,max=2048"`
+	Events      StringList `gorm:"type:jsonb;not null" json:"events" validate:"required,dive,webhook_event"`
+	Secret      string     `gorm:"not null" json:"secret,omitempty" validate:"omitempty,min=16,max=255"`
+	Description string     `gorm:"type:text" json:"description" validate:"max=4096"`
+	Disabled    bool       `gorm:"not null;default:false" json:"disabled"`
+}
+
+// WebhookDelivery is one event queued for, or sent to, one webhook.
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	WebhookID      uint       `gorm:"not null;index" json:"webhook_id"`
+	EventID        string     `gorm:"type:varchar(64);not null;index" json:"event_id"`
+	EventType      string     `gorm:"type:varchar(64);not null" json:"event_type"`
+	Payload        Metadata   `gorm:"type:jsonb;not null" json:"payload"`
+	Status         string     `gorm:"type:varchar(16);not null;index:idx_webhook_deliveries_status_next_attempt_at,priority:1" json:"status"`
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  *time.Time `gorm:"index:idx_webhook_deliveries_status_next_attempt_at,priority:2" json:"next_attempt_at,omitempty"`
+	LastStatusCode int        `json:"last_status_code,omitempty"`
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// OutboxEntry is a change event written in the same transaction as the change, waiting to be relayed.
+// PublishedTo lists the sinks that accepted it; it is delivered once every sink has.
+type OutboxEntry struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	EventID       string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"event_id"`
+	EventType     string     `gorm:"type:varchar(64);not null" json:"event_type"`
+	Payload       Metadata   `gorm:"type:jsonb;not null" json:"payload"`
+	PublishedTo   StringList `gorm:"type:jsonb;not null" json:"published_to"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at,omitempty"`
+	LastError     string     `gorm:"type:text" json:"last_error,omitempty"`
+	DeliveredAt   *time.Time `gorm:"index" json:"delivered_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// StringList is a list of strings stored as a JSON array in a jsonb column.
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	encoded, err := json.Marshal([]string(l))
+	return string(encoded), err
+}
+
+func (l *StringList) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(l))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(l))
+	default:
+		return fmt.Errorf("cannot scan %T into StringList", src)
+	}
+}
+
+// Metadata is free-form JSON stored in a jsonb column, such as the Kong routes and plugins of an imported version.
+type Metadata json.RawMessage
+
+func (m Metadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return string(m), nil
+}
+
+func (m *Metadata) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+	case []byte:
+		*m = append(Metadata(nil), v...)
+	case string:
+		*m = Metadata(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", src)
+	}
+	return nil
+}
+
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	if len(m) == 0 {
+		return []byte("null"), nil
+	}
+	return m, nil
+}
+
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*m = nil
+		return nil
+	}
+	*m = append(Metadata(nil), data...)
+	return nil
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSink records what it published and fails while err is set.
+type fakeSink struct {
+	name      string
+	err       error
+	published []string
+}
+
+func (s *fakeSink) Name() string { return s.name }
+
+func (s *fakeSink) Publish(_ context.Context, event ChangeEvent, _ []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.published = append(s.published, event.ID)
+	return nil
+}
+
+func TestOutboxRelayPublish(t *testing.T) {
+	stable := &fakeSink{name: "stable"}
+	flaky := &fakeSink{name: "flaky", err: errors.New("unavailable")}
+	relay := &OutboxRelay{Sinks: []OutboxSink{stable, flaky}}
+
+	payload, _ := json.Marshal(ChangeEvent{ID: "evt-1", Type: EventServiceCreated})
+	entry := OutboxEntry{EventID: "evt-1", Payload: payload, PublishedTo: StringList{}}
+
+	// A failing sink is retried later without republishing to the others
+	relay.publish(context.Background(), &entry)
+	assert.Nil(t, entry.DeliveredAt)
+	assert.Equal(t, 1, entry.Attempts)
+	assert.Equal(t, StringList{"stable"}, entry.PublishedTo)
+	assert.Equal(t, "flaky: unavailable", entry.LastError)
+	assert.WithinDuration(t, time.Now().Add(RetryBase), *entry.NextAttemptAt, 5*time.Second)
+
+	flaky.err = nil
+	relay.publish(context.Background(), &entry)
+	assert.NotNil(t, entry.DeliveredAt)
+	assert.Nil(t, entry.NextAttemptAt)
+	assert.Empty(t, entry.LastError)
+	assert.Equal(t, StringList{"stable", "flaky"}, entry.PublishedTo)
+	assert.Equal(t, []string{"evt-1"}, stable.published)
+	assert.Equal(t, []string{"evt-1"}, flaky.published)
+}
+
+func TestNATSSink(t *testing.T) {
+	options := natstest.DefaultTestOptions
+	options.Port = -1
+	options.Username, options.Password = "dashboard", "secret"
+	server := natstest.RunServer(&options)
+	defer server.Shutdown()
+	serverURL := strings.Replace(server.ClientURL(), "nats://", "nats://dashboard:secret@", 1)
+
+	subscriber, err := nats.Connect(serverURL)
+	assert.NoError(t, err)
+	defer subscriber.Close()
+	subscription, err := subscriber.SubscribeSync("catalog.>")
+	assert.NoError(t, err)
+	assert.NoError(t, subscriber.Flush())
+
+	t.Run("Published", func(t *testing.T) {
+		sink := &NATSSink{URL: serverURL, SubjectPrefix: "catalog"}
+		defer sink.Close()
+		var conn *nats.Conn
+		for _, id := range []string{"evt-1", "evt-2"} {
+			event := ChangeEvent{ID: id, Type: EventServiceUpdated}
+			assert.NoError(t, sink.Publish(context.Background(), event, []byte(`{"id":"`+id+`"}`)))
+
+			msg, err := subscription.NextMsg(time.Second)
+			assert.NoError(t, err)
+			assert.Equal(t, "catalog.service.updated", msg.Subject)
+			assert.Equal(t, `{"id":"`+id+`"}`, string(msg.Data))
+
+			if conn == nil {
+				conn = sink.conn
+			}
+			assert.Same(t, conn, sink.conn, "every event uses the same connection")
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		sink := &NATSSink{URL: server.ClientURL(), SubjectPrefix: "catalog"}
+		err := sink.Publish(context.Background(), ChangeEvent{ID: "evt-3", Type: EventServiceUpdated}, []byte(`{}`))
+		assert.ErrorContains(t, err, "Authorization Violation")
+	})
+}
+
+func TestKafkaSink(t *testing.T) {
+	var status int
+	var body map[string][]map[string]interface{}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/topics/catalog-events", r.URL.Path)
+		assert.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		raw, _ := io.ReadAll(r.Body)
+		json.Unmarshal(raw, &body)
+		w.WriteHeader(status)
+	}))
+	defer proxy.Close()
+
+	sink := KafkaSink{RESTURL: proxy.URL + "/", Topic: "catalog-events"}
+	event := ChangeEvent{ID: "evt-1", Type: EventServiceDeleted}
+
+	status = http.StatusOK
+	assert.NoError(t, sink.Publish(context.Background(), event, []byte(`{"id":"evt-1"}`)))
+	assert.Len(t, body["records"], 1)
+	assert.Equal(t, "evt-1", body["records"][0]["key"])
+	assert.Equal(t, map[string]interface{}{"id": "evt-1"}, body["records"][0]["value"])
+
+	status = http.StatusInternalServerError
+	assert.ErrorContains(t, sink.Publish(context.Background(), event, []byte(`{}`)), "500")
+}
+
+func TestOutboxRelay(t *testing.T) {
+	db := GetDBInstance()
+
+	req := httptest.NewRequest("POST", "/v1/services", strings.NewReader(`{"service_name": "Outbox Service"}`))
+	rr := httptest.NewRecorder()
+	CreateService(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var service Service
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &service))
+
+	// The event is stored with the service and waits for the relay
+	var entry OutboxEntry
+	assert.NoError(t, db.Where("event_type = ? AND payload->'data'->>'service_name' = ?", EventServiceCreated, "Outbox Service").First(&entry).Error)
+	assert.Nil(t, entry.DeliveredAt)
+
+	sink := &fakeSink{name: "test"}
+	relay := &OutboxRelay{Sinks: []OutboxSink{sink}, Retention: time.Hour}
+	_, err := relay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, sink.published, entry.EventID)
+
+	assert.NoError(t, db.First(&entry, entry.ID).Error)
+	assert.NotNil(t, entry.DeliveredAt)
+	assert.Equal(t, StringList{"test"}, entry.PublishedTo)
+
+	// Delivered entries are compacted once past the retention
+	relay.Retention = 0
+	_, err = relay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Error(t, db.First(&entry, entry.ID).Error)
+}
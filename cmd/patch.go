@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Media types accepted by the PATCH endpoints.
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// Error codes specific to PATCH requests.
+const (
+	CodeInvalidPatch         = "invalid_patch"
+	CodeReadOnlyField        = "read_only"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+)
+
+// PatchSpec describes which parts of a resource a PATCH request may change.
+type PatchSpec struct {
+	// Allowed lists the JSON paths callers may change, using dots for nested objects, e.g. "user_profile.email".
+	Allowed []string
+	// Preload lists the associations loaded before the patch is applied.
+	Preload []string
+	// FullSaveAssociations also writes changes to preloaded associations.
+	FullSaveAssociations bool
+}
+
+var servicePatchSpec = PatchSpec{
+	Allowed: []string{"service_name", "service_description"},
+}
+
+var serviceVersionPatchSpec = PatchSpec{
+	Allowed: []string{"service_version_name", "service_version_url", "service_version_description", "health_path"},
+}
+
+var userPatchSpec = PatchSpec{
+	Allowed:              []string{"username", "password", "user_profile.first_name", "user_profile.last_name", "user_profile.email"},
+	Preload:              []string{"UserProfile"},
+	FullSaveAssociations: true,
+}
+
+// PatchService applies a JSON Merge Patch or JSON Patch to the service identified by ?id=.
+func PatchService(w http.ResponseWriter, r *http.Request) {
+	patchResource[Service](w, r, servicePatchSpec)
+}
+
+// PatchServiceVersion applies a JSON Merge Patch or JSON Patch to the service version identified by ?id=.
+func PatchServiceVersion(w http.ResponseWriter, r *http.Request) {
+	patchResource[ServiceVersion](w, r, serviceVersionPatchSpec)
+}
+
+// PatchUser applies a JSON Merge Patch or JSON Patch to the user identified by ?id=.
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	patchResource[User](w, r, userPatchSpec)
+}
+
+// patchResource loads the resource, applies the patch document and saves the result in one transaction.
+//
+// The row is locked while the patch is applied and If-Match must name its current revision. Changes outside
+// spec.Allowed, unknown fields and values failing validation all reject the whole patch, so either every
+// change is stored or none is.
+func patchResource[T any](w http.ResponseWriter, r *http.Request, spec PatchSpec) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil || id == 0 {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "ID parameter is required")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeReadProblem(w, r, err, CodeInvalidPatch, "Failed to read patch document")
+		return
+	}
+
+	apply, rej := patchFunc(r.Header.Get("Content-Type"), body)
+	if rej != nil {
+		writeRejection(w, r, rej)
+		return
+	}
+
+	var updated T
+	err = GetDBInstance().WithContext(r.Context()).Transaction(func(tx *gorm.DB) error {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+		for _, association := range spec.Preload {
+			query = query.Preload(association)
+		}
+		var current T
+		if err := query.First(&current, id).Error; err != nil {
+			return err
+		}
+		if rej := checkIfMatch(r, any(&current).(revisioned)); rej != nil {
+			return rej
+		}
+
+		original, err := json.Marshal(&current)
+		if err != nil {
+			return err
+		}
+		patched, err := apply(original)
+		if err != nil {
+			return &rejection{status: http.StatusUnprocessableEntity, code: CodeInvalidPatch, detail: "Patch could not be applied: " + err.Error()}
+		}
+
+		fieldErrors := unknownFields(patched, reflect.TypeOf(updated), "")
+		fieldErrors = append(fieldErrors, disallowedChanges(original, patched, spec.Allowed)...)
+		if len(fieldErrors) == 0 {
+			if err := json.Unmarshal(patched, &updated); err != nil {
+				return &rejection{status: http.StatusUnprocessableEntity, code: CodeInvalidPatch, detail: "Patched document does not match the resource schema"}
+			}
+			fieldErrors = validationErrors(&updated)
+		}
+		if len(fieldErrors) > 0 {
+			return &rejection{status: http.StatusUnprocessableEntity, code: CodeValidationFailed, detail: "Patch validation failed", fieldErrors: fieldErrors}
+		}
+
+		if spec.FullSaveAssociations {
+			tx = tx.Session(&gorm.Session{FullSaveAssociations: true})
+		}
+		return updateWithRevision(tx, any(&updated).(revisioned), any(&current).(revisioned).currentRevision())
+	})
+
+	var rejected *rejection
+	switch {
+	case errors.As(err, &rejected):
+		writeRejection(w, r, rejected)
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
+		return
+	case errors.Is(err, errRevisionMismatch):
+		writeRevisionMismatch(w, r)
+		return
+	case writeUniqueViolation(w, r, err, "Resource already exists"):
+		return
+	case err != nil:
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to apply patch")
+		requestLogger(r).Error("Error applying patch", "error", err)
+		return
+	}
+
+	setJSONHeader(w)
+	w.Header().Set("ETag", any(&updated).(revisioned).ETag())
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&updated)
+}
+
+// patchFunc returns a function applying the patch document according to its media type.
+func patchFunc(contentType string, body []byte) (func([]byte) ([]byte, error), *rejection) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch mediaType {
+	case MergePatchContentType:
+		if !json.Valid(body) {
+			return nil, &rejection{status: http.StatusBadRequest, code: CodeInvalidPatch, detail: "Merge patch is not valid JSON"}
+		}
+		return func(original []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(original, body)
+		}, nil
+	case JSONPatchContentType:
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return nil, &rejection{status: http.StatusBadRequest, code: CodeInvalidPatch, detail: "JSON Patch document is malformed"}
+		}
+		return patch.Apply, nil
+	default:
+		return nil, &rejection{
+			status: http.StatusUnsupportedMediaType,
+			code:   CodeUnsupportedMediaType,
+			detail: "Content-Type must be " + MergePatchContentType + " or " + JSONPatchContentType,
+		}
+	}
+}
+
+// disallowedChanges compares the documents before and after the patch and reports every changed
+// path that is not covered by the allow-list.
+func disallowedChanges(original, patched []byte, allowed []string) []FieldError {
+	var before, after map[string]interface{}
+	if json.Unmarshal(original, &before) != nil || json.Unmarshal(patched, &after) != nil {
+		return []FieldError{{Field: "", Code: CodeInvalidPatch, Message: "patched document must be a JSON object"}}
+	}
+
+	allowedPaths := make(map[string]bool)
+	for _, path := range allowed {
+		allowedPaths[path] = true
+	}
+	return diffObjects(before, after, "", allowedPaths)
+}
+
+func diffObjects(before, after map[string]interface{}, prefix string, allowed map[string]bool) []FieldError {
+	keys := make(map[string]bool)
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	var fieldErrors []FieldError
+	for _, key := range sortedKeys(keys) {
+		path := prefix + key
+		if allowed[path] || reflect.DeepEqual(before[key], after[key]) {
+			continue
+		}
+
+		// Descend into nested objects when only some of their members are allow-listed
+		beforeObject, beforeOK := before[key].(map[string]interface{})
+		afterObject, afterOK := after[key].(map[string]interface{})
+		if beforeOK && afterOK && hasAllowedChild(allowed, path) {
+			fieldErrors = append(fieldErrors, diffObjects(beforeObject, afterObject, path+".", allowed)...)
+			continue
+		}
+
+		fieldErrors = append(fieldErrors, FieldError{Field: path, Code: CodeReadOnlyField, Message: "cannot be changed with PATCH"})
+	}
+	return fieldErrors
+}
+
+func hasAllowedChild(allowed map[string]bool, path string) bool {
+	for candidate := range allowed {
+		if strings.HasPrefix(candidate, path+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDisallowedChanges(t *testing.T) {
+	original := []byte(`{"ID": 1, "username": "user", "role": "user", "user_profile": {"ID": 2, "email": "a@b.c"}}`)
+
+	tests := []struct {
+		name       string
+		patched    string
+		wantFields []string
+	}{
+		{"NoChange", `{"ID": 1, "username": "user", "role": "user", "user_profile": {"ID": 2, "email": "a@b.c"}}`, nil},
+		{"AllowedChanges", `{"ID": 1, "username": "renamed", "role": "user", "user_profile": {"ID": 2, "email": "x@y.z"}}`, nil},
+		{"RoleAndID", `{"ID": 9, "username": "user", "role": "admin", "user_profile": {"ID": 2, "email": "a@b.c"}}`, []string{"ID", "role"}},
+		{"NestedID", `{"ID": 1, "username": "user", "role": "user", "user_profile": {"ID": 3, "email": "a@b.c"}}`, []string{"user_profile.ID"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, fe := range disallowedChanges(original, []byte(tt.patched), userPatchSpec.Allowed) {
+				fields = append(fields, fe.Field)
+			}
+			assert.Equal(t, tt.wantFields, fields)
+		})
+	}
+}
+
+func TestPatchHandlers(t *testing.T) {
+	db := GetDBInstance()
+	service := Service{ServiceName: "ServiceForPatch", ServiceDescription: "Original description"}
+	db.Create(&service)
+	user := User{Username: "UserForPatch", Password: "password", Role: "user", UserProfile: UserProfile{Email: "patch@example.com"}}
+	db.Create(&user)
+
+	tests := []struct {
+		name        string
+		url         string
+		handler     http.HandlerFunc
+		contentType string
+		ifMatch     string
+		payload     string
+		statusCode  int
+		body        string
+	}{
+		{"TestMergePatchService", "/services?id=" + fmt.Sprint(service.ID), PatchService, MergePatchContentType, `"1"`, `{"service_description": "Patched"}`, http.StatusOK, `"service_name":"ServiceForPatch"`},
+		{"TestJSONPatchService", "/services?id=" + fmt.Sprint(service.ID), PatchService, JSONPatchContentType, `"2"`, `[{"op": "replace", "path": "/service_name", "value": "ServiceForPatch2"}]`, http.StatusOK, "ServiceForPatch2"},
+		{"TestPatchServiceID", "/services?id=" + fmt.Sprint(service.ID), PatchService, MergePatchContentType, `"3"`, `{"ID": 99999}`, http.StatusUnprocessableEntity, `"code":"read_only"`},
+		{"TestPatchServiceInvalid", "/services?id=" + fmt.Sprint(service.ID), PatchService, MergePatchContentType, `"3"`, `{"service_name": ""}`, http.StatusUnprocessableEntity, `"field":"service_name"`},
+		{"TestPatchServiceNotFound", "/services?id=10000", PatchService, MergePatchContentType, `"1"`, `{"service_description": "x"}`, http.StatusNotFound, "Resource not found"},
+		{"TestPatchServiceStaleIfMatch", "/services?id=" + fmt.Sprint(service.ID), PatchService, MergePatchContentType, `"1"`, `{"service_description": "Stale"}`, http.StatusPreconditionFailed, "precondition_failed"},
+		{"TestPatchServiceUnsupportedMediaType", "/services?id=" + fmt.Sprint(service.ID), PatchService, "application/json", `"3"`, `{}`, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"TestPatchUserKeepsPassword", "/users?id=" + fmt.Sprint(user.ID), PatchUser, MergePatchContentType, `"1"`, `{"user_profile": {"first_name": "Patched"}}`, http.StatusOK, `"password":"password"`},
+		{"TestPatchUserRole", "/users?id=" + fmt.Sprint(user.ID), PatchUser, MergePatchContentType, `"2"`, `{"role": "admin"}`, http.StatusUnprocessableEntity, `"field":"role"`},
+		{"TestJSONPatchUserFailedTest", "/users?id=" + fmt.Sprint(user.ID), PatchUser, JSONPatchContentType, `"2"`, `[{"op": "test", "path": "/username", "value": "someone-else"}]`, http.StatusUnprocessableEntity, "invalid_patch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("PATCH", tt.url, strings.NewReader(tt.payload))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("If-Match", tt.ifMatch)
+
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.statusCode, rr.Code)
+			assert.Contains(t, rr.Body.String(), tt.body)
+		})
+	}
+}
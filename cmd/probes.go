@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lifecycle phases of the server. Only a ready server receives traffic.
+const (
+	PhaseStarting = "starting"
+	PhaseReady    = "ready"
+	PhaseStopping = "stopping"
+)
+
+// Readiness of the server, reported by /readyz.
+const (
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+)
+
+// Outcomes of a readiness check.
+const (
+	CheckPass = "pass"
+	CheckFail = "fail"
+)
+
+// CodeNotReady is returned for API requests received while the server is still starting.
+const CodeNotReady = "not_ready"
+
+// readinessCheckTimeout bounds the database queries of a readiness check, so a hung database fails the
+// probe instead of hanging it.
+const readinessCheckTimeout = 2 * time.Second
+
+// workerStaleIntervals is how many intervals a background worker may go without completing a run before it
+// is reported as stuck. Workers with short intervals get at least workerStaleMinimum.
+const (
+	workerStaleIntervals = 3
+	workerStaleMinimum   = time.Minute
+)
+
+// migrationsDir holds the migrations applied at startup.
+const migrationsDir = "./migrations"
+
+// probePaths are served without a token and while the server is starting.
+var probePaths = []string{"/livez", "/readyz", "/health"}
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	Status          string     `json:"status"`
+	LatencyMS       *float64   `json:"latency_ms,omitempty"`
+	Version         *uint      `json:"version,omitempty"`
+	ExpectedVersion *uint      `json:"expected_version,omitempty"`
+	LastRunAt       *time.Time `json:"last_run_at,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// Readiness is the body of /readyz.
+type Readiness struct {
+	Status string                 `json:"status"`
+	Phase  string                 `json:"phase"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// workerState is what is known about a background worker from its runs.
+type workerState struct {
+	interval  time.Duration
+	startedAt time.Time
+	lastRunAt time.Time
+	lastError string
+	stopped   bool
+}
+
+// Lifecycle tracks the phase of the server and the health of its background workers.
+type Lifecycle struct {
+	mu      sync.Mutex
+	phase   string
+	workers map[string]*workerState
+}
+
+// lifecycle is the lifecycle of the running server.
+var lifecycle = NewLifecycle()
+
+// NewLifecycle returns a lifecycle in the starting phase.
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{phase: PhaseStarting, workers: map[string]*workerState{}}
+}
+
+// Phase returns the current phase.
+func (l *Lifecycle) Phase() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.phase
+}
+
+// SetPhase moves the server to phase.
+func (l *Lifecycle) SetPhase(phase string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.phase = phase
+}
+
+// WorkerStarted registers a worker running every interval. It is expected to complete a run soon.
+func (l *Lifecycle) WorkerStarted(name string, interval time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.workers[name] = &workerState{interval: interval, startedAt: time.Now()}
+}
+
+// WorkerRan records that a worker completed a run, failing with err if not nil.
+func (l *Lifecycle) WorkerRan(name string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if worker, ok := l.workers[name]; ok {
+		worker.lastRunAt = time.Now()
+		worker.lastError = ""
+		if err != nil {
+			worker.lastError = err.Error()
+		}
+	}
+}
+
+// WorkerStopped records that a worker returned.
+func (l *Lifecycle) WorkerStopped(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if worker, ok := l.workers[name]; ok {
+		worker.stopped = true
+	}
+}
+
+// workerChecks reports every worker that stopped or has not completed a run for too long as failed.
+// A worker whose last run failed still passes: its error, e.g. an unreachable Kong, is reported, but does not
+// make the API unable to serve.
+func (l *Lifecycle) workerChecks(now time.Time) map[string]CheckResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	checks := map[string]CheckResult{}
+	for name, worker := range l.workers {
+		check := CheckResult{Status: CheckPass, Error: worker.lastError}
+		last := worker.startedAt
+		if !worker.lastRunAt.IsZero() {
+			lastRunAt := worker.lastRunAt
+			check.LastRunAt = &lastRunAt
+			last = lastRunAt
+		}
+		staleAfter := max(workerStaleIntervals*worker.interval, workerStaleMinimum)
+		switch {
+		case worker.stopped:
+			check.Status = CheckFail
+			check.Error = "worker stopped"
+		case now.Sub(last) > staleAfter:
+			check.Status = CheckFail
+			check.Error = fmt.Sprintf("no completed run for %s", now.Sub(last).Round(time.Second))
+		}
+		checks["worker:"+name] = check
+	}
+	return checks
+}
+
+// LivenessHandler reports that the process is up. It checks no dependency, so a database outage does not get
+// the server restarted.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// ReadinessHandler reports whether the server can serve traffic: it is neither starting nor stopping, the
+// database answers, every migration is applied and the background workers are running. It answers 503
+// otherwise, with the outcome of every check.
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := lifecycle.Readiness(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if readiness.Status != StatusReady {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(readiness)
+}
+
+// Readiness runs the readiness checks. The database is not touched while the server is starting, as it may
+// not be connected and migrated yet.
+func (l *Lifecycle) Readiness(ctx context.Context) Readiness {
+	readiness := Readiness{Status: StatusReady, Phase: l.Phase()}
+	if readiness.Phase == PhaseStarting {
+		readiness.Status = StatusNotReady
+		return readiness
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+	readiness.Checks = l.workerChecks(time.Now())
+	readiness.Checks["database"] = checkDatabase(ctx)
+	readiness.Checks["migrations"] = checkMigrations(ctx, migrationsDir)
+
+	if readiness.Phase != PhaseReady {
+		readiness.Status = StatusNotReady
+	}
+	for _, check := range readiness.Checks {
+		if check.Status != CheckPass {
+			readiness.Status = StatusNotReady
+		}
+	}
+	return readiness
+}
+
+// checkDatabase pings the database.
+func checkDatabase(ctx context.Context) CheckResult {
+	start := time.Now()
+	sqlDB, err := GetDBInstance().DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	latency := float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		return CheckResult{Status: CheckFail, LatencyMS: &latency, Error: err.Error()}
+	}
+	return CheckResult{Status: CheckPass, LatencyMS: &latency}
+}
+
+// checkMigrations compares the schema version recorded by golang-migrate with the newest migration in dir.
+// A newer schema passes, so instances of the previous release stay ready while a rollout migrates.
+func checkMigrations(ctx context.Context, dir string) CheckResult {
+	expected, err := latestMigrationVersion(dir)
+	if err != nil {
+		return CheckResult{Status: CheckFail, Error: err.Error()}
+	}
+
+	var version uint
+	var dirty bool
+	err = GetDBInstance().WithContext(ctx).Raw("SELECT version, dirty FROM schema_migrations LIMIT 1").Row().Scan(&version, &dirty)
+	if err != nil {
+		return CheckResult{Status: CheckFail, ExpectedVersion: &expected, Error: err.Error()}
+	}
+
+	check := CheckResult{Status: CheckPass, Version: &version, ExpectedVersion: &expected}
+	switch {
+	case dirty:
+		check.Status = CheckFail
+		check.Error = "a migration failed halfway, the schema is dirty"
+	case version < expected:
+		check.Status = CheckFail
+		check.Error = "migrations are pending"
+	}
+	return check
+}
+
+// latestMigrationVersion returns the highest version of the migrations in dir, named NNNNNN_name.up.sql.
+func latestMigrationVersion(dir string) (uint, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var latest uint64
+	found := false
+	for _, entry := range entries {
+		prefix, _, ok := strings.Cut(entry.Name(), "_")
+		if !ok || !strings.HasSuffix(entry.Name(), ".up.sql") {
+			continue
+		}
+		if version, err := strconv.ParseUint(prefix, 10, 64); err == nil {
+			latest = max(latest, version)
+			found = true
+		}
+	}
+	if !found {
+		return 0, errors.New("no migrations found in " + dir)
+	}
+	return uint(latest), nil
+}
+
+// isProbePath tells whether r is for a liveness or readiness probe.
+func isProbePath(r *http.Request) bool {
+	for _, path := range probePaths {
+		if r.URL.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// StartupMiddleware answers every request but the probes with 503 until the server is ready, so nothing reaches
+// the database before it is migrated.
+func StartupMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isProbePath(r) && lifecycle.Phase() == PhaseStarting {
+			w.Header().Set("Retry-After", "1")
+			writeProblem(w, r, http.StatusServiceUnavailable, CodeNotReady, "The server is starting")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
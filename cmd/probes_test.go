@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerChecks(t *testing.T) {
+	l := NewLifecycle()
+	l.WorkerStarted("outbox_relay", time.Second)
+	l.WorkerStarted("kong_sync", time.Minute)
+	l.WorkerStarted("webhook_dispatcher", time.Second)
+	l.WorkerStarted("health_prober", time.Minute)
+	l.WorkerRan("outbox_relay", nil)
+	l.WorkerRan("kong_sync", errors.New("connection refused"))
+	l.WorkerStopped("webhook_dispatcher")
+
+	tests := []struct {
+		name   string
+		at     time.Duration
+		worker string
+		status string
+		error  string
+	}{
+		{"recent run", 0, "outbox_relay", CheckPass, ""},
+		{"failed run still passes", 0, "kong_sync", CheckPass, "connection refused"},
+		{"stopped", 0, "webhook_dispatcher", CheckFail, "worker stopped"},
+		{"first run in progress", 0, "health_prober", CheckPass, ""},
+		{"short interval gets the minimum", 30 * time.Second, "outbox_relay", CheckPass, ""},
+		{"stale", 2 * time.Minute, "outbox_relay", CheckFail, "no completed run for 2m0s"},
+		{"first run stuck", 4 * time.Minute, "health_prober", CheckFail, "no completed run for 4m0s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := l.workerChecks(time.Now().Add(tt.at))["worker:"+tt.worker]
+			assert.Equal(t, tt.status, check.Status)
+			assert.Equal(t, tt.error, check.Error)
+		})
+	}
+}
+
+func TestLatestMigrationVersion(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"000001_init.up.sql", "000001_init.down.sql", "000012_outbox.up.sql", "000013_next.down.sql", "README.md"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	version, err := latestMigrationVersion(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(12), version)
+
+	_, err = latestMigrationVersion(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestStartupPhase(t *testing.T) {
+	defer func(previous *Lifecycle) { lifecycle = previous }(lifecycle)
+	lifecycle = NewLifecycle()
+
+	handler := StartupMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" {
+			ReadinessHandler(w, r)
+		}
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/v1/services", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), CodeNotReady)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	var readiness Readiness
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &readiness))
+	assert.Equal(t, Readiness{Status: StatusNotReady, Phase: PhaseStarting}, readiness)
+}
+
+func TestLivenessHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	RoleBasedMiddleware(http.HandlerFunc(LivenessHandler)).ServeHTTP(rr, httptest.NewRequest("GET", "/livez", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status":"ok"}`, rr.Body.String())
+}
+
+func TestReadiness(t *testing.T) {
+	l := NewLifecycle()
+	l.SetPhase(PhaseReady)
+	readiness := l.Readiness(context.Background())
+	assert.Equal(t, CheckPass, readiness.Checks["database"].Status)
+	assert.NotNil(t, readiness.Checks["database"].LatencyMS)
+	assert.Contains(t, readiness.Checks, "migrations")
+
+	l.SetPhase(PhaseStopping)
+	readiness = l.Readiness(context.Background())
+	assert.Equal(t, StatusNotReady, readiness.Status)
+	assert.Equal(t, CheckPass, readiness.Checks["database"].Status)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Stable, machine-readable error codes returned in the "code" member of problem details.
+const (
+	CodeInvalidJSON      = "invalid_json"
+	CodeInvalidParameter = "invalid_parameter"
+	CodeValidationFailed = "validation_failed"
+	CodeNotFound         = "not_found"
+	CodeConflict         = "conflict"
+	CodeUnauthorized     = "unauthorized"
+	CodeInvalidToken     = "invalid_token"
+	CodeTokenExpired     = "token_expired"
+	CodeForbidden        = "forbidden"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeInternal         = "internal_error"
+)
+
+// problemTypeBase prefixes every error code to form the RFC 7807 "type" URI.
+const problemTypeBase = "urn:kong-service-dashboard:problem:"
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body, extended with a stable error code,
+// the request ID and field-level errors.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes a problem with a single request field or query parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeProblem writes an application/problem+json response.
+//
+// The detail is shown to clients as-is, so it must never include internal errors; log those instead.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string, fieldErrors ...FieldError) {
+	problem := newProblem(r, status, code, detail, fieldErrors...)
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		requestLogger(r).Error("Encoding error", "error", err)
+	}
+}
+
+// newProblem builds the problem details for a request without writing them.
+func newProblem(r *http.Request, status int, code, detail string, fieldErrors ...FieldError) Problem {
+	return Problem{
+		Type:      problemTypeBase + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: RequestIDFromContext(r.Context()),
+		Errors:    fieldErrors,
+	}
+}
+
+// rejection carries a problem response out of code that cannot write it directly, such as a
+// transaction callback, which returns it as an error.
+type rejection struct {
+	status      int
+	code        string
+	detail      string
+	fieldErrors []FieldError
+}
+
+func (e *rejection) Error() string {
+	return e.detail
+}
+
+func writeRejection(w http.ResponseWriter, r *http.Request, rej *rejection) {
+	writeProblem(w, r, rej.status, rej.code, rej.detail, rej.fieldErrors...)
+}
+
+// writeQueryProblem reports an invalid query string, attaching the offending parameter as a field error.
+func writeQueryProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, queryErr.Error(),
+			FieldError{Field: queryErr.Param, Code: CodeInvalidParameter, Message: queryErr.Message})
+		return
+	}
+	writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid query parameters")
+}
+
+// notFoundHandler and methodNotAllowedHandler replace the router's plain-text defaults.
+func notFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "No route matches "+r.URL.Path)
+	})
+}
+
+func methodNotAllowedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+	})
+}
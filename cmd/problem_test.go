@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteProblem(t *testing.T) {
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(w, r, http.StatusBadRequest, CodeValidationFailed, "ID is required",
+			FieldError{Field: "id", Code: "required", Message: "ID is required"})
+	}))
+
+	req, err := http.NewRequest("PUT", "/v1/services", nil)
+	assert.NoError(t, err)
+	req.Header.Set(RequestIDHeader, "test-request-id")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, ProblemContentType, rr.Header().Get("Content-Type"))
+	assert.Equal(t, "test-request-id", rr.Header().Get(RequestIDHeader))
+
+	var problem Problem
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+	assert.Equal(t, CodeValidationFailed, problem.Code)
+	assert.Equal(t, problemTypeBase+CodeValidationFailed, problem.Type)
+	assert.Equal(t, "/v1/services", problem.Instance)
+	assert.Equal(t, "test-request-id", problem.RequestID)
+	assert.Equal(t, []FieldError{{Field: "id", Code: "required", Message: "ID is required"}}, problem.Errors)
+}
+
+func TestRequestIDMiddlewareGeneratesID(t *testing.T) {
+	var seen string
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	req, err := http.NewRequest("GET", "/v1/services", nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.NotEmpty(t, seen)
+	assert.Equal(t, seen, rr.Header().Get(RequestIDHeader))
+}
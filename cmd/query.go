@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindInt
+	kindTime
+	kindBool
+)
+
+// queryOperators lists the comparison operators understood by the filter grammar.
+// Two-character operators come first so that ">=" is not read as ">".
+var queryOperators = []string{">=", "<=", "!=", ">", "<", "="}
+
+// QueryField describes a single filterable or sortable field of a list endpoint.
+type QueryField struct {
+	Column   string
+	Kind     fieldKind
+	Sortable bool
+	// Apply overrides the default "column op value" condition, e.g. for virtual fields.
+	Apply func(db *gorm.DB, op string, value interface{}) *gorm.DB
+}
+
+// QuerySchema describes the fields a list endpoint accepts in its filter and sort grammar.
+//
+// Reserved names are query parameters the handler consumes itself (e.g. "id" or "load_version");
+// a plain "name=value" for them is never treated as a filter.
+type QuerySchema struct {
+	Fields      map[string]QueryField
+	Reserved    []string
+	DefaultSort []SortKey
+}
+
+// Filter is a single parsed "field op value" condition.
+type Filter struct {
+	Field string
+	Op    string
+	Value interface{}
+}
+
+// SortKey is a single parsed sort key; Desc is set when the key was prefixed with "-".
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// ListQuery is the parsed form of a list endpoint's query string.
+type ListQuery struct {
+	Filters []Filter
+	Sort    []SortKey
+	Page    int
+	Limit   int
+}
+
+// QueryError reports an invalid query parameter.
+type QueryError struct {
+	Param   string
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("Invalid %s parameter: %s", e.Param, e.Message)
+}
+
+// commonParams are consumed by every list endpoint.
+var commonParams = []string{"page", "limit", "sort", "sort_by", "order", "fields", "expand"}
+
+// ParseListQuery parses pagination, sorting and filters from the request against the schema.
+//
+// Filters use the grammar "field op value" where op is one of =, !=, >, >=, < or <=, e.g.
+// "?updated_at>=2024-01-01&has_versions=true". Sorting uses "?sort=-updated_at,service_name",
+// where a leading "-" sorts descending. The legacy "sort_by" and "order" pair is still accepted
+// when "sort" is absent. Unknown fields are rejected with a QueryError.
+func ParseListQuery(r *http.Request, schema QuerySchema) (ListQuery, error) {
+	q := ListQuery{Page: 1, Limit: 10}
+	values := r.URL.Query()
+
+	if page := values.Get("page"); page != "" {
+		pageInt, err := strconv.Atoi(page)
+		if err != nil || pageInt < 1 {
+			return q, &QueryError{Param: "page", Message: "must be a positive integer"}
+		}
+		q.Page = pageInt
+	}
+	if limit := values.Get("limit"); limit != "" {
+		limitInt, err := strconv.Atoi(limit)
+		if err != nil || limitInt < 1 {
+			return q, &QueryError{Param: "limit", Message: "must be a positive integer"}
+		}
+		q.Limit = limitInt
+	}
+
+	sortKeys, err := parseSort(values, schema)
+	if err != nil {
+		return q, err
+	}
+	q.Sort = sortKeys
+
+	filters, err := parseFilters(r.URL.RawQuery, schema)
+	if err != nil {
+		return q, err
+	}
+	q.Filters = filters
+
+	return q, nil
+}
+
+func parseSort(values url.Values, schema QuerySchema) ([]SortKey, error) {
+	var keys []SortKey
+
+	if sortParam := values.Get("sort"); sortParam != "" {
+		for _, raw := range strings.Split(sortParam, ",") {
+			raw = strings.TrimSpace(raw)
+			key := SortKey{Field: strings.TrimPrefix(raw, "-"), Desc: strings.HasPrefix(raw, "-")}
+			if key.Field == "" {
+				return nil, &QueryError{Param: "sort", Message: "empty sort key"}
+			}
+			keys = append(keys, key)
+		}
+	} else if sortBy := values.Get("sort_by"); sortBy != "" {
+		order := values.Get("order")
+		if order == "" {
+			order = "asc"
+		}
+		if order != "asc" && order != "desc" {
+			return nil, &QueryError{Param: "order", Message: "must be asc or desc"}
+		}
+		keys = append(keys, SortKey{Field: sortBy, Desc: order == "desc"})
+	} else {
+		if order := values.Get("order"); order != "" && order != "asc" && order != "desc" {
+			return nil, &QueryError{Param: "order", Message: "must be asc or desc"}
+		}
+		keys = append(keys, schema.DefaultSort...)
+		if values.Get("order") == "desc" {
+			for i := range keys {
+				keys[i].Desc = !keys[i].Desc
+			}
+		}
+	}
+
+	for _, key := range keys {
+		field, ok := schema.Fields[key.Field]
+		if !ok || !field.Sortable {
+			return nil, &QueryError{Param: "sort", Message: fmt.Sprintf("unknown sort field %q, allowed: %s", key.Field, strings.Join(schema.sortableFields(), ", "))}
+		}
+	}
+	return keys, nil
+}
+
+func parseFilters(rawQuery string, schema QuerySchema) ([]Filter, error) {
+	reserved := make(map[string]bool)
+	for _, name := range commonParams {
+		reserved[name] = true
+	}
+	for _, name := range schema.Reserved {
+		reserved[name] = true
+	}
+
+	var filters []Filter
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		decoded, err := url.QueryUnescape(part)
+		if err != nil {
+			return nil, &QueryError{Param: part, Message: "malformed query parameter"}
+		}
+
+		name, op, rawValue := splitCondition(decoded)
+		if reserved[name] && (op == "=" || op == "") {
+			continue
+		}
+
+		field, ok := schema.Fields[name]
+		if !ok {
+			return nil, &QueryError{Param: name, Message: fmt.Sprintf("unknown filter field, allowed: %s", strings.Join(schema.filterFields(), ", "))}
+		}
+		if op == "" {
+			return nil, &QueryError{Param: name, Message: "missing comparison operator"}
+		}
+		if !operatorAllowed(field.Kind, op) {
+			return nil, &QueryError{Param: name, Message: fmt.Sprintf("operator %q is not supported for this field", op)}
+		}
+
+		value, err := parseFieldValue(field.Kind, rawValue)
+		if err != nil {
+			return nil, &QueryError{Param: name, Message: err.Error()}
+		}
+		filters = append(filters, Filter{Field: name, Op: op, Value: value})
+	}
+	return filters, nil
+}
+
+// splitCondition splits "field>=value" into its field, operator and value.
+func splitCondition(s string) (string, string, string) {
+	idx := strings.IndexAny(s, "<>!=")
+	if idx < 0 {
+		return s, "", ""
+	}
+	for _, op := range queryOperators {
+		if strings.HasPrefix(s[idx:], op) {
+			return s[:idx], op, s[idx+len(op):]
+		}
+	}
+	return s[:idx], "", s[idx:]
+}
+
+func operatorAllowed(kind fieldKind, op string) bool {
+	switch kind {
+	case kindBool, kindString:
+		return op == "=" || op == "!="
+	default:
+		return true
+	}
+}
+
+func parseFieldValue(kind fieldKind, raw string) (interface{}, error) {
+	switch kind {
+	case kindInt:
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected an integer, got %q", raw)
+		}
+		return v, nil
+	case kindBool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false, got %q", raw)
+		}
+		return v, nil
+	case kindTime:
+		for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+			if v, err := time.Parse(layout, raw); err == nil {
+				return v, nil
+			}
+		}
+		return nil, fmt.Errorf("expected an RFC 3339 timestamp or YYYY-MM-DD date, got %q", raw)
+	default:
+		return raw, nil
+	}
+}
+
+// Apply adds the parsed filters, sort keys and pagination to the query.
+func (q ListQuery) Apply(db *gorm.DB, schema QuerySchema) *gorm.DB {
+	db = q.ApplyFilters(db, schema)
+	for _, key := range q.Sort {
+		direction := "ASC"
+		if key.Desc {
+			direction = "DESC"
+		}
+		db = db.Order(schema.Fields[key.Field].Column + " " + direction)
+	}
+	return db.Offset((q.Page - 1) * q.Limit).Limit(q.Limit)
+}
+
+// ApplyFilters adds only the parsed filters to the query.
+func (q ListQuery) ApplyFilters(db *gorm.DB, schema QuerySchema) *gorm.DB {
+	for _, f := range q.Filters {
+		field := schema.Fields[f.Field]
+		if field.Apply != nil {
+			db = field.Apply(db, f.Op, f.Value)
+			continue
+		}
+		op := f.Op
+		if op == "!=" {
+			op = "<>"
+		}
+		db = db.Where(field.Column+" "+op+" ?", f.Value)
+	}
+	return db
+}
+
+func (s QuerySchema) filterFields() []string {
+	var names []string
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s QuerySchema) sortableFields() []string {
+	var names []string
+	for name, field := range s.Fields {
+		if field.Sortable {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+var serviceQuerySchema = QuerySchema{
+	Fields: map[string]QueryField{
+		"id":           {Column: "services.id", Kind: kindInt, Sortable: true},
+		"service_name": {Column: "services.service_name", Kind: kindString, Sortable: true},
+		"created_at":   {Column: "services.created_at", Kind: kindTime, Sortable: true},
+		"updated_at":   {Column: "services.updated_at", Kind: kindTime, Sortable: true},
+		"has_versions": {Kind: kindBool, Apply: func(db *gorm.DB, op string, value interface{}) *gorm.DB {
+			exists := "EXISTS (SELECT 1 FROM service_versions WHERE service_versions.service_id = services.id AND service_versions.deleted_at IS NULL)"
+			if value.(bool) == (op == "!=") {
+				exists = "NOT " + exists
+			}
+			return db.Where(exists)
+		}},
+	},
+	Reserved:    []string{"id", "name", "search_mode", "load_version"},
+	DefaultSort: []SortKey{{Field: "id"}},
+}
+
+var serviceVersionQuerySchema = QuerySchema{
+	Fields: map[string]QueryField{
+		"id":                   {Column: "id", Kind: kindInt, Sortable: true},
+		"service_id":           {Column: "service_id", Kind: kindInt, Sortable: true},
+		"service_version_name": {Column: "service_version_name", Kind: kindString, Sortable: true},
+		"service_version_url":  {Column: "service_version_url", Kind: kindString},
+		"created_at":           {Column: "created_at", Kind: kindTime, Sortable: true},
+		"updated_at":           {Column: "updated_at", Kind: kindTime, Sortable: true},
+	},
+	DefaultSort: []SortKey{{Field: "id"}},
+}
+
+var userQuerySchema = QuerySchema{
+	Fields: map[string]QueryField{
+		"id":         {Column: "id", Kind: kindInt, Sortable: true},
+		"username":   {Column: "username", Kind: kindString, Sortable: true},
+		"role":       {Column: "role", Kind: kindString, Sortable: true},
+		"created_at": {Column: "created_at", Kind: kindTime, Sortable: true},
+		"updated_at": {Column: "updated_at", Kind: kindTime, Sortable: true},
+	},
+	Reserved:    []string{"id", "username"},
+	DefaultSort: []SortKey{{Field: "id"}},
+}
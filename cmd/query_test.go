@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseListQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		wantErr     bool
+		wantSort    []SortKey
+		wantFilters []Filter
+	}{
+		{"Defaults", "/services", false, []SortKey{{Field: "id"}}, nil},
+		{"MultiKeySort", "/services?sort=-updated_at,service_name", false, []SortKey{{Field: "updated_at", Desc: true}, {Field: "service_name"}}, nil},
+		{"LegacySortBy", "/services?sort_by=service_name&order=desc", false, []SortKey{{Field: "service_name", Desc: true}}, nil},
+		{"ComparisonFilter", "/services?updated_at>=2024-01-01", false, []SortKey{{Field: "id"}}, []Filter{{Field: "updated_at", Op: ">=", Value: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}},
+		{"EncodedOperator", "/services?created_at%3C2024-01-01T00:00:00Z", false, []SortKey{{Field: "id"}}, []Filter{{Field: "created_at", Op: "<", Value: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}},
+		{"BoolFilter", "/services?has_versions=true", false, []SortKey{{Field: "id"}}, []Filter{{Field: "has_versions", Op: "=", Value: true}}},
+		{"ReservedParamsIgnored", "/services?id=1&load_version=true&page=2", false, []SortKey{{Field: "id"}}, nil},
+		{"ReservedFieldWithOperator", "/services?id>=5", false, []SortKey{{Field: "id"}}, []Filter{{Field: "id", Op: ">=", Value: 5}}},
+		{"UnknownFilter", "/services?owner=alice", true, nil, nil},
+		{"UnknownSortField", "/services?sort=owner", true, nil, nil},
+		{"VirtualFieldNotSortable", "/services?sort=has_versions", true, nil, nil},
+		{"InvalidOperatorForString", "/services?service_name>abc", true, nil, nil},
+		{"InvalidTime", "/services?created_at>=yesterday", true, nil, nil},
+		{"InvalidOrder", "/services?sort_by=id&order=sideways", true, nil, nil},
+		{"InvalidLimit", "/services?limit=0", true, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.url, nil)
+			assert.NoError(t, err)
+
+			q, err := ParseListQuery(req, serviceQuerySchema)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSort, q.Sort)
+			assert.Equal(t, tt.wantFilters, q.Filters)
+		})
+	}
+}
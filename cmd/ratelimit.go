@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CodeRateLimited is returned when a client used up its rate limit.
+const CodeRateLimited = "rate_limited"
+
+// Rate limit stores.
+const (
+	RateLimitBackendMemory   = "memory"
+	RateLimitBackendPostgres = "postgres"
+)
+
+// DefaultRateLimits allow every client 600 requests a minute, and 20 logins a minute to slow down password guessing.
+const DefaultRateLimits = "*=600/1m,auth=20/1m"
+
+// rateLimitAllGroups is the group of a rule applying to every group without a rule of its own.
+const rateLimitAllGroups = "*"
+
+// rateLimitSweepInterval is how often buckets that filled up again are dropped.
+const rateLimitSweepInterval = time.Minute
+
+// rateLimitGroups assigns request paths to the route groups limits are configured for. The first group whose
+// path is the request path, or a parent of it, applies.
+var rateLimitGroups = []struct {
+	path  string
+	group string
+}{
+	{"/v1/auth", "auth"},
+	{"/v1/services", "services"},
+	{"/v1/service_versions", "services"},
+	{"/v1/users", "users"},
+	{"/v1/import/kong", "kong"},
+	{"/v1/kong", "kong"},
+	{"/v1/export", "catalog"},
+	{"/v1/import", "catalog"},
+	{"/v1/apply", "catalog"},
+	{"/v1/webhooks", "webhooks"},
+	{"/v1/events", "events"},
+	{"/metrics", "metrics"},
+}
+
+// RateLimitConfig configures RateLimitMiddleware.
+type RateLimitConfig struct {
+	Enabled bool   `yaml:"enabled" env:"SERVICE_DASHBOARD_RATE_LIMIT_ENABLED" usage:"limit the request rate of every client"`
+	Backend string `yaml:"backend" env:"SERVICE_DASHBOARD_RATE_LIMIT_BACKEND" usage:"memory, or postgres to share the limits between replicas"`
+	// Limits are group[:role]=requests/period rules, e.g. services:user=60/1m.
+	Limits []string `yaml:"limits" env:"SERVICE_DASHBOARD_RATE_LIMITS" usage:"comma-separated group[:role]=requests/period rules"`
+	// TrustedProxies may set X-Forwarded-For, e.g. the Kong gateway in front of the dashboard.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"SERVICE_DASHBOARD_TRUSTED_PROXIES" usage:"comma-separated addresses or CIDRs of proxies setting X-Forwarded-For"`
+}
+
+// validate reports every invalid rate limit setting.
+func (c RateLimitConfig) validate() []error {
+	var errs []error
+	if c.Backend != RateLimitBackendMemory && c.Backend != RateLimitBackendPostgres {
+		errs = append(errs, fmt.Errorf("rate_limit.backend must be %s or %s, got %q", RateLimitBackendMemory, RateLimitBackendPostgres, c.Backend))
+	}
+	if _, err := parseRateLimits(c.Limits); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit.limits: %w", err))
+	}
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limit.trusted_proxies: %w", err))
+	}
+	return errs
+}
+
+// RateLimit allows Requests per Period, in bursts of up to Requests.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// rate returns the tokens added to a bucket per second.
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// parseRateLimits parses group[:role]=requests/period rules into a map from group[:role] to limit.
+func parseRateLimits(rules []string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+	for _, rule := range rules {
+		scope, value, ok := strings.Cut(rule, "=")
+		scope, value = strings.TrimSpace(scope), strings.TrimSpace(value)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a group[:role]=requests/period rule", rule)
+		}
+		group, role, hasRole := strings.Cut(scope, ":")
+		if group != rateLimitAllGroups && !slices.ContainsFunc(rateLimitGroups, func(g struct{ path, group string }) bool { return g.group == group }) {
+			return nil, fmt.Errorf("%q: unknown group %q", rule, group)
+		}
+		if hasRole && !slices.Contains(AllowedRoles, role) {
+			return nil, fmt.Errorf("%q: role must be one of %s", rule, strings.Join(AllowedRoles, ", "))
+		}
+		requests, period, ok := strings.Cut(value, "/")
+		limit := RateLimit{}
+		var err error
+		if limit.Requests, err = strconv.Atoi(requests); err != nil || limit.Requests <= 0 {
+			return nil, fmt.Errorf("%q: requests must be a positive number", rule)
+		}
+		if limit.Period, err = time.ParseDuration(period); !ok || err != nil || limit.Period <= 0 {
+			return nil, fmt.Errorf("%q: period must be a positive duration, e.g. 1m", rule)
+		}
+		if _, ok := limits[scope]; ok {
+			return nil, fmt.Errorf("%q is limited twice", scope)
+		}
+		limits[scope] = limit
+	}
+	return limits, nil
+}
+
+// parseTrustedProxies parses addresses and CIDRs.
+func parseTrustedProxies(proxies []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, proxy := range proxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an address nor a CIDR", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// RateLimitResult is the state of a bucket after a request took a token from it, or failed to.
+type RateLimitResult struct {
+	Allowed   bool
+	Remaining int
+	// RetryAfter is when the next token is added, for a request that was not allowed.
+	RetryAfter time.Duration
+	// Reset is when the bucket is full again.
+	Reset time.Duration
+}
+
+// bucketResult returns the result of a bucket left with tokens.
+func bucketResult(limit RateLimit, tokens float64, allowed bool) RateLimitResult {
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Requests) - tokens) / limit.rate() * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / limit.rate() * float64(time.Second))
+	}
+	return result
+}
+
+// RateLimitStore keeps the token buckets.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key, which holds up to limit.Requests tokens and is refilled at the
+	// rate of limit. A missing bucket is full.
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// memoryBucket is a token bucket of a MemoryRateLimitStore.
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	limit     RateLimit
+}
+
+// MemoryRateLimitStore keeps the buckets in memory, so each replica limits on its own.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	sweptAt time.Time
+	now     func() time.Time
+}
+
+// NewMemoryRateLimitStore returns an empty in-memory store.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: map[string]*memoryBucket{}, sweptAt: time.Now(), now: time.Now}
+}
+
+// Take takes a token from the bucket of key.
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(limit.Requests), updatedAt: now}
+		s.buckets[key] = bucket
+	}
+	bucket.limit = limit
+	bucket.tokens = min(float64(limit.Requests), bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*limit.rate())
+	bucket.updatedAt = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	return bucketResult(limit, bucket.tokens, allowed), nil
+}
+
+// sweep drops the buckets that filled up again, which behave like missing ones, so clients that went away do
+// not hold memory.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < rateLimitSweepInterval {
+		return
+	}
+	s.sweptAt = now
+	for key, bucket := range s.buckets {
+		if bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*bucket.limit.rate() >= float64(bucket.limit.Requests) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// PostgresRateLimitStore keeps the buckets in the rate_limit_buckets table, so every replica shares them. The
+// database clock times the refills, so replicas with skewed clocks agree.
+type PostgresRateLimitStore struct {
+	db func() *gorm.DB
+
+	mu      sync.Mutex
+	sweptAt time.Time
+}
+
+// NewPostgresRateLimitStore returns a store using the database of db, called on every request.
+func NewPostgresRateLimitStore(db func() *gorm.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db, sweptAt: time.Now()}
+}
+
+// takeTokenSQL refills the bucket of a key and takes a token from it if it holds one, in a single statement so
+// concurrent requests cannot take the same token. Every expression of SET sees the row as it was.
+var takeTokenSQL = func() string {
+	refilled := "LEAST(CAST(@requests AS double precision), rate_limit_buckets.tokens + " +
+		"EXTRACT(EPOCH FROM now() - rate_limit_buckets.updated_at) * CAST(@rate AS double precision))"
+	return `INSERT INTO rate_limit_buckets (key, tokens, allowed, updated_at)
+VALUES (@key, CAST(@requests AS double precision) - 1, true, now())
+ON CONFLICT (key) DO UPDATE SET
+	tokens = CASE WHEN ` + refilled + ` >= 1 THEN ` + refilled + ` - 1 ELSE ` + refilled + ` END,
+	allowed = ` + refilled + ` >= 1,
+	updated_at = now()
+RETURNING tokens, allowed`
+}()
+
+// Take takes a token from the bucket of key.
+func (s *PostgresRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	db := s.db().WithContext(ctx)
+	s.sweep(db)
+
+	var tokens float64
+	var allowed bool
+	err := db.Raw(takeTokenSQL, map[string]interface{}{
+		"key":      key,
+		"requests": float64(limit.Requests),
+		"rate":     limit.rate(),
+	}).Row().Scan(&tokens, &allowed)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return bucketResult(limit, tokens, allowed), nil
+}
+
+// sweep deletes the buckets no request used for a day at most once per rateLimitSweepInterval. Periods are much
+// shorter, so those buckets are full again.
+func (s *PostgresRateLimitStore) sweep(db *gorm.DB) {
+	s.mu.Lock()
+	if time.Since(s.sweptAt) < rateLimitSweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.sweptAt = time.Now()
+	s.mu.Unlock()
+	db.Exec("DELETE FROM rate_limit_buckets WHERE updated_at < now() - interval '1 day'")
+}
+
+// RateLimiter picks the limit of every request and takes a token from the bucket of its client.
+type RateLimiter struct {
+	Store          RateLimitStore
+	Limits         map[string]RateLimit
+	TrustedProxies []netip.Prefix
+}
+
+// newRateLimiter returns the rate limiter of config, or nil when rate limiting is switched off. config is
+// expected to be valid.
+func newRateLimiter(config RateLimitConfig) *RateLimiter {
+	if !config.Enabled {
+		return nil
+	}
+
+	limits, _ := parseRateLimits(config.Limits)
+	proxies, _ := parseTrustedProxies(config.TrustedProxies)
+	var store RateLimitStore = NewMemoryRateLimitStore()
+	if config.Backend == RateLimitBackendPostgres {
+		store = NewPostgresRateLimitStore(GetDBInstance)
+	}
+	return &RateLimiter{Store: store, Limits: limits, TrustedProxies: proxies}
+}
+
+// rateLimitGroup returns the route group of path, or "" for paths in no group.
+func rateLimitGroup(path string) string {
+	for _, g := range rateLimitGroups {
+		if path == g.path || strings.HasPrefix(path, g.path+"/") {
+			return g.group
+		}
+	}
+	return ""
+}
+
+// limit returns the most specific rule for group and role: the one of the group and role, then of the group,
+// then of every group and the role, then of every group. The rule's scope names its bucket, so a group with a
+// rule of its own has a budget of its own.
+func (rl *RateLimiter) limit(group, role string) (string, RateLimit, bool) {
+	var scopes []string
+	if role != "" {
+		scopes = append(scopes, group+":"+role)
+	}
+	scopes = append(scopes, group)
+	if role != "" {
+		scopes = append(scopes, rateLimitAllGroups+":"+role)
+	}
+	scopes = append(scopes, rateLimitAllGroups)
+	for _, scope := range scopes {
+		if limit, ok := rl.Limits[scope]; ok {
+			return scope, limit, true
+		}
+	}
+	return "", RateLimit{}, false
+}
+
+// client identifies the caller of r: the subject of its token or client certificate once authenticated, such as
+// user:alice or cert:billing-sync, and its address otherwise. There is no API key to key on: the dashboard issues none, and services calling it
+// authenticate with a client certificate, whose common name is their subject.
+func (rl *RateLimiter) client(r *http.Request) string {
+	if subject := SubjectFromContext(r.Context()); subject != "" {
+		return subject
+	}
+	return "ip:" + rl.clientAddr(r)
+}
+
+// clientAddr returns the address of the client of r. Behind trusted proxies it is the last address of
+// X-Forwarded-For not added by one of them; a client can prepend any address, but not append one.
+func (rl *RateLimiter) clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !rl.trusted(addr) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !rl.trusted(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// trusted tells whether addr is a trusted proxy.
+func (rl *RateLimiter) trusted(addr netip.Addr) bool {
+	for _, prefix := range rl.TrustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitMiddleware takes a token from the bucket of the caller for every request, and answers 429 when the
+// bucket is empty. Requests are keyed by the subject RoleBasedMiddleware authenticated, so it must run after
+// it, and by address before login. Responses carry the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers of the limit applied. Probes are never limited, and requests are let through when the store fails.
+func RateLimitMiddleware(rl *RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isProbePath(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if scope, limit, ok := rl.limit(rateLimitGroup(r.URL.Path), RoleFromContext(r.Context())); ok && !rl.take(w, r, scope, limit, rl.client(r)) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// UnauthorizedRateLimitMiddleware limits the requests RoleBasedMiddleware rejects with 401, which never reach
+// RateLimitMiddleware, so it must run before it. They are keyed by address like requests before login, and a
+// caller sending invalid tokens is answered with 429 once its address used up its budget.
+func UnauthorizedRateLimitMiddleware(rl *RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&unauthorizedLimiter{ResponseWriter: w, rl: rl, r: r}, r)
+		})
+	}
+}
+
+// unauthorizedLimiter takes a token for a 401 response when its header is written, and writes a 429 in its
+// place when the bucket is empty.
+type unauthorizedLimiter struct {
+	http.ResponseWriter
+	rl          *RateLimiter
+	r           *http.Request
+	wroteHeader bool
+	limited     bool
+}
+
+func (u *unauthorizedLimiter) WriteHeader(status int) {
+	if u.wroteHeader {
+		return
+	}
+	u.wroteHeader = true
+	if status == http.StatusUnauthorized {
+		scope, limit, ok := u.rl.limit(rateLimitGroup(u.r.URL.Path), "")
+		if ok && !u.rl.take(u.ResponseWriter, u.r, scope, limit, "ip:"+u.rl.clientAddr(u.r)) {
+			u.limited = true
+			return
+		}
+	}
+	u.ResponseWriter.WriteHeader(status)
+}
+
+func (u *unauthorizedLimiter) Write(b []byte) (int, error) {
+	if !u.wroteHeader {
+		u.WriteHeader(http.StatusOK)
+	}
+	// The body of a 401 that was answered with 429 is dropped
+	if u.limited {
+		return len(b), nil
+	}
+	return u.ResponseWriter.Write(b)
+}
+
+func (u *unauthorizedLimiter) Flush() {
+	if !u.wroteHeader {
+		u.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := u.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (u *unauthorizedLimiter) Unwrap() http.ResponseWriter {
+	return u.ResponseWriter
+}
+
+// take takes a token for client from the bucket of scope and sets the RateLimit headers. When the bucket is
+// empty it answers 429 and reports false.
+func (rl *RateLimiter) take(w http.ResponseWriter, r *http.Request, scope string, limit RateLimit, client string) bool {
+	result, err := rl.Store.Take(r.Context(), scope+"|"+client, limit)
+	if err != nil {
+		requestLogger(r).Error("Rate limit error, letting the request through", "error", err)
+		return true
+	}
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Period)))
+	if !result.Allowed {
+		recordRateLimited(scope)
+		w.Header().Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+		writeProblem(w, r, http.StatusTooManyRequests, CodeRateLimited,
+			fmt.Sprintf("Rate limit of %d requests per %s exceeded", limit.Requests, limit.Period))
+		return false
+	}
+	return true
+}
+
+// ceilSeconds returns d in whole seconds, rounded up.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
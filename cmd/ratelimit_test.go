@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		rules    []string
+		expected map[string]RateLimit
+		error    string
+	}{
+		{"defaults", []string{"*=600/1m", "auth=20/1m"}, map[string]RateLimit{"*": {600, time.Minute}, "auth": {20, time.Minute}}, ""},
+		{"group and role", []string{" services:user = 60/30s "}, map[string]RateLimit{"services:user": {60, 30 * time.Second}}, ""},
+		{"every group and role", []string{"*:admin=1000/1h"}, map[string]RateLimit{"*:admin": {1000, time.Hour}}, ""},
+		{"not a rule", []string{"services"}, nil, "is not a group[:role]=requests/period rule"},
+		{"unknown group", []string{"servcies=10/1m"}, nil, `unknown group "servcies"`},
+		{"unknown role", []string{"services:owner=10/1m"}, nil, "role must be one of admin, user"},
+		{"zero requests", []string{"services=0/1m"}, nil, "requests must be a positive number"},
+		{"missing period", []string{"services=10"}, nil, "period must be a positive duration"},
+		{"invalid period", []string{"services=10/minute"}, nil, "period must be a positive duration"},
+		{"twice", []string{"auth=1/1m", "auth=2/1m"}, nil, `"auth" is limited twice`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits, err := parseRateLimits(tt.rules)
+			if tt.error != "" {
+				assert.ErrorContains(t, err, tt.error)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, limits)
+		})
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Requests: 2, Period: time.Second}
+	take := func(key string) RateLimitResult {
+		result, err := store.Take(context.Background(), key, limit)
+		assert.NoError(t, err)
+		return result
+	}
+
+	assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 1, Reset: 500 * time.Millisecond}, take("a"))
+	assert.Equal(t, RateLimitResult{Allowed: true, Remaining: 0, Reset: time.Second}, take("a"))
+	assert.Equal(t, RateLimitResult{Allowed: false, Remaining: 0, RetryAfter: 500 * time.Millisecond, Reset: time.Second}, take("a"))
+	assert.True(t, take("b").Allowed, "every key has a bucket of its own")
+
+	// Tokens are added at the rate of the limit
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, take("a").Allowed)
+	assert.False(t, take("a").Allowed)
+
+	// Buckets that filled up again are dropped
+	now = now.Add(rateLimitSweepInterval)
+	take("c")
+	assert.Len(t, store.buckets, 1)
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter := &RateLimiter{
+		Store: NewMemoryRateLimitStore(),
+		Limits: map[string]RateLimit{
+			"*":              {2, time.Minute},
+			"auth":           {1, time.Minute},
+			"services:admin": {3, time.Minute},
+		},
+	}
+	handler := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(path, role, subject, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = remoteAddr
+		ctx := context.WithValue(req.Context(), roleKey{}, role)
+		ctx = context.WithValue(ctx, subjectKey{}, subject)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req.WithContext(ctx))
+		return rr
+	}
+
+	// Every group without a rule of its own shares the bucket of *
+	assert.Equal(t, http.StatusOK, serve("/v1/users", "user", "user:alice", "10.0.0.1:1234").Code)
+	rr := serve("/v1/services", "user", "user:alice", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rr.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", rr.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", rr.Header().Get("RateLimit-Policy"))
+
+	rr = serve("/v1/webhooks", "user", "user:alice", "10.0.0.2:1234")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), CodeRateLimited)
+
+	// Other subjects, groups and roles with rules of their own have budgets of their own
+	assert.Equal(t, http.StatusOK, serve("/v1/services", "user", "user:bob", "10.0.0.1:1234").Code)
+	assert.Equal(t, http.StatusOK, serve("/v1/services", "user", "cert:alice", "10.0.0.1:1234").Code, "a certificate named like a user")
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, serve("/v1/services", "admin", "user:alice", "10.0.0.1:1234").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve("/v1/services", "admin", "user:alice", "10.0.0.1:1234").Code)
+
+	// Callers that are not authenticated are limited by address
+	assert.Equal(t, http.StatusOK, serve("/v1/auth", "", "", "10.0.0.1:1234").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("/v1/auth", "", "", "10.0.0.1:5678").Code)
+	assert.Equal(t, http.StatusOK, serve("/v1/auth", "", "", "10.0.0.2:1234").Code)
+
+	// Probes are never limited
+	for i := 0; i < 3; i++ {
+		rr = serve("/readyz", "", "", "10.0.0.1:1234")
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestUnauthorizedRateLimitMiddleware(t *testing.T) {
+	limiter := &RateLimiter{Store: NewMemoryRateLimitStore(), Limits: map[string]RateLimit{"*": {2, time.Minute}}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := UnauthorizedRateLimitMiddleware(limiter)(RoleBasedMiddleware(RateLimitMiddleware(limiter)(ok)))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/services", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer invalid")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Invalid tokens use up the budget of their address
+	for i := 0; i < 2; i++ {
+		rr := serve("10.0.0.1:1234")
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		assert.Equal(t, "2", rr.Header().Get("RateLimit-Limit"))
+	}
+	rr := serve("10.0.0.1:5678")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Contains(t, rr.Body.String(), CodeRateLimited)
+	assert.NotContains(t, rr.Body.String(), CodeInvalidToken)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusUnauthorized, serve("10.0.0.2:1234").Code)
+}
+
+func TestRateLimiterClientAddr(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	assert.NoError(t, err)
+	limiter := &RateLimiter{TrustedProxies: proxies}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		expected     string
+	}{
+		{"direct", "203.0.113.7:1234", "", "203.0.113.7"},
+		{"untrusted peer cannot forward", "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:1234", "198.51.100.1", "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:1234", "198.51.100.1, 192.168.1.1, 10.9.9.9", "198.51.100.1"},
+		{"spoofed first entry", "10.1.2.3:1234", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"trusted proxy without header", "192.168.1.1:1234", "", "192.168.1.1"},
+		{"invalid entry", "10.1.2.3:1234", "garbage, 198.51.100.1", "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/auth", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			assert.Equal(t, tt.expected, limiter.clientAddr(req))
+		})
+	}
+}
+
+func TestPostgresRateLimitStore(t *testing.T) {
+	store := NewPostgresRateLimitStore(GetDBInstance)
+	limit := RateLimit{Requests: 2, Period: time.Hour}
+	key := "test|subject:" + time.Now().String()
+
+	tests := []struct {
+		allowed   bool
+		remaining int
+	}{{true, 1}, {true, 0}, {false, 0}}
+
+	for i, tt := range tests {
+		result, err := store.Take(context.Background(), key, limit)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.allowed, result.Allowed, "request %d", i+1)
+		assert.Equal(t, tt.remaining, result.Remaining, "request %d", i+1)
+	}
+	result, err := store.Take(context.Background(), "test|another", limit)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed, "every key has a bucket of its own")
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// gormModelFields maps the snake_case names accepted by ?fields= onto the JSON keys emitted for gorm.Model.
+var gormModelFields = map[string]string{
+	"id":         "ID",
+	"created_at": "CreatedAt",
+	"updated_at": "UpdatedAt",
+	"deleted_at": "DeletedAt",
+}
+
+// ResourceSpec describes how a resource can be trimmed and expanded in a response.
+type ResourceSpec struct {
+	// Fields lists the JSON fields that may be requested with ?fields=, besides the gorm.Model ones.
+	Fields []string
+	// Expand maps the names accepted by ?expand= onto their GORM association and JSON key.
+	Expand map[string]Expansion
+}
+
+// Expansion is a relationship that is only loaded when requested with ?expand=.
+// Computed data that is not a GORM association sets Load instead, which fills it in after the fetch.
+type Expansion struct {
+	Association string
+	JSONKey     string
+	Load        func(data interface{}) error
+}
+
+// ResponseOptions is the parsed form of the ?fields= and ?expand= query parameters.
+type ResponseOptions struct {
+	spec   ResourceSpec
+	fields []string
+	expand []string
+}
+
+// ParseResponseOptions parses ?fields= and ?expand= against the resource spec and rejects unknown names.
+func ParseResponseOptions(r *http.Request, spec ResourceSpec) (ResponseOptions, error) {
+	opts := ResponseOptions{spec: spec}
+	values := r.URL.Query()
+
+	allowed := make(map[string]string)
+	for name, key := range gormModelFields {
+		allowed[name] = key
+	}
+	for _, name := range spec.Fields {
+		allowed[name] = name
+	}
+
+	for _, name := range splitList(values.Get("fields")) {
+		key, ok := allowed[name]
+		if !ok {
+			return opts, &QueryError{Param: "fields", Message: fmt.Sprintf("unknown field %q, allowed: %s", name, strings.Join(sortedKeys(allowed), ", "))}
+		}
+		opts.fields = append(opts.fields, key)
+	}
+
+	for _, name := range splitList(values.Get("expand")) {
+		if _, ok := spec.Expand[name]; !ok {
+			return opts, &QueryError{Param: "expand", Message: fmt.Sprintf("unknown relationship %q, allowed: %s", name, strings.Join(sortedKeys(spec.Expand), ", "))}
+		}
+		opts.expand = append(opts.expand, name)
+	}
+
+	return opts, nil
+}
+
+// WithExpand adds an expansion as if it had been requested with ?expand=, e.g. for legacy flags.
+func (o ResponseOptions) WithExpand(name string) ResponseOptions {
+	if _, ok := o.spec.Expand[name]; !ok {
+		return o
+	}
+	for _, existing := range o.expand {
+		if existing == name {
+			return o
+		}
+	}
+	o.expand = append(append([]string(nil), o.expand...), name)
+	return o
+}
+
+// Preload adds the requested expansions to the query.
+func (o ResponseOptions) Preload(db *gorm.DB) *gorm.DB {
+	for _, name := range o.expand {
+		if association := o.spec.Expand[name].Association; association != "" {
+			db = db.Preload(association)
+		}
+	}
+	return db
+}
+
+// load runs the Load function of every requested expansion on the fetched data.
+func (o ResponseOptions) load(data interface{}) error {
+	for _, name := range o.expand {
+		if load := o.spec.Expand[name].Load; load != nil {
+			if err := load(data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fullRepresentation reports whether the response is the resource as stored, which its revision tags. Expanded
+// relationships, e.g. versions, change without bumping the revision of the resource, and projections are
+// different representations, so neither may share its tag.
+func (o ResponseOptions) fullRepresentation() bool {
+	return len(o.expand) == 0 && len(o.fields) == 0
+}
+
+// respond is the shared response layer for read endpoints.
+//
+// It calls the provided fetch function and maps gorm.ErrRecordNotFound to 404 and any other error to 500.
+// On success it encodes data as JSON, trimmed to the requested ?fields= plus any expanded relationships,
+// and sets an ETag. A matching If-None-Match header is answered with 304 and no body.
+func respond(w http.ResponseWriter, r *http.Request, opts ResponseOptions, fetchFunc func() error, data interface{}) {
+	err := fetchFunc()
+	if err == nil {
+		err = opts.load(data)
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
+		return
+	}
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Internal server error")
+		requestLogger(r).Error("Database error", "error", err)
+		return
+	}
+
+	body, err := opts.project(data)
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+		requestLogger(r).Error("Encoding error", "error", err)
+		return
+	}
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to encode response")
+		requestLogger(r).Error("Encoding error", "error", err)
+		return
+	}
+
+	// Single resources are tagged with their revision, lists, expansions and projections with a hash of the body
+	etag := bodyETag(encoded)
+	if resource, ok := data.(revisioned); ok && opts.fullRepresentation() {
+		etag = resource.ETag()
+	}
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagListMatches(ifNoneMatch, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	setJSONHeader(w)
+	w.Write(append(encoded, '\n'))
+}
+
+// project trims data to the requested fields. Without ?fields= the data is returned untouched.
+func (o ResponseOptions) project(data interface{}) (interface{}, error) {
+	if len(o.fields) == 0 {
+		return data, nil
+	}
+
+	keep := make(map[string]bool)
+	for _, key := range o.fields {
+		keep[key] = true
+	}
+	for _, name := range o.expand {
+		keep[o.spec.Expand[name].JSONKey] = true
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &list); err == nil {
+		for _, item := range list {
+			trimObject(item, keep)
+		}
+		return list, nil
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, err
+	}
+	trimObject(object, keep)
+	return object, nil
+}
+
+func trimObject(object map[string]json.RawMessage, keep map[string]bool) {
+	for key := range object {
+		if !keep[key] {
+			delete(object, key)
+		}
+	}
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var serviceResourceSpec = ResourceSpec{
+	Fields: []string{"service_name", "service_description"},
+	Expand: map[string]Expansion{
+		"versions": {Association: "Versions", JSONKey: "service_versions"},
+		"health":   {JSONKey: "health", Load: loadHealth},
+	},
+}
+
+var serviceVersionResourceSpec = ResourceSpec{
+	Fields: []string{"service_id", "service_version_name", "service_version_url", "service_version_description", "metadata", "health_path"},
+	Expand: map[string]Expansion{
+		"health": {JSONKey: "health", Load: loadHealth},
+	},
+}
+
+var userResourceSpec = ResourceSpec{
+	Fields: []string{"username", "role"},
+	Expand: map[string]Expansion{
+		"user_profile": {Association: "UserProfile", JSONKey: "user_profile"},
+	},
+}
+
+var webhookResourceSpec = ResourceSpec{
+	Fields: []string{"url", "events", "description", "disabled"},
+}
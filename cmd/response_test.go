@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseResponseOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{"NoOptions", "/services", false},
+		{"KnownFields", "/services?fields=id,service_name", false},
+		{"KnownExpansion", "/services?expand=versions", false},
+		{"UnknownField", "/services?fields=id,owner", true},
+		{"UnknownExpansion", "/services?expand=owner", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.url, nil)
+			assert.NoError(t, err)
+
+			_, err = ParseResponseOptions(req, serviceResourceSpec)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestRespondProjectsFields(t *testing.T) {
+	req, err := http.NewRequest("GET", "/services?fields=id,service_name&expand=versions", nil)
+	assert.NoError(t, err)
+	opts, err := ParseResponseOptions(req, serviceResourceSpec)
+	assert.NoError(t, err)
+
+	services := []Service{{ServiceName: "Service 1", ServiceDescription: "Dropped", Versions: []ServiceVersion{{ServiceVersionName: "v1"}}}}
+	rr := httptest.NewRecorder()
+	respond(rr, req, opts, func() error { return nil }, &services)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var body []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Len(t, body, 1)
+	assert.ElementsMatch(t, []string{"ID", "service_name", "service_versions"}, sortedKeys(body[0]))
+}
+
+func TestRespondETag(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		revisionTag bool
+	}{
+		{"FullRepresentation", "/services", true},
+		{"Expansion", "/services?expand=versions", false},
+		{"Projection", "/services?fields=id,service_name", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.url, nil)
+			assert.NoError(t, err)
+			req.Header.Set("If-None-Match", `"3"`)
+			opts, err := ParseResponseOptions(req, serviceResourceSpec)
+			assert.NoError(t, err)
+
+			service := Service{ServiceName: "Service 1", RevisionCounter: RevisionCounter{Revision: 3}}
+			rr := httptest.NewRecorder()
+			respond(rr, req, opts, func() error { return nil }, &service)
+
+			if tt.revisionTag {
+				assert.Equal(t, http.StatusNotModified, rr.Code)
+				assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+				return
+			}
+			// A version change does not bump the service revision, so its tag must not match
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.True(t, strings.HasPrefix(rr.Header().Get("ETag"), `W/"`))
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults of the HTTP server settings.
+const (
+	DefaultAddr              = ":8080"
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout       = 30 * time.Second
+	DefaultWriteTimeout      = 60 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultMaxHeaderBytes    = 1 << 20
+	DefaultMaxBodyBytes      = 10 << 20
+	DefaultShutdownDelay     = 5 * time.Second
+	DefaultShutdownTimeout   = 20 * time.Second
+)
+
+// CodeRequestTooLarge is returned when a request body exceeds the configured maximum.
+const CodeRequestTooLarge = "request_too_large"
+
+// ServerConfig holds the limits of the HTTP server and how it shuts down.
+type ServerConfig struct {
+	Addr              string        `yaml:"addr" env:"SERVICE_DASHBOARD_ADDR" usage:"address the API listens on"`
+	MetricsAddr       string        `yaml:"metrics_addr" env:"SERVICE_DASHBOARD_METRICS_ADDR" usage:"separate address serving /metrics without a token"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVICE_DASHBOARD_READ_HEADER_TIMEOUT" usage:"time a client may take to send the headers"`
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"SERVICE_DASHBOARD_READ_TIMEOUT" usage:"time a client may take to send the request"`
+	WriteTimeout      time.Duration `yaml:"write_timeout" env:"SERVICE_DASHBOARD_WRITE_TIMEOUT" usage:"time a response may take"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"SERVICE_DASHBOARD_IDLE_TIMEOUT" usage:"time an idle connection stays open"`
+	MaxHeaderBytes    int           `yaml:"max_header_bytes" env:"SERVICE_DASHBOARD_MAX_HEADER_BYTES" usage:"maximum size of the request headers"`
+	MaxBodyBytes      int           `yaml:"max_body_bytes" env:"SERVICE_DASHBOARD_MAX_BODY_BYTES" usage:"maximum size of a request body"`
+	// ShutdownDelay is how long the server keeps serving after a signal while /readyz reports it stopping,
+	// so load balancers stop sending it traffic before it stops accepting connections.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" env:"SERVICE_DASHBOARD_SHUTDOWN_DELAY" usage:"time serving on after a signal while not ready, 0 to skip"`
+	// ShutdownTimeout bounds draining requests, stopping the workers and closing the database pool.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SERVICE_DASHBOARD_SHUTDOWN_TIMEOUT" usage:"time allowed to drain and stop"`
+}
+
+// NewServer returns an HTTP server serving handler with the limits of config. Request bodies larger than
+// MaxBodyBytes are cut off and answered with 413.
+func (config ServerConfig) NewServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              config.Addr,
+		Handler:           BodyLimitMiddleware(int64(config.MaxBodyBytes))(handler),
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+}
+
+// BodyLimitMiddleware makes reading more than limit bytes of a request body fail, see writeReadProblem.
+func BodyLimitMiddleware(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeReadProblem answers a request whose body could not be read: with 413 when it is too large, and
+// otherwise with 400 and the given code and detail.
+func writeReadProblem(w http.ResponseWriter, r *http.Request, err error, code, detail string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, CodeRequestTooLarge, fmt.Sprintf("The request body exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	writeProblem(w, r, http.StatusBadRequest, code, detail)
+}
+
+// disableWriteTimeout lifts the write timeout of the server for a long-lived response such as an event stream.
+func disableWriteTimeout(w http.ResponseWriter) {
+	// Fails only when the response writer cannot unwrap to the server's, e.g. in tests
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+}
+
+// Workers runs the background workers and stops them on shutdown.
+type Workers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewWorkers returns an empty set of workers.
+func NewWorkers() *Workers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Workers{ctx: ctx, cancel: cancel}
+}
+
+// Go runs a worker until Stop is called.
+func (ws *Workers) Go(run func(context.Context)) {
+	ws.wg.Add(1)
+	go func() {
+		defer ws.wg.Done()
+		run(ws.ctx)
+	}()
+}
+
+// Stop cancels every worker and waits for them to return, or for ctx to be done. A worker interrupted
+// mid-run leaves its work in the database, e.g. undelivered outbox entries, for the next start.
+func (ws *Workers) Stop(ctx context.Context) error {
+	ws.cancel()
+	done := make(chan struct{})
+	go func() {
+		ws.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background workers did not stop: %w", ctx.Err())
+	}
+}
+
+// shutdown drains the servers, then stops the workers and closes the database pool, all within
+// config.ShutdownTimeout, and flushes the traces last so the shutdown itself is exported. It returns the
+// errors of every step; a failed step does not skip the next ones.
+func shutdown(config ServerConfig, servers []*http.Server, workers *Workers, shutdownTracing func(context.Context) error) error {
+	lifecycle.SetPhase(PhaseStopping)
+	slog.Info("Shutting down, no longer ready", "delay", config.ShutdownDelay.String())
+	time.Sleep(config.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("draining requests: %w", err))
+		}
+	}
+	slog.Info("Requests drained")
+	if err := workers.Stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if sqlDB, err := GetDBInstance().DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing the database pool: %w", err))
+		}
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("flushing traces: %w", err))
+	}
+	return errors.Join(errs...)
+}
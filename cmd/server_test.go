@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	handler := BodyLimitMiddleware(16)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if decodeJSONBody(w, r, &body) {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"within the limit", `{"a": 1}`, http.StatusNoContent, ""},
+		{"too large", `{"service_name": "billing"}`, http.StatusRequestEntityTooLarge, CodeRequestTooLarge},
+		{"malformed", `{"a"`, http.StatusBadRequest, CodeInvalidJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", "/v1/services", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.status, rr.Code)
+			if tt.code != "" {
+				assert.Contains(t, rr.Body.String(), `"code":"`+tt.code+`"`)
+			}
+		})
+	}
+}
+
+func TestDisableWriteTimeout(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/events", func(w http.ResponseWriter, r *http.Request) {
+		disableWriteTimeout(w)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("still streaming"))
+	})
+	router.HandleFunc("/v1/services", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("too late"))
+	})
+
+	// The deadline is reached through the response writers wrapped by the middlewares
+	server := httptest.NewUnstartedServer(TracingMiddleware(router)(LoggerMiddleware(router)(MetricsMiddleware(router)(router))))
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/events")
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "still streaming", string(body))
+	}
+
+	// Other responses are still cut off
+	_, err = http.Get(server.URL + "/v1/services")
+	assert.Error(t, err)
+}
+
+func TestWorkersStop(t *testing.T) {
+	workers := NewWorkers()
+	stopped := make(chan struct{})
+	workers.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+	assert.NoError(t, workers.Stop(context.Background()))
+	<-stopped
+
+	// A worker that ignores cancellation fails the deadline
+	workers = NewWorkers()
+	release := make(chan struct{})
+	defer close(release)
+	workers.Go(func(context.Context) { <-release })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, workers.Stop(ctx), context.DeadlineExceeded)
+}
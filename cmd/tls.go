@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTLSReloadInterval is how often the certificate files are checked for changes.
+const DefaultTLSReloadInterval = 10 * time.Second
+
+// Client certificate requirements of mutual TLS.
+const (
+	// TLSClientAuthOptional verifies client certificates when they are sent; callers without one use a token.
+	TLSClientAuthOptional = "optional"
+	// TLSClientAuthRequired refuses the TLS handshake of clients without a valid certificate.
+	TLSClientAuthRequired = "required"
+)
+
+// TLSConfig configures HTTPS and the client certificates accepted in place of a token.
+type TLSConfig struct {
+	CertFile       string        `yaml:"cert_file" env:"SERVICE_DASHBOARD_TLS_CERT_FILE" usage:"PEM certificate chain to serve HTTPS with"`
+	KeyFile        string        `yaml:"key_file" env:"SERVICE_DASHBOARD_TLS_KEY_FILE" usage:"PEM private key of the certificate"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env:"SERVICE_DASHBOARD_TLS_RELOAD_INTERVAL" usage:"time between checks of the files for changes"`
+	// ClientCAFile enables mutual TLS: client certificates signed by these CAs authenticate their callers.
+	ClientCAFile string `yaml:"client_ca_file" env:"SERVICE_DASHBOARD_TLS_CLIENT_CA_FILE" usage:"PEM CAs of the client certificates, enables mutual TLS"`
+	ClientAuth   string `yaml:"client_auth" env:"SERVICE_DASHBOARD_TLS_CLIENT_AUTH" usage:"optional or required client certificates"`
+	// ClientRoles maps the common names of client certificates to roles, e.g. billing-sync=admin.
+	ClientRoles []string `yaml:"client_roles" env:"SERVICE_DASHBOARD_TLS_CLIENT_ROLES" usage:"comma-separated common name=role pairs"`
+}
+
+// Enabled tells whether the API is served over HTTPS.
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// validate reports every invalid TLS setting.
+func (c TLSConfig) validate() []error {
+	var errs []error
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file must be set together"))
+	}
+	if c.ClientCAFile != "" && c.CertFile == "" {
+		errs = append(errs, errors.New("tls.client_ca_file requires tls.cert_file"))
+	}
+	if c.ClientAuth != TLSClientAuthOptional && c.ClientAuth != TLSClientAuthRequired {
+		errs = append(errs, fmt.Errorf("tls.client_auth must be %s or %s, got %q", TLSClientAuthOptional, TLSClientAuthRequired, c.ClientAuth))
+	}
+	if len(c.ClientRoles) > 0 && c.ClientCAFile == "" {
+		errs = append(errs, errors.New("tls.client_roles requires tls.client_ca_file"))
+	}
+	if _, err := parseClientRoles(c.ClientRoles); err != nil {
+		errs = append(errs, fmt.Errorf("tls.client_roles: %w", err))
+	}
+	return errs
+}
+
+// parseClientRoles parses common name=role pairs into a map from common name to role.
+func parseClientRoles(pairs []string) (map[string]string, error) {
+	roles := map[string]string{}
+	for _, pair := range pairs {
+		name, role, ok := strings.Cut(pair, "=")
+		name, role = strings.TrimSpace(name), strings.TrimSpace(role)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%q is not a common name=role pair", pair)
+		}
+		if !slices.Contains(AllowedRoles, role) {
+			return nil, fmt.Errorf("%q: role must be one of %s", pair, strings.Join(AllowedRoles, ", "))
+		}
+		if _, ok := roles[name]; ok {
+			return nil, fmt.Errorf("%q is mapped twice", name)
+		}
+		roles[name] = role
+	}
+	return roles, nil
+}
+
+// ClientCertificateRoles maps the common names of verified client certificates to their roles. It is set from
+// TLSConfig at startup.
+var ClientCertificateRoles = map[string]string{}
+
+// clientCertificateName returns the common name of the verified client certificate of r, if there is one.
+// Certificates that were sent but not verified against the client CAs never count.
+func clientCertificateName(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// certificateFiles records the files a certificateReloader loaded, to notice when they change.
+type certificateFiles map[string]time.Time
+
+// certificateReloader serves the certificate, key and client CAs of a TLSConfig, reloading them when the files
+// change, e.g. when cert-manager renews a certificate. A failed reload keeps the files loaded last.
+type certificateReloader struct {
+	config TLSConfig
+
+	mu        sync.Mutex
+	current   *tls.Config
+	files     certificateFiles
+	checkedAt time.Time
+}
+
+// newCertificateReloader loads the files of config, failing when they are missing or invalid.
+func newCertificateReloader(config TLSConfig) (*certificateReloader, error) {
+	reloader := &certificateReloader{config: config}
+	current, files, err := reloader.load()
+	if err != nil {
+		return nil, err
+	}
+	reloader.current, reloader.files, reloader.checkedAt = current, files, time.Now()
+	return reloader, nil
+}
+
+// paths returns the files to load.
+func (cr *certificateReloader) paths() []string {
+	paths := []string{cr.config.CertFile, cr.config.KeyFile}
+	if cr.config.ClientCAFile != "" {
+		paths = append(paths, cr.config.ClientCAFile)
+	}
+	return paths
+}
+
+// modTimes returns the modification times of the files. os.Stat follows symbolic links, so the swapped links of
+// a mounted Kubernetes secret are noticed too.
+func (cr *certificateReloader) modTimes() (certificateFiles, error) {
+	files := certificateFiles{}
+	for _, path := range cr.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files[path] = info.ModTime()
+	}
+	return files, nil
+}
+
+// load reads the files into the TLS configuration of a connection.
+func (cr *certificateReloader) load() (*tls.Config, certificateFiles, error) {
+	// Stat first, so a file replaced while loading is loaded again on the next check
+	files, err := cr.modTimes()
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, err := tls.LoadX509KeyPair(cr.config.CertFile, cr.config.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading the certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if cr.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(cr.config.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading the client CAs: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("loading the client CAs: no certificate found in %s", cr.config.ClientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if cr.config.ClientAuth == TLSClientAuthRequired {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return config, files, nil
+}
+
+// reload loads the files again when one of them changed since they were loaded, at most once per
+// ReloadInterval.
+func (cr *certificateReloader) reload(now time.Time) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if now.Sub(cr.checkedAt) < cr.config.ReloadInterval {
+		return
+	}
+	cr.checkedAt = now
+
+	files, err := cr.modTimes()
+	if err == nil && maps.EqualFunc(files, cr.files, time.Time.Equal) {
+		return
+	}
+	current, files, err := cr.load()
+	if err != nil {
+		slog.Error("Error reloading the TLS files, serving the ones loaded last", "error", err)
+		return
+	}
+	cr.current, cr.files = current, files
+	slog.Info("Reloaded the TLS files", "cert_file", cr.config.CertFile)
+}
+
+// GetConfigForClient returns the TLS configuration of a new connection, see tls.Config.
+func (cr *certificateReloader) GetConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	cr.reload(time.Now())
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.current, nil
+}
+
+// TLSConfig returns the configuration of a server whose connections use the files loaded last.
+func (cr *certificateReloader) TLSConfig() *tls.Config {
+	return &tls.Config{MinVersion: tls.VersionTLS12, GetConfigForClient: cr.GetConfigForClient}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCertificate is a certificate and its key, signed by parent or self-signed.
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCertificate(t *testing.T, name string, parent *testCertificate, isCA bool) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCertificate{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and its key as PEM files.
+func (c *testCertificate) write(t *testing.T, certFile, keyFile string) {
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600))
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(c.key)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600))
+	}
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	config := TLSConfig{
+		CertFile:       filepath.Join(dir, "tls.crt"),
+		KeyFile:        filepath.Join(dir, "tls.key"),
+		ReloadInterval: time.Minute,
+		ClientAuth:     TLSClientAuthOptional,
+	}
+	first := newTestCertificate(t, "first", nil, false)
+	first.write(t, config.CertFile, config.KeyFile)
+
+	reloader, err := newCertificateReloader(config)
+	assert.NoError(t, err)
+	served := func(at time.Duration) string {
+		reloader.reload(time.Now().Add(at))
+		current, err := reloader.GetConfigForClient(nil)
+		assert.NoError(t, err)
+		return current.Certificates[0].Leaf.Subject.CommonName
+	}
+
+	// Renewed files are served once the reload interval passed
+	second := newTestCertificate(t, "second", nil, false)
+	second.write(t, config.CertFile, config.KeyFile)
+	renewedAt := time.Now().Add(time.Hour)
+	for _, path := range []string{config.CertFile, config.KeyFile} {
+		assert.NoError(t, os.Chtimes(path, renewedAt, renewedAt))
+	}
+	assert.Equal(t, "first", served(0))
+	assert.Equal(t, "second", served(2*time.Minute))
+
+	// Invalid files keep the ones loaded last
+	assert.NoError(t, os.WriteFile(config.CertFile, []byte("not a certificate"), 0o600))
+	brokenAt := renewedAt.Add(time.Hour)
+	assert.NoError(t, os.Chtimes(config.CertFile, brokenAt, brokenAt))
+	assert.Equal(t, "second", served(4*time.Minute))
+
+	_, err = newCertificateReloader(TLSConfig{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: config.KeyFile})
+	assert.Error(t, err)
+}
+
+func TestClientCertificateAuthentication(t *testing.T) {
+	defer func(previous map[string]string) { ClientCertificateRoles = previous }(ClientCertificateRoles)
+	ClientCertificateRoles = map[string]string{"billing-sync": "admin", "status-page": "user"}
+
+	dir := t.TempDir()
+	config := TLSConfig{
+		CertFile:       filepath.Join(dir, "tls.crt"),
+		KeyFile:        filepath.Join(dir, "tls.key"),
+		ClientCAFile:   filepath.Join(dir, "ca.crt"),
+		ReloadInterval: time.Minute,
+		ClientAuth:     TLSClientAuthOptional,
+	}
+	ca := newTestCertificate(t, "dashboard-ca", nil, true)
+	ca.write(t, config.ClientCAFile, "")
+	newTestCertificate(t, "dashboard", ca, false).write(t, config.CertFile, config.KeyFile)
+	reloader, err := newCertificateReloader(config)
+	assert.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(RoleBasedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(RoleFromContext(r.Context()) + " " + SubjectFromContext(r.Context())))
+	})))
+	server.TLS = reloader.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	untrusted := newTestCertificate(t, "billing-sync", nil, false)
+
+	tests := []struct {
+		name        string
+		certificate *testCertificate
+		method      string
+		token       string
+		status      int
+		body        string
+	}{
+		{"mapped to admin", newTestCertificate(t, "billing-sync", ca, false), "POST", "", http.StatusOK, "admin cert:billing-sync"},
+		{"mapped to user", newTestCertificate(t, "status-page", ca, false), "GET", "", http.StatusOK, "user"},
+		{"role not allowed", newTestCertificate(t, "status-page", ca, false), "POST", "", http.StatusForbidden, CodeForbidden},
+		{"not mapped", newTestCertificate(t, "unknown", ca, false), "GET", "", http.StatusUnauthorized, "Client certificate unknown is not mapped to a role"},
+		{"token takes precedence", newTestCertificate(t, "billing-sync", ca, false), "GET", "Bearer invalid", http.StatusUnauthorized, CodeInvalidToken},
+		{"no certificate", nil, "GET", "", http.StatusUnauthorized, "Authorization token not provided"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig := &tls.Config{RootCAs: roots}
+			if tt.certificate != nil {
+				clientConfig.Certificates = []tls.Certificate{tt.certificate.tlsCertificate()}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+			req, err := http.NewRequest(tt.method, server.URL+"/v1/services", nil)
+			assert.NoError(t, err)
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			resp, err := client.Do(req)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Contains(t, string(body), tt.body)
+		})
+	}
+
+	// A certificate from another CA fails the handshake rather than falling back to no certificate
+	sendUntrusted := func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		certificate := untrusted.tlsCertificate()
+		return &certificate, nil
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, GetClientCertificate: sendUntrusted}}}
+	_, err = client.Get(server.URL + "/v1/services")
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// Trace exporters selected with SERVICE_DASHBOARD_TRACES_EXPORTER.
+const (
+	TracesExporterNone   = "none"
+	TracesExporterOTLP   = "otlp"
+	TracesExporterStdout = "stdout"
+)
+
+// DefaultServiceName names the service in traces unless OTEL_SERVICE_NAME is set.
+const DefaultServiceName = "kong-service-dashboard"
+
+// tracerName identifies the spans created by the dashboard itself.
+const tracerName = "github.com/harrydaihaolin/kong-service-dashboard"
+
+// setupTracing installs the W3C trace context propagator and, unless the exporter is none, a tracer provider
+// exporting spans. The returned function flushes and stops the exporter.
+//
+// The OTLP exporter sends over HTTP and is configured by the standard OTEL_EXPORTER_OTLP_* variables,
+// and sampling by OTEL_TRACES_SAMPLER.
+func setupTracing(ctx context.Context, exporterName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case TracesExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case TracesExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case TracesExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown traces exporter %q, allowed: %s, %s, %s", exporterName, TracesExporterNone, TracesExporterOTLP, TracesExporterStdout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", DefaultServiceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// TracingMiddleware starts a server span for every request, continuing the trace of an incoming traceparent
+// header. Spans are named after the route template matched on router, e.g. "GET /v1/services".
+func TracingMiddleware(router *mux.Router) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.route", routeTemplate(router, r)))
+			next.ServeHTTP(w, r)
+		})
+		return otelhttp.NewHandler(routed, "http.request",
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return r.Method + " " + routeTemplate(router, r)
+			}),
+		)
+	}
+}
+
+// tracedTransport propagates the trace context to outgoing requests and records a client span for each.
+func tracedTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return otelhttp.NewTransport(base)
+}
+
+// gormSpanKey stores the span of a statement between the before and after callbacks.
+const gormSpanKey = "service_dashboard:span"
+
+// registerTracingCallbacks adds a client span around every GORM operation of db. Only statements run with a
+// context that is already traced get a span, so the background workers polling the database stay quiet;
+// pass the request context with db.WithContext to trace the queries of a request.
+func registerTracingCallbacks(db *gorm.DB) error {
+	tracer := otel.Tracer(tracerName)
+
+	before := func(operation string) func(*gorm.DB) {
+		return func(tx *gorm.DB) {
+			ctx := tx.Statement.Context
+			if ctx == nil || !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+				return
+			}
+			ctx, span := tracer.Start(ctx, "gorm."+operation, trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attribute.String("db.system", "postgresql"), attribute.String("db.operation", operation)))
+			tx.Statement.Context = ctx
+			tx.InstanceSet(gormSpanKey, span)
+		}
+	}
+	after := func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(gormSpanKey)
+		if !ok {
+			return
+		}
+		span := value.(trace.Span)
+		defer span.End()
+
+		// Only the statement is recorded, never the bound values, which may hold passwords and secrets
+		span.SetAttributes(
+			attribute.String("db.statement", tx.Statement.SQL.String()),
+			attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+		)
+		if tx.Statement.Table != "" {
+			span.SetAttributes(attribute.String("db.sql.table", tx.Statement.Table))
+		}
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			span.RecordError(tx.Error)
+			span.SetStatus(codes.Error, tx.Error.Error())
+		}
+	}
+
+	callbacks := db.Callback()
+	processors := []struct {
+		operation string
+		before    func(string, func(*gorm.DB)) error
+		after     func(string, func(*gorm.DB)) error
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	}
+	for _, processor := range processors {
+		name := "service_dashboard:trace_" + processor.operation
+		if err := processor.before(name+"_before", before(processor.operation)); err != nil {
+			return err
+		}
+		if err := processor.after(name+"_after", after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
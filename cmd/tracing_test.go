@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	testSpansOnce sync.Once
+	testSpans     *tracetest.SpanRecorder
+)
+
+// recordSpans installs a tracer provider recording every span. The global provider can only be replaced
+// before the first tracer is used, so every test shares one recorder.
+func recordSpans() *tracetest.SpanRecorder {
+	testSpansOnce.Do(func() {
+		testSpans = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(testSpans)))
+		setupTracing(context.Background(), TracesExporterNone)
+	})
+	return testSpans
+}
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestSetupTracing(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), TracesExporterNone)
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = setupTracing(context.Background(), "jaeger")
+	assert.ErrorContains(t, err, "unknown traces exporter")
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	spans := recordSpans()
+
+	router := mux.NewRouter()
+	var handlerSpan trace.SpanContext
+	router.HandleFunc("/v1/services/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+	}).Methods("GET")
+	handler := TracingMiddleware(router)(router)
+
+	req := httptest.NewRequest("GET", "/v1/services/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	span := findSpan(spans.Ended(), "GET /v1/services/{id}")
+	if assert.NotNil(t, span) {
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+		assert.Contains(t, span.Attributes(), attribute.String("http.route", "/v1/services/{id}"))
+		assert.Equal(t, span.SpanContext().SpanID(), handlerSpan.SpanID())
+	}
+}
+
+func TestTracedTransport(t *testing.T) {
+	recordSpans()
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, span := otel.Tracer(tracerName).Start(context.Background(), "parent")
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	resp, err := (&http.Client{Transport: tracedTransport(nil)}).Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	span.End()
+
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+}
+
+func TestGormTracing(t *testing.T) {
+	spans := recordSpans()
+
+	ctx, parent := otel.Tracer(tracerName).Start(context.Background(), "request")
+	var services []Service
+	assert.NoError(t, GetDBInstance().WithContext(ctx).Preload("Versions").Find(&services).Error)
+	parent.End()
+
+	// The services query and the versions preload both belong to the request trace
+	var tables []attribute.KeyValue
+	for _, span := range spans.Ended() {
+		if span.Name() == "gorm.query" && span.SpanContext().TraceID() == parent.SpanContext().TraceID() {
+			tables = append(tables, span.Attributes()...)
+		}
+	}
+	assert.Contains(t, tables, attribute.String("db.sql.table", "services"))
+	assert.Contains(t, tables, attribute.String("db.sql.table", "service_versions"))
+
+	// Queries without a traced context get no span
+	before := len(spans.Ended())
+	assert.NoError(t, GetDBInstance().Find(&services).Error)
+	assert.Len(t, spans.Ended(), before)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// CodeUnknownField is reported for JSON fields that do not exist on the target payload.
+const CodeUnknownField = "unknown_field"
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// Report fields by their JSON name so clients can map errors back to their payload
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+
+	// role accepts only the roles listed in AllowedRoles
+	v.RegisterValidation("role", func(fl validator.FieldLevel) bool {
+		for _, role := range AllowedRoles {
+			if fl.Field().String() == role {
+				return true
+			}
+		}
+		return false
+	})
+
+	// service_url accepts absolute URLs using one of the protocols Kong can proxy to
+	v.RegisterValidation("service_url", func(fl validator.FieldLevel) bool {
+		u, err := url.Parse(fl.Field().String())
+		if err != nil || u.Host == "" {
+			return false
+		}
+		for _, protocol := range KongProtocols {
+			if u.Scheme == protocol {
+				return true
+			}
+		}
+		return false
+	})
+
+	// webhook_url accepts absolute http and https URLs
+	v.RegisterValidation("webhook_url", func(fl validator.FieldLevel) bool {
+		u, err := url.Parse(fl.Field().String())
+		return err == nil && u.Host != "" && (u.Scheme == "http" || u.Scheme == "https")
+	})
+
+	// webhook_event accepts the known event types and the patterns matching them
+	v.RegisterValidation("webhook_event", func(fl validator.FieldLevel) bool {
+		for _, eventType := range WebhookEventTypes {
+			if eventMatches(fl.Field().String(), eventType) {
+				return true
+			}
+		}
+		return false
+	})
+
+	return v
+}
+
+// decodeJSONBody decodes the request body into v and reports whether it succeeded.
+//
+// Malformed JSON is answered with 400. Unknown fields, including nested ones, and values of the wrong type
+// are all reported together in a single 422 response.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeReadProblem(w, r, err, CodeInvalidJSON, "Invalid JSON payload")
+		return false
+	}
+	if rej := decodeJSON(body, v); rej != nil {
+		writeRejection(w, r, rej)
+		return false
+	}
+	return true
+}
+
+// decodeJSON is the body-agnostic part of decodeJSONBody, also used for the items of bulk requests.
+func decodeJSON(body []byte, v interface{}) *rejection {
+	if !json.Valid(body) {
+		return &rejection{status: http.StatusBadRequest, code: CodeInvalidJSON, detail: "Invalid JSON payload"}
+	}
+
+	fieldErrors := unknownFields(body, reflect.TypeOf(v), "")
+
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return &rejection{status: http.StatusBadRequest, code: CodeInvalidJSON, detail: "Invalid JSON payload"}
+		}
+		fieldErrors = append(fieldErrors, FieldError{
+			Field:   typeErr.Field,
+			Code:    "type",
+			Message: fmt.Sprintf("must be of type %s", typeErr.Type.Kind()),
+		})
+	}
+
+	if len(fieldErrors) > 0 {
+		return &rejection{status: http.StatusUnprocessableEntity, code: CodeValidationFailed, detail: "Payload contains invalid fields", fieldErrors: fieldErrors}
+	}
+	return nil
+}
+
+// validatePayload validates v against its `validate` struct tags and reports whether it is valid.
+// Every invalid field is reported in a single 422 response.
+func validatePayload(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	fieldErrors := validationErrors(v)
+	if len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return false
+	}
+	return true
+}
+
+// writeValidationProblem reports field errors collected by a handler as a single 422 response.
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, fieldErrors []FieldError) {
+	writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "Payload validation failed", fieldErrors...)
+}
+
+// validationErrors converts the validator's errors into field errors keyed by JSON path.
+func validationErrors(v interface{}) []FieldError {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return []FieldError{{Field: "", Code: "invalid", Message: err.Error()}}
+	}
+
+	fieldErrors := make([]FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		// Drop the leading struct name, e.g. "User.user_profile.email" becomes "user_profile.email"
+		field := fe.Namespace()
+		if idx := strings.Index(field, "."); idx >= 0 {
+			field = field[idx+1:]
+		}
+		fieldErrors = append(fieldErrors, FieldError{Field: field, Code: fe.Tag(), Message: validationMessage(fe)})
+	}
+	return fieldErrors
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		return fmt.Sprintf("must be at most %s %s", fe.Param(), lengthUnit(fe))
+	case "min":
+		return fmt.Sprintf("must be at least %s %s", fe.Param(), lengthUnit(fe))
+	case "email":
+		return "must be a valid email address"
+	case "service_url":
+		return fmt.Sprintf("must be a valid URL using one of: %s", strings.Join(KongProtocols, ", "))
+	case "startswith":
+		return fmt.Sprintf("must start with %s", fe.Param())
+	case "webhook_url":
+		return "must be an absolute http or https URL"
+	case "webhook_event":
+		return fmt.Sprintf("must be one of: %s, a pattern like service.* or *", strings.Join(WebhookEventTypes, ", "))
+	case "unique":
+		return fmt.Sprintf("must not contain two items with the same %s", strings.ToLower(fe.Param()))
+	case "role":
+		return fmt.Sprintf("must be one of: %s", strings.Join(AllowedRoles, ", "))
+	default:
+		return fmt.Sprintf("failed the %s rule", fe.Tag())
+	}
+}
+
+// lengthUnit names what min and max count for the field: items of slices, arrays and maps, characters of strings.
+func lengthUnit(fe validator.FieldError) string {
+	unit := "character"
+	switch fe.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		unit = "item"
+	}
+	if fe.Param() != "1" {
+		unit += "s"
+	}
+	return unit
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// unknownFields lists the JSON object keys in raw that have no matching field in t.
+// Keys are matched case-insensitively, like encoding/json does.
+func unknownFields(raw []byte, t reflect.Type, prefix string) []FieldError {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			return nil
+		}
+		var fieldErrors []FieldError
+		for i, item := range items {
+			fieldErrors = append(fieldErrors, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", prefix, i))...)
+		}
+		return fieldErrors
+	case reflect.Struct:
+		var object map[string]json.RawMessage
+		if json.Unmarshal(raw, &object) != nil {
+			return nil
+		}
+		fields := jsonFields(t)
+		var fieldErrors []FieldError
+		for _, key := range sortedKeys(object) {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			field, ok := lookupJSONField(fields, key)
+			if !ok {
+				fieldErrors = append(fieldErrors, FieldError{Field: path, Code: CodeUnknownField, Message: "is not a known field"})
+				continue
+			}
+			fieldErrors = append(fieldErrors, unknownFields(object[key], field.Type, path)...)
+		}
+		return fieldErrors
+	default:
+		return nil
+	}
+}
+
+// jsonFields returns the struct fields of t keyed by JSON name, flattening embedded structs such as gorm.Model.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embeddedName, embedded := range jsonFields(field.Type) {
+				fields[embeddedName] = embedded
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func lookupJSONField(fields map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+	if field, ok := fields[key]; ok {
+		return field, true
+	}
+	for name, field := range fields {
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    interface{}
+		wantFields []string
+	}{
+		{"ValidService", &Service{ServiceName: "Service"}, nil},
+		{"EmptyServiceName", &Service{}, []string{"service_name"}},
+		{"InvalidVersionURL", &ServiceVersion{ServiceVersionName: "v1", ServiceVersionURL: "ftp://example.com"}, []string{"service_version_url"}},
+		{"ValidUserWithoutProfile", &User{Username: "user", Password: "password", Role: "user"}, nil},
+		{"InvalidUser", &User{Username: "user", Password: "short", Role: "root"}, []string{"password", "role"}},
+		{"InvalidProfileEmail", &User{Username: "user", Password: "password", Role: "user", UserProfile: UserProfile{Email: "nope"}}, []string{"user_profile.email"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, fe := range validationErrors(tt.payload) {
+				fields = append(fields, fe.Field)
+			}
+			assert.ElementsMatch(t, tt.wantFields, fields)
+		})
+	}
+}
+
+func TestValidationMessages(t *testing.T) {
+	payload := struct {
+		Name   string            `json:"name" validate:"max=3"`
+		Events []string          `json:"events" validate:"max=2"`
+		Labels map[string]string `json:"labels" validate:"min=1"`
+	}{Name: "long", Events: []string{"a", "b", "c"}, Labels: map[string]string{}}
+
+	messages := map[string]string{}
+	for _, fe := range validationErrors(&payload) {
+		messages[fe.Field] = fe.Message
+	}
+	assert.Equal(t, map[string]string{
+		"name":   "must be at most 3 characters",
+		"events": "must be at most 2 items",
+		"labels": "must be at least 1 item",
+	}, messages)
+}
+
+func TestDecodeJSONBodyReportsAllUnknownFields(t *testing.T) {
+	req, err := http.NewRequest("POST", "/v1/users", strings.NewReader(`{"username": "u", "admin": true, "user_profile": {"email": "a@b.c", "phone": "1"}}`))
+	assert.NoError(t, err)
+
+	var user User
+	rr := httptest.NewRecorder()
+	assert.False(t, decodeJSONBody(rr, req, &user))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"field":"admin"`)
+	assert.Contains(t, rr.Body.String(), `"field":"user_profile.phone"`)
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Catalog change events. User events are only streamed to admins and never sent to webhooks.
+const (
+	EventServiceCreated        = "service.created"
+	EventServiceUpdated        = "service.updated"
+	EventServiceDeleted        = "service.deleted"
+	EventServiceVersionCreated = "service_version.created"
+	EventServiceVersionUpdated = "service_version.updated"
+	EventServiceVersionDeleted = "service_version.deleted"
+	EventUserCreated           = "user.created"
+	EventUserUpdated           = "user.updated"
+	EventUserDeleted           = "user.deleted"
+)
+
+// WebhookEventTypes lists every event a webhook can subscribe to.
+var WebhookEventTypes = []string{
+	EventServiceCreated, EventServiceUpdated, EventServiceDeleted,
+	EventServiceVersionCreated, EventServiceVersionUpdated, EventServiceVersionDeleted,
+}
+
+// Delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Headers sent with every delivery.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// Retry policy of webhook deliveries and outbox entries: attempt n waits RetryBase * 2^(n-1), at most
+// RetryMax. A webhook delivery fails for good after MaxDeliveryAttempts.
+const (
+	RetryBase           = 10 * time.Second
+	RetryMax            = time.Hour
+	MaxDeliveryAttempts = 8
+)
+
+// DefaultWebhookPollInterval is how often the dispatcher looks for due retries when no event wakes it up.
+const DefaultWebhookPollInterval = 5 * time.Second
+
+// webhookBatchSize is the number of due deliveries claimed at a time.
+const webhookBatchSize = 50
+
+// webhookDispatcher is the running dispatcher, woken up when deliveries are queued.
+var webhookDispatcher *WebhookDispatcher
+
+// eventMatches reports whether a subscription pattern covers the event type.
+func eventMatches(pattern, eventType string) bool {
+	if pattern == "*" || pattern == eventType {
+		return true
+	}
+	prefix, isWildcard := strings.CutSuffix(pattern, "*")
+	return isWildcard && strings.HasSuffix(prefix, ".") && strings.HasPrefix(eventType, prefix)
+}
+
+// resourceEvent names the event for an action on a service, version or user, and reports false for other resources.
+func resourceEvent(resource interface{}, action string) (string, bool) {
+	switch resource.(type) {
+	case *Service:
+		return "service." + action, true
+	case *ServiceVersion:
+		return "service_version." + action, true
+	case *User:
+		return "user." + action, true
+	}
+	return "", false
+}
+
+// queueDeliveries queues a delivery of the event for every enabled webhook subscribed to it.
+// Webhooks that already have a delivery of the event are skipped, so relaying an event twice sends it once.
+func queueDeliveries(db *gorm.DB, event ChangeEvent, payload []byte) error {
+	var webhooks []Webhook
+	if err := db.Where("disabled = ?", false).
+		Where("id NOT IN (?)", db.Model(&WebhookDelivery{}).Select("webhook_id").Where("event_id = ?", event.ID)).
+		Find(&webhooks).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var deliveries []WebhookDelivery
+	for _, webhook := range webhooks {
+		if subscribed(webhook, event.Type) {
+			deliveries = append(deliveries, WebhookDelivery{
+				WebhookID:     webhook.ID,
+				EventID:       event.ID,
+				EventType:     event.Type,
+				Payload:       payload,
+				Status:        DeliveryPending,
+				NextAttemptAt: &now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return db.Create(&deliveries).Error
+}
+
+func subscribed(webhook Webhook, eventType string) bool {
+	if !slices.Contains(WebhookEventTypes, eventType) {
+		return false
+	}
+	for _, pattern := range webhook.Events {
+		if eventMatches(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhookPayload computes the X-Webhook-Signature header: "t=<unix time>,v1=<hex HMAC-SHA256>", where the
+// HMAC is keyed with the webhook secret and covers the timestamp, a dot and the body.
+func SignWebhookPayload(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix + "."))
+	mac.Write(body)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryBackoff is the wait after the given number of failed attempts.
+func retryBackoff(attempts int) time.Duration {
+	wait := RetryBase
+	for i := 1; i < attempts && wait < RetryMax; i++ {
+		wait *= 2
+	}
+	return min(wait, RetryMax)
+}
+
+// WebhookDispatcher sends queued deliveries, retrying failures with exponential backoff.
+//
+// Due deliveries are claimed with FOR UPDATE SKIP LOCKED and leased by moving their next attempt past the
+// client timeout, so several server instances can dispatch from the same table without sending twice.
+type WebhookDispatcher struct {
+	Client       *http.Client
+	PollInterval time.Duration
+
+	wake chan struct{}
+}
+
+// NewWebhookDispatcher returns a dispatcher polling every pollInterval and whenever Wake is called.
+func NewWebhookDispatcher(client *http.Client, pollInterval time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{Client: client, PollInterval: pollInterval, wake: make(chan struct{}, 1)}
+}
+
+// Wake makes the dispatcher look for due deliveries right away.
+func (d *WebhookDispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	lifecycle.WorkerStarted("webhook_dispatcher", d.PollInterval)
+	defer lifecycle.WorkerStopped("webhook_dispatcher")
+
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		_, err := d.DeliverDue(ctx)
+		if err != nil {
+			slog.Error("Webhook dispatch failed", "error", err)
+		}
+		lifecycle.WorkerRan("webhook_dispatcher", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue sends every delivery whose next attempt is due and returns how many were attempted.
+func (d *WebhookDispatcher) DeliverDue(ctx context.Context) (int, error) {
+	db := GetDBInstance().WithContext(ctx)
+	attempted := 0
+	for {
+		deliveries, err := d.claim(db)
+		if err != nil || len(deliveries) == 0 {
+			return attempted, err
+		}
+
+		var webhookIDs []uint
+		for _, delivery := range deliveries {
+			webhookIDs = append(webhookIDs, delivery.WebhookID)
+		}
+		var webhooks []Webhook
+		if err := db.Where("id IN ?", webhookIDs).Find(&webhooks).Error; err != nil {
+			return attempted, err
+		}
+		byID := make(map[uint]Webhook)
+		for _, webhook := range webhooks {
+			byID[webhook.ID] = webhook
+		}
+
+		for _, delivery := range deliveries {
+			webhook, ok := byID[delivery.WebhookID]
+			if !ok || webhook.Disabled {
+				delivery.Status = DeliveryFailed
+				delivery.NextAttemptAt = nil
+				delivery.LastError = "webhook was deleted or disabled"
+			} else {
+				d.attempt(ctx, webhook, &delivery)
+			}
+			if err := db.Save(&delivery).Error; err != nil {
+				return attempted, err
+			}
+			attempted++
+		}
+		if len(deliveries) < webhookBatchSize {
+			return attempted, nil
+		}
+	}
+}
+
+// claim locks a batch of due deliveries and leases them to this dispatcher.
+func (d *WebhookDispatcher) claim(db *gorm.DB) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+			Order("next_attempt_at").Limit(webhookBatchSize).
+			Find(&deliveries).Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		lease := now.Add(d.Client.Timeout + time.Minute)
+		return tx.Model(&WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", lease).Error
+	})
+	return deliveries, err
+}
+
+// attempt sends the delivery once and records the outcome on it.
+func (d *WebhookDispatcher) attempt(ctx context.Context, webhook Webhook, delivery *WebhookDelivery) {
+	delivery.Attempts++
+	delivery.LastStatusCode = 0
+	delivery.LastError = ""
+
+	err := d.send(ctx, webhook, delivery)
+	if err == nil {
+		now := time.Now()
+		delivery.Status = DeliverySucceeded
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= MaxDeliveryAttempts {
+		delivery.Status = DeliveryFailed
+		delivery.NextAttemptAt = nil
+		return
+	}
+	next := time.Now().Add(retryBackoff(delivery.Attempts))
+	delivery.NextAttemptAt = &next
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, webhook Webhook, delivery *WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kong-service-dashboard-webhooks")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	delivery.LastStatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// newWebhookSecret generates the signing secret of a webhook created without one.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validateWebhook validates a webhook payload, including the rules the validator tags cannot express.
+func validateWebhook(webhook *Webhook) []FieldError {
+	fieldErrors := validationErrors(webhook)
+	if webhook.Events != nil && len(webhook.Events) == 0 {
+		fieldErrors = append(fieldErrors, FieldError{Field: "events", Code: "required", Message: "is required"})
+	}
+	return fieldErrors
+}
+
+// GetWebhooks lists webhooks, or returns the one identified by ?id=. Secrets are never returned.
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	db := GetDBInstance().WithContext(r.Context())
+	opts, err := ParseResponseOptions(r, webhookResourceSpec)
+	if err != nil {
+		writeQueryProblem(w, r, err)
+		return
+	}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		var webhook Webhook
+		respond(w, r, opts, func() error {
+			err := db.First(&webhook, "id = ?", id).Error
+			webhook.Secret = ""
+			return err
+		}, &webhook)
+		return
+	}
+
+	var webhooks []Webhook
+	respond(w, r, opts, func() error {
+		err := db.Order("id").Find(&webhooks).Error
+		for i := range webhooks {
+			webhooks[i].Secret = ""
+		}
+		return err
+	}, &webhooks)
+}
+
+// CreateWebhook subscribes a URL to events. A secret is generated when none is given; the response is the
+// only place it is returned.
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook Webhook
+	if !decodeJSONBody(w, r, &webhook) {
+		return
+	}
+	if fieldErrors := validateWebhook(&webhook); len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	if webhook.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to create webhook")
+			requestLogger(r).Error("Error generating webhook secret", "error", err)
+			return
+		}
+		webhook.Secret = secret
+	}
+
+	if err := GetDBInstance().WithContext(r.Context()).Create(&webhook).Error; err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to create webhook")
+		requestLogger(r).Error("Error creating webhook", "error", err)
+		return
+	}
+
+	setJSONHeader(w)
+	w.Header().Set("ETag", webhook.ETag())
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// UpdateWebhook replaces a webhook. An empty secret keeps the current one.
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	db := GetDBInstance().WithContext(r.Context())
+
+	var webhook Webhook
+	if !decodeJSONBody(w, r, &webhook) {
+		return
+	}
+	if webhook.ID == 0 {
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeValidationFailed, "ID is required",
+			FieldError{Field: "id", Code: "required", Message: "is required"})
+		return
+	}
+
+	var existing Webhook
+	if db.First(&existing, webhook.ID).Error != nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Webhook not found")
+		return
+	}
+	if rej := checkIfMatch(r, &existing); rej != nil {
+		writeRejection(w, r, rej)
+		return
+	}
+	if fieldErrors := validateWebhook(&webhook); len(fieldErrors) > 0 {
+		writeValidationProblem(w, r, fieldErrors)
+		return
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret = existing.Secret
+	}
+	webhook.CreatedAt = existing.CreatedAt
+	if err := updateWithRevision(db, &webhook, existing.Revision); err != nil {
+		if errors.Is(err, errRevisionMismatch) {
+			writeRevisionMismatch(w, r)
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to update webhook")
+		requestLogger(r).Error("Error updating webhook", "error", err)
+		return
+	}
+
+	webhook.Secret = ""
+	setJSONHeader(w)
+	w.Header().Set("ETag", webhook.ETag())
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// DeleteWebhook deletes the webhook identified by ?id=. Its pending deliveries fail on their next attempt.
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	db := GetDBInstance().WithContext(r.Context())
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil || id == 0 {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "ID parameter is required")
+		return
+	}
+
+	var webhook Webhook
+	if db.First(&webhook, id).Error != nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
+		return
+	}
+	if rej := checkIfMatch(r, &webhook); rej != nil {
+		writeRejection(w, r, rej)
+		return
+	}
+
+	if err := deleteWithRevision(db, &webhook, webhook.Revision); err != nil {
+		if errors.Is(err, errRevisionMismatch) {
+			writeRevisionMismatch(w, r)
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to delete webhook")
+		requestLogger(r).Error("Error deleting webhook", "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetWebhookDeliveries returns the delivery log, newest first, filtered by ?webhook_id=, ?event_id= and
+// ?status=. ?limit= caps the number of deliveries, 100 by default and at most 500.
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	query := GetDBInstance().WithContext(r.Context()).Order("id DESC")
+	params := r.URL.Query()
+
+	limit := 100
+	if value := params.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 500 {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid limit parameter: must be between 1 and 500",
+				FieldError{Field: "limit", Code: CodeInvalidParameter, Message: "must be between 1 and 500"})
+			return
+		}
+		limit = parsed
+	}
+	if value := params.Get("webhook_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || id == 0 {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid webhook_id parameter: must be a positive integer",
+				FieldError{Field: "webhook_id", Code: CodeInvalidParameter, Message: "must be a positive integer"})
+			return
+		}
+		query = query.Where("webhook_id = ?", id)
+	}
+	if value := params.Get("event_id"); value != "" {
+		query = query.Where("event_id = ?", value)
+	}
+	if value := params.Get("status"); value != "" {
+		if value != DeliveryPending && value != DeliverySucceeded && value != DeliveryFailed {
+			writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid status parameter",
+				FieldError{Field: "status", Code: CodeInvalidParameter, Message: fmt.Sprintf("must be one of: %s, %s, %s", DeliveryPending, DeliverySucceeded, DeliveryFailed)})
+			return
+		}
+		query = query.Where("status = ?", value)
+	}
+
+	deliveries := []WebhookDelivery{}
+	respond(w, r, ResponseOptions{}, func() error {
+		return query.Limit(limit).Find(&deliveries).Error
+	}, &deliveries)
+}
+
+// RedeliverWebhook queues the delivery identified by ?id= again, as a new delivery of the same event.
+func RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	db := GetDBInstance().WithContext(r.Context())
+
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 32)
+	if err != nil || id == 0 {
+		writeProblem(w, r, http.StatusBadRequest, CodeInvalidParameter, "ID parameter is required")
+		return
+	}
+
+	var original WebhookDelivery
+	if db.First(&original, id).Error != nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Resource not found")
+		return
+	}
+	var webhook Webhook
+	if db.First(&webhook, original.WebhookID).Error != nil {
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "Webhook not found")
+		return
+	}
+
+	now := time.Now()
+	delivery := WebhookDelivery{
+		WebhookID:     original.WebhookID,
+		EventID:       original.EventID,
+		EventType:     original.EventType,
+		Payload:       original.Payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: &now,
+	}
+	if err := db.Create(&delivery).Error; err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "Failed to queue delivery")
+		requestLogger(r).Error("Error queueing delivery", "error", err)
+		return
+	}
+	if webhookDispatcher != nil {
+		webhookDispatcher.Wake()
+	}
+
+	setJSONHeader(w)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventMatches(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		eventType string
+		matches   bool
+	}{
+		{"*", EventServiceVersionDeleted, true},
+		{EventServiceCreated, EventServiceCreated, true},
+		{EventServiceCreated, EventServiceUpdated, false},
+		{"service.*", EventServiceDeleted, true},
+		{"service.*", EventServiceVersionCreated, false},
+		{"service_version.*", EventServiceVersionCreated, true},
+		{"service*", EventServiceCreated, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.eventType, func(t *testing.T) {
+			assert.Equal(t, tt.matches, eventMatches(tt.pattern, tt.eventType))
+		})
+	}
+}
+
+func TestWebhookValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		webhook Webhook
+		fields  []string
+	}{
+		{"Valid", Webhook{URL: "https://docs.internal/hooks", Events: StringList{"service.*", EventServiceVersionCreated}}, nil},
+		{"MissingFields", Webhook{}, []string{"url", "events"}},
+		{"EmptyEvents", Webhook{URL: "https://docs.internal/hooks", Events: StringList{}}, []string{"events"}},
+		{"UnknownEvent", Webhook{URL: "https://docs.internal/hooks", Events: StringList{"user.created"}}, []string{"events[0]"}},
+		{"NotHTTP", Webhook{URL: "ftp://docs.internal", Events: StringList{"*"}}, []string{"url"}},
+		{"ShortSecret", Webhook{URL: "https://docs.internal/hooks", Events: StringList{"*"}, Secret: "short"}, []string{"secret"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, fe := range validateWebhook(&tt.webhook) {
+				fields = append(fields, fe.Field)
+			}
+			assert.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	timestamp := time.Unix(1714564800, 0)
+	signature := SignWebhookPayload("secret", timestamp, []byte(`{"id":"1"}`))
+
+	assert.True(t, strings.HasPrefix(signature, "t=1714564800,v1="))
+	assert.Len(t, strings.TrimPrefix(signature, "t=1714564800,v1="), 64)
+	assert.Equal(t, signature, SignWebhookPayload("secret", timestamp, []byte(`{"id":"1"}`)))
+	assert.NotEqual(t, signature, SignWebhookPayload("other", timestamp, []byte(`{"id":"1"}`)))
+	assert.NotEqual(t, signature, SignWebhookPayload("secret", timestamp, []byte(`{"id":"2"}`)))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, RetryBase, retryBackoff(1))
+	assert.Equal(t, 2*RetryBase, retryBackoff(2))
+	assert.Equal(t, 8*RetryBase, retryBackoff(4))
+	assert.Equal(t, RetryMax, retryBackoff(20))
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var fail atomic.Bool
+	var received atomic.Int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := strings.TrimPrefix(strings.SplitN(r.Header.Get(WebhookSignatureHeader), ",", 2)[0], "t=")
+		var unix int64
+		fmt.Sscan(timestamp, &unix)
+		if r.Header.Get(WebhookSignatureHeader) != SignWebhookPayload("0123456789abcdef", time.Unix(unix, 0), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer receiver.Close()
+
+	// Subscribe to service events only
+	req := httptest.NewRequest("POST", "/v1/webhooks", strings.NewReader(fmt.Sprintf(`{"url": %q, "events": ["service.*"], "secret": "0123456789abcdef"}`, receiver.URL)))
+	rr := httptest.NewRecorder()
+	CreateWebhook(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	var webhook Webhook
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &webhook))
+	defer GetDBInstance().Delete(&webhook)
+
+	// Secrets are only returned on creation
+	req = httptest.NewRequest("GET", fmt.Sprintf("/v1/webhooks?id=%d", webhook.ID), nil)
+	rr = httptest.NewRecorder()
+	GetWebhooks(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "0123456789abcdef")
+
+	req = httptest.NewRequest("POST", "/v1/services", strings.NewReader(`{"service_name": "Webhook Service"}`))
+	rr = httptest.NewRecorder()
+	CreateService(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+
+	// The relay queues the delivery from the outbox, then the dispatcher sends it
+	dispatcher := NewWebhookDispatcher(&http.Client{Timeout: time.Second}, time.Minute)
+	relay := &OutboxRelay{Sinks: []OutboxSink{WebhookSink{Dispatcher: dispatcher}}, Retention: time.Hour}
+	_, err := relay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	_, err = dispatcher.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), received.Load())
+
+	var delivery WebhookDelivery
+	db := GetDBInstance()
+	assert.NoError(t, db.Where("webhook_id = ?", webhook.ID).First(&delivery).Error)
+	assert.Equal(t, DeliverySucceeded, delivery.Status)
+	assert.Equal(t, EventServiceCreated, delivery.EventType)
+	assert.Contains(t, string(delivery.Payload), "Webhook Service")
+
+	// A failed redelivery is retried later
+	fail.Store(true)
+	req = httptest.NewRequest("POST", fmt.Sprintf("/v1/webhooks/deliveries/redeliver?id=%d", delivery.ID), nil)
+	rr = httptest.NewRecorder()
+	RedeliverWebhook(rr, req)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	var redelivery WebhookDelivery
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &redelivery))
+	assert.Equal(t, delivery.EventID, redelivery.EventID)
+
+	_, err = dispatcher.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, db.First(&redelivery, redelivery.ID).Error)
+	assert.Equal(t, DeliveryPending, redelivery.Status)
+	assert.Equal(t, 1, redelivery.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, redelivery.LastStatusCode)
+	assert.WithinDuration(t, time.Now().Add(RetryBase), *redelivery.NextAttemptAt, 5*time.Second)
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/v1/webhooks/deliveries?webhook_id=%d&status=pending", webhook.ID), nil)
+	rr = httptest.NewRecorder()
+	GetWebhookDeliveries(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var deliveries []WebhookDelivery
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &deliveries))
+	assert.Len(t, deliveries, 1)
+}
+
+func TestGetWebhookDeliveriesInvalidParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		field string
+	}{
+		{"WebhookIDNotANumber", "?webhook_id=abc", "webhook_id"},
+		{"WebhookIDZero", "?webhook_id=0", "webhook_id"},
+		{"LimitTooLarge", "?limit=501", "limit"},
+		{"UnknownStatus", "?status=lost", "status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/webhooks/deliveries"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			GetWebhookDeliveries(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			var problem Problem
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+			assert.Equal(t, CodeInvalidParameter, problem.Code)
+			assert.Equal(t, tt.field, problem.Errors[0].Field)
+		})
+	}
+}
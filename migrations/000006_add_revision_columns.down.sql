@@ -0,0 +1,3 @@
+ALTER TABLE "services" DROP COLUMN IF EXISTS revision;
+ALTER TABLE "service_versions" DROP COLUMN IF EXISTS revision;
+ALTER TABLE "users" DROP COLUMN IF EXISTS revision;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE "services" ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE "service_versions" ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1;
\ No newline at end of file
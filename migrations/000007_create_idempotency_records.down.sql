@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "idempotency_records";
\ No newline at end of file
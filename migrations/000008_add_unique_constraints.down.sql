@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "idx_service_versions_service_id_name";
+ALTER TABLE "services" DROP CONSTRAINT IF EXISTS uni_services_service_name;
\ No newline at end of file
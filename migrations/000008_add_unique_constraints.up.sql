@@ -0,0 +1,11 @@
+DO $$
+BEGIN
+    -- GORM may already have added this constraint from the model tag
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'uni_services_service_name') THEN
+        ALTER TABLE "services" ADD CONSTRAINT uni_services_service_name UNIQUE (service_name);
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_service_versions_service_id_name
+ON service_versions(service_id, service_version_name)
+WHERE deleted_at IS NULL;
\ No newline at end of file
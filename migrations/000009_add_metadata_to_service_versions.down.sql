@@ -0,0 +1,2 @@
+ALTER TABLE "service_versions"
+DROP COLUMN IF EXISTS metadata;
\ No newline at end of file
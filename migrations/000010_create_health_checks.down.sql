@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS "health_checks";
+
+ALTER TABLE "service_versions"
+DROP COLUMN IF EXISTS health_path;
\ No newline at end of file
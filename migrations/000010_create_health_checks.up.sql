@@ -0,0 +1,15 @@
+ALTER TABLE "service_versions"
+ADD COLUMN IF NOT EXISTS health_path TEXT;
+
+CREATE TABLE IF NOT EXISTS "health_checks" (
+    id BIGSERIAL PRIMARY KEY,
+    service_version_id BIGINT NOT NULL,
+    checked_at TIMESTAMPTZ NOT NULL,
+    healthy BOOLEAN NOT NULL,
+    status_code BIGINT,
+    latency_ms BIGINT,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_health_checks_version_checked_at ON health_checks(service_version_id, checked_at);
+CREATE INDEX IF NOT EXISTS idx_health_checks_checked_at ON health_checks(checked_at);
\ No newline at end of file
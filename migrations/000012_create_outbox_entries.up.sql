@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS "outbox_entries" (
+    id BIGSERIAL PRIMARY KEY,
+    event_id VARCHAR(64) NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL,
+    published_to JSONB NOT NULL,
+    attempts BIGINT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    last_error TEXT,
+    delivered_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_outbox_entries_event_id ON outbox_entries(event_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_entries_next_attempt_at ON outbox_entries(next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_outbox_entries_delivered_at ON outbox_entries(delivered_at);
\ No newline at end of file
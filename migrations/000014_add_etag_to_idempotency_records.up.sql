@@ -0,0 +1,2 @@
+ALTER TABLE "idempotency_records"
+ADD COLUMN IF NOT EXISTS etag VARCHAR(255);